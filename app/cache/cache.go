@@ -1,69 +1,810 @@
 package cache
 
 import (
+	"cmp"
+	"hash/maphash"
+	"math"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/memory"
+)
+
+// Policies picking the keys evicted when the cache uses too much memory.
+// "volatile" policies only consider keys with an expiry.
+const (
+	POLICY_NOEVICTION      = "noeviction"
+	POLICY_ALLKEYS_LRU     = "allkeys-lru"
+	POLICY_VOLATILE_LRU    = "volatile-lru"
+	POLICY_ALLKEYS_LFU     = "allkeys-lfu"
+	POLICY_VOLATILE_LFU    = "volatile-lfu"
+	POLICY_ALLKEYS_RANDOM  = "allkeys-random"
+	POLICY_VOLATILE_RANDOM = "volatile-random"
+	POLICY_VOLATILE_TTL    = "volatile-ttl"
+)
+
+const (
+	// The number of shards the keyspace is split in. Every shard has its own
+	// lock, so goroutines accessing different keys rarely wait for each other.
+	SHARD_COUNT = 64
+	// The number of keys with an expiry ExpireCycle checks at a time in
+	// every shard.
+	ACTIVE_EXPIRE_SAMPLES = 20
+	// The number of eviction candidates kept between evictions.
+	EVICTION_POOL_SIZE = 16
+	// The access frequency counter of new items, so they are not evicted
+	// before having a chance to be accessed.
+	LFU_INIT_VAL = 5
+	// How hard it is for the frequency counter to grow, as Redis'
+	// "lfu-log-factor" option.
+	LFU_LOG_FACTOR = 10
+	// The frequency counter is decremented once per period without access,
+	// as Redis' "lfu-decay-time" option.
+	LFU_DECAY_TIME = time.Minute
+)
+
+// Events the cache reports to its notifier, as the names of the keyspace
+// notifications Redis sends for them.
+const (
+	// A key was added.
+	EVENT_NEW = "new"
+	// A key was read but does not exist.
+	EVENT_KEY_MISS = "keymiss"
+	EVENT_EXPIRED  = "expired"
+	EVENT_EVICTED  = "evicted"
 )
 
 type item struct {
 	value  any
 	expiry time.Time
+	// size is the estimated memory used by the item.
+	size int64
+	// accessTime is when the item was last read or written.
+	accessTime time.Time
+	// freq is a logarithmic counter of the accesses to the item.
+	freq uint8
 }
 
-type Cache struct {
-	items map[string]item
+// ItemInfo describes how an item is stored.
+type ItemInfo struct {
+	Value  any
+	Expiry time.Time
+	// Size is the estimated memory used by the item.
+	Size int64
+	// Idle is the time elapsed since the item was last accessed.
+	Idle time.Duration
+	// Freq is the logarithmic access frequency counter of the item.
+	Freq int
+}
+
+// poolEntry is an eviction candidate. Entries with a higher idle score are
+// evicted first.
+type poolEntry struct {
+	key  string
+	idle uint64
+}
+
+// shard holds the items of the keys hashing to it.
+type shard struct {
 	mu    sync.Mutex
+	items map[string]item
+	// expires holds the keys of the items with an expiry.
+	expires map[string]struct{}
+	// Counters of the lookups performed through GetItem and of the items
+	// removed because they expired or were evicted.
+	evictedItems int
+	expiredItems int
+	hits         int
+	misses       int
+}
+
+// Cache is the keyspace. Operations on a single key only lock the shard of
+// that key, operations on several keys go through Update.
+type Cache struct {
+	shards []*shard
+	seed   maphash.Seed
+	// usedMemory is the estimated memory used by all the items.
+	usedMemory atomic.Int64
+	// evictMu guards the eviction pool, which holds the best eviction
+	// candidates sampled so far by increasing idle score.
+	evictMu sync.Mutex
+	pool    []poolEntry
+	// notify is called for every event of the cache, see SetNotifier.
+	notify func(event, key string)
+}
+
+// Stats describes the contents and use of a cache.
+type Stats struct {
+	Items         int
+	ExpiringItems int
+	// AvgTTL is the average time to live of the items with an expiry.
+	AvgTTL       time.Duration
+	EvictedItems int
+	ExpiredItems int
+	Hits         int
+	Misses       int
+	UsedMemory   int64
 }
 
 func NewCache() *Cache {
-	return &Cache{
-		items: map[string]item{},
+	return newCache(SHARD_COUNT)
+}
+
+func newCache(shardCount int) *Cache {
+	ch := &Cache{
+		shards: make([]*shard, shardCount),
+		seed:   maphash.MakeSeed(),
+	}
+
+	for index := range ch.shards {
+		ch.shards[index] = &shard{
+			items:   map[string]item{},
+			expires: map[string]struct{}{},
+		}
+	}
+
+	return ch
+}
+
+// SetNotifier makes the cache call "notify" with the EVENT_* events of its
+// keys. It must be called before the cache is used. "notify" is called with
+// the lock of the key's shard held, so it must not call the cache.
+func (ch *Cache) SetNotifier(notify func(event, key string)) {
+	ch.notify = notify
+}
+
+func (ch *Cache) emit(event, key string) {
+	if ch.notify != nil {
+		ch.notify(event, key)
 	}
 }
 
-func (i *item) GetTTL() time.Time {
-	return i.expiry
+func (ch *Cache) shardIndex(key string) int {
+	return int(maphash.String(ch.seed, key) % uint64(len(ch.shards)))
+}
+
+func (ch *Cache) shard(key string) *shard {
+	return ch.shards[ch.shardIndex(key)]
+}
+
+// lockAll locks every shard. Shards are always locked by increasing index,
+// so that it cannot deadlock with Update.
+func (ch *Cache) lockAll() {
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+	}
+}
+
+func (ch *Cache) unlockAll() {
+	for _, sh := range ch.shards {
+		sh.mu.Unlock()
+	}
 }
 
 func (ch *Cache) GetItem(key string) any {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
 
-	item, ok := ch.items[key]
+	return ch.getItem(sh, key)
+}
+
+// getItem returns the value of "key", counting as an access to it. It must
+// be called with sh.mu held.
+func (ch *Cache) getItem(sh *shard, key string) any {
+	item, ok := ch.lookup(sh, key)
 
 	if !ok {
+		sh.misses += 1
+		ch.emit(EVENT_KEY_MISS, key)
+
 		return nil
 	}
 
-	if item.expiry.IsZero() {
-		return item.value
+	sh.hits += 1
+	touch(sh, key, item)
+
+	return item.value
+}
+
+// lookup returns the item of "key", removing it if it expired. It must be
+// called with sh.mu held.
+func (ch *Cache) lookup(sh *shard, key string) (item, bool) {
+	item, ok := sh.items[key]
+
+	if !ok {
+		return item, false
 	}
 
-	if item.expiry.Before(time.Now()) {
-		delete(ch.items, key)
-		return nil
+	if !item.expiry.IsZero() && item.expiry.Before(time.Now()) {
+		ch.remove(sh, key)
+		sh.expiredItems += 1
+		ch.emit(EVENT_EXPIRED, key)
+
+		return item, false
 	}
 
-	return item.value
+	return item, true
+}
+
+// Inspect returns how the item of "key" is stored, without counting as an
+// access to it. It reports false when there is no such item.
+func (ch *Cache) Inspect(key string) (ItemInfo, bool) {
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return ch.inspect(sh, key)
 }
 
-func (ch *Cache) GetItems() map[string]item {
-	return ch.items
+// inspect must be called with sh.mu held.
+func (ch *Cache) inspect(sh *shard, key string) (ItemInfo, bool) {
+	item, ok := ch.lookup(sh, key)
+
+	if !ok {
+		return ItemInfo{}, false
+	}
+
+	return item.info(time.Now()), true
+}
+
+func (item item) info(now time.Time) ItemInfo {
+	return ItemInfo{
+		Value:  item.value,
+		Expiry: item.expiry,
+		Size:   item.size,
+		Idle:   now.Sub(item.accessTime),
+		Freq:   int(decayedFreq(item, now)),
+	}
+}
+
+// Range calls "fn" for every item, expired or not, until it returns false.
+// Shards are visited one at a time with their lock held, so "fn" must not
+// call the cache. Range a Snapshot to see the items at a single point in
+// time.
+func (ch *Cache) Range(fn func(key string, value any, expiry time.Time) bool) {
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+
+		for key, item := range sh.items {
+			if !fn(key, item.value, item.expiry) {
+				sh.mu.Unlock()
+				return
+			}
+		}
+
+		sh.mu.Unlock()
+	}
+}
+
+// RangeInfo is like Range, but describes every item like Inspect does.
+func (ch *Cache) RangeInfo(fn func(key string, info ItemInfo) bool) {
+	now := time.Now()
+
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+
+		for key, item := range sh.items {
+			if !fn(key, item.info(now)) {
+				sh.mu.Unlock()
+				return
+			}
+		}
+
+		sh.mu.Unlock()
+	}
+}
+
+// Keys returns the keys of the items that did not expire.
+func (ch *Cache) Keys() []string {
+	keys := []string{}
+	now := time.Now()
+
+	ch.Range(func(key string, value any, expiry time.Time) bool {
+		if expiry.IsZero() || expiry.After(now) {
+			keys = append(keys, key)
+		}
+
+		return true
+	})
+
+	return keys
 }
 
 func (ch *Cache) SetItem(key string, value any, expiry time.Time) {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	ch.set(sh, key, value, expiry)
+}
+
+// RestoreItem stores an item loaded from a snapshot, along with the time
+// since it was last accessed and its access frequency counter, so eviction
+// treats it as it did before the snapshot was taken. A negative "idle" or
+// "freq" is unknown, and left to its default like SetItem does.
+func (ch *Cache) RestoreItem(key string, value any, expiry time.Time, idle time.Duration, freq int) {
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	ch.set(sh, key, value, expiry)
+	item := sh.items[key]
+
+	if idle >= 0 {
+		item.accessTime = item.accessTime.Add(-idle)
+	}
+
+	if freq >= 0 {
+		item.freq = uint8(min(freq, math.MaxUint8))
+	}
+
+	sh.items[key] = item
+}
+
+// set stores an item. It must be called with sh.mu held.
+func (ch *Cache) set(sh *shard, key string, value any, expiry time.Time) {
+	_, exists := ch.lookup(sh, key)
+	ch.remove(sh, key)
+
+	item := item{
+		value:      value,
+		expiry:     expiry,
+		size:       memory.Usage(key, value, !expiry.IsZero()),
+		accessTime: time.Now(),
+		freq:       LFU_INIT_VAL,
+	}
+
+	sh.items[key] = item
+	ch.usedMemory.Add(item.size)
+
+	if !expiry.IsZero() {
+		sh.expires[key] = struct{}{}
+	}
+
+	if !exists {
+		ch.emit(EVENT_NEW, key)
+	}
+}
+
+// touch records an access to an item. It must be called with sh.mu held.
+func touch(sh *shard, key string, item item) {
+	now := time.Now()
+	item.freq = decayedFreq(item, now)
+
+	// The counter grows logarithmically, so it can count accesses in the
+	// millions with 8 bits.
+	if item.freq < math.MaxUint8 {
+		base := max(float64(item.freq)-LFU_INIT_VAL, 0)
+
+		if rand.Float64() < 1/(base*LFU_LOG_FACTOR+1) {
+			item.freq += 1
+		}
+	}
+
+	item.accessTime = now
+	sh.items[key] = item
+}
+
+// decayedFreq returns the access frequency counter of an item, decremented
+// for every LFU_DECAY_TIME period elapsed since it was last accessed.
+func decayedFreq(item item, now time.Time) uint8 {
+	periods := int64(now.Sub(item.accessTime) / LFU_DECAY_TIME)
+
+	if periods >= int64(item.freq) {
+		return 0
+	}
+
+	return item.freq - uint8(periods)
+}
+
+// Tx gives the function run by Update access to the keys it locked.
+type Tx struct {
+	ch *Cache
+	// locked holds the indexes of the locked shards, in increasing order.
+	locked []int
+}
+
+func (tx *Tx) shard(key string) *shard {
+	index := tx.ch.shardIndex(key)
+
+	if _, found := slices.BinarySearch(tx.locked, index); !found {
+		panic("cache: key \"" + key + "\" was not locked by Update")
+	}
+
+	return tx.ch.shards[index]
+}
+
+// Get returns the value and expiry of "key", without counting as an access
+// to it. It reports false when there is no such item.
+func (tx *Tx) Get(key string) (any, time.Time, bool) {
+	item, ok := tx.ch.lookup(tx.shard(key), key)
+	return item.value, item.expiry, ok
+}
+
+// GetItem returns the value of "key" like Cache.GetItem.
+func (tx *Tx) GetItem(key string) any {
+	return tx.ch.getItem(tx.shard(key), key)
+}
+
+// Inspect returns how the item of "key" is stored like Cache.Inspect.
+func (tx *Tx) Inspect(key string) (ItemInfo, bool) {
+	return tx.ch.inspect(tx.shard(key), key)
+}
+
+func (tx *Tx) SetItem(key string, value any, expiry time.Time) {
+	tx.ch.set(tx.shard(key), key, value, expiry)
+}
 
-	ch.items[key] = item{value: value, expiry: expiry}
+// RemoveItem removes an item like Cache.RemoveItem.
+func (tx *Tx) RemoveItem(key string) bool {
+	return tx.ch.removeItem(tx.shard(key), key)
+}
+
+// Update runs "fn" with the shards of "keys" locked, so other goroutines see
+// either none or all of its changes. "fn" may only access "keys", through
+// the Tx it is given.
+func (ch *Cache) Update(keys []string, fn func(tx *Tx)) {
+	tx := &Tx{ch: ch}
+
+	for _, key := range keys {
+		tx.locked = append(tx.locked, ch.shardIndex(key))
+	}
+
+	slices.Sort(tx.locked)
+	tx.locked = slices.Compact(tx.locked)
+
+	for _, index := range tx.locked {
+		ch.shards[index].mu.Lock()
+	}
+
+	defer func() {
+		for _, index := range tx.locked {
+			ch.shards[index].mu.Unlock()
+		}
+	}()
+
+	fn(tx)
 }
 
 func (ch *Cache) Size() int {
-	return len(ch.items)
+	size := 0
+
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+		size += len(sh.items)
+		sh.mu.Unlock()
+	}
+
+	return size
+}
+
+// UsedMemory returns the estimated memory used by the items of the cache.
+func (ch *Cache) UsedMemory() int64 {
+	return ch.usedMemory.Load()
+}
+
+func (ch *Cache) Stats() Stats {
+	stats := Stats{}
+	now := time.Now()
+	var totalTTL time.Duration
+
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+
+		stats.Items += len(sh.items)
+		stats.ExpiringItems += len(sh.expires)
+		stats.EvictedItems += sh.evictedItems
+		stats.ExpiredItems += sh.expiredItems
+		stats.Hits += sh.hits
+		stats.Misses += sh.misses
+
+		for key := range sh.expires {
+			totalTTL += max(sh.items[key].expiry.Sub(now), 0)
+		}
+
+		sh.mu.Unlock()
+	}
+
+	stats.UsedMemory = ch.UsedMemory()
+
+	if stats.ExpiringItems > 0 {
+		stats.AvgTTL = totalTTL / time.Duration(stats.ExpiringItems)
+	}
+
+	return stats
+}
+
+// Evict removes the item "policy" picks and returns its key. It reports
+// false when there is no item the policy may evict.
+//
+// Like Redis, it approximates the policy rather than tracking the exact
+// order of the items: it samples "samples" keys and adds them to a pool of
+// the best candidates seen so far, evicting the best of the pool.
+func (ch *Cache) Evict(policy string, samples int) (string, bool) {
+	ch.evictMu.Lock()
+	defer ch.evictMu.Unlock()
+
+	volatile := policy == POLICY_VOLATILE_LRU || policy == POLICY_VOLATILE_LFU ||
+		policy == POLICY_VOLATILE_RANDOM || policy == POLICY_VOLATILE_TTL
+
+	switch policy {
+	case POLICY_ALLKEYS_RANDOM, POLICY_VOLATILE_RANDOM:
+		// The sampled key may be removed before it is locked again.
+		for {
+			candidates := ch.sample(policy, volatile, 1)
+
+			if len(candidates) == 0 {
+				return "", false
+			}
+
+			if ch.evict(candidates[0].key, volatile) {
+				return candidates[0].key, true
+			}
+		}
+
+	case POLICY_ALLKEYS_LRU, POLICY_VOLATILE_LRU, POLICY_ALLKEYS_LFU, POLICY_VOLATILE_LFU, POLICY_VOLATILE_TTL:
+		return ch.evictFromPool(policy, volatile, samples)
+	}
+
+	return "", false
+}
+
+// evict removes an item picked for eviction. It reports false when the item
+// no longer exists or, when "volatile" is set, no longer has an expiry.
+func (ch *Cache) evict(key string, volatile bool) bool {
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	_, exists := sh.items[key]
+	_, expires := sh.expires[key]
+
+	if !exists || (volatile && !expires) {
+		return false
+	}
+
+	ch.remove(sh, key)
+	sh.evictedItems += 1
+	ch.emit(EVENT_EVICTED, key)
+
+	return true
+}
+
+// ExpireCycle removes expired items that were not accessed since they
+// expired, and returns their number. Like Redis, it checks a sample of the
+// keys with an expiry of every shard, sampling again as long as more than a
+// quarter of them expired.
+func (ch *Cache) ExpireCycle() int {
+	expired := 0
+	now := time.Now()
+
+	for _, sh := range ch.shards {
+		sh.mu.Lock()
+
+		for {
+			sampled, removed := 0, 0
+
+			for key := range sh.expires {
+				if sampled >= ACTIVE_EXPIRE_SAMPLES {
+					break
+				}
+
+				sampled += 1
+
+				if sh.items[key].expiry.Before(now) {
+					ch.remove(sh, key)
+					sh.expiredItems += 1
+					ch.emit(EVENT_EXPIRED, key)
+					removed += 1
+				}
+			}
+
+			expired += removed
+
+			if removed*4 <= sampled {
+				break
+			}
+		}
+
+		sh.mu.Unlock()
+	}
+
+	return expired
 }
 
-func (ch *Cache) RemoveItem(key string) {
-	ch.mu.Lock()
-	defer ch.mu.Unlock()
-	delete(ch.items, key)
+// sample returns up to "n" keys with their idle score, only picking keys
+// with an expiry when "volatile" is set. Sampling starts from a random
+// shard, and map iteration at a random position, so the keys are a random
+// sample.
+func (ch *Cache) sample(policy string, volatile bool, n int) []poolEntry {
+	candidates := make([]poolEntry, 0, n)
+	now := time.Now()
+	start := rand.IntN(len(ch.shards))
+
+	for offset := range ch.shards {
+		if len(candidates) >= n {
+			break
+		}
+
+		sh := ch.shards[(start+offset)%len(ch.shards)]
+		sh.mu.Lock()
+
+		if volatile {
+			for key := range sh.expires {
+				if len(candidates) >= n {
+					break
+				}
+
+				candidates = append(candidates, poolEntry{key: key, idle: idleScore(policy, sh.items[key], now)})
+			}
+		} else {
+			for key, item := range sh.items {
+				if len(candidates) >= n {
+					break
+				}
+
+				candidates = append(candidates, poolEntry{key: key, idle: idleScore(policy, item, now)})
+			}
+		}
+
+		sh.mu.Unlock()
+	}
+
+	return candidates
+}
+
+// idleScore returns how good an eviction candidate an item is for "policy",
+// items with a higher score being evicted first.
+func idleScore(policy string, item item, now time.Time) uint64 {
+	switch policy {
+	case POLICY_ALLKEYS_LRU, POLICY_VOLATILE_LRU:
+		return uint64(max(now.Sub(item.accessTime).Milliseconds(), 0))
+
+	case POLICY_ALLKEYS_LFU, POLICY_VOLATILE_LFU:
+		return math.MaxUint8 - uint64(decayedFreq(item, now))
+
+	case POLICY_VOLATILE_TTL:
+		// Keys expiring first are evicted first.
+		return math.MaxUint64 - uint64(item.expiry.UnixMilli())
+	}
+
+	return 0
+}
+
+// evictFromPool samples keys into the eviction pool and evicts the best
+// candidate that still exists. It must be called with ch.evictMu held.
+func (ch *Cache) evictFromPool(policy string, volatile bool, samples int) (string, bool) {
+	for {
+		candidates := ch.sample(policy, volatile, max(samples, 1))
+
+		if len(candidates) == 0 {
+			return "", false
+		}
+
+		ch.populatePool(candidates)
+
+		// Candidates may have been removed or lost their expiry since they
+		// were sampled.
+		for len(ch.pool) > 0 {
+			best := ch.pool[len(ch.pool)-1]
+			ch.pool = ch.pool[:len(ch.pool)-1]
+
+			if ch.evict(best.key, volatile) {
+				return best.key, true
+			}
+		}
+	}
+}
+
+// populatePool adds sampled candidates to the eviction pool, keeping the
+// EVICTION_POOL_SIZE candidates with the highest idle score.
+func (ch *Cache) populatePool(candidates []poolEntry) {
+	for _, candidate := range candidates {
+		if slices.ContainsFunc(ch.pool, func(entry poolEntry) bool { return entry.key == candidate.key }) {
+			continue
+		}
+
+		index, _ := slices.BinarySearchFunc(ch.pool, candidate.idle, func(entry poolEntry, idle uint64) int {
+			return cmp.Compare(entry.idle, idle)
+		})
+
+		// The pool is full of better candidates.
+		if index == 0 && len(ch.pool) >= EVICTION_POOL_SIZE {
+			continue
+		}
+
+		ch.pool = slices.Insert(ch.pool, index, candidate)
+
+		if len(ch.pool) > EVICTION_POOL_SIZE {
+			ch.pool = ch.pool[1:]
+		}
+	}
+}
+
+// Snapshot returns a copy of the cache holding its items at the current
+// point in time. Values are shared with the cache, so they must not be
+// modified in place.
+func (ch *Cache) Snapshot() *Cache {
+	ch.lockAll()
+	defer ch.unlockAll()
+
+	snapshot := &Cache{
+		shards: make([]*shard, len(ch.shards)),
+		seed:   ch.seed,
+	}
+
+	for index, sh := range ch.shards {
+		copied := &shard{
+			items:   make(map[string]item, len(sh.items)),
+			expires: make(map[string]struct{}, len(sh.expires)),
+		}
+
+		for key, item := range sh.items {
+			copied.items[key] = item
+		}
+
+		for key := range sh.expires {
+			copied.expires[key] = struct{}{}
+		}
+
+		snapshot.shards[index] = copied
+	}
+
+	snapshot.usedMemory.Store(ch.usedMemory.Load())
+
+	return snapshot
+}
+
+// Clear removes every item from the cache.
+func (ch *Cache) Clear() {
+	ch.evictMu.Lock()
+	ch.pool = nil
+	ch.evictMu.Unlock()
+
+	ch.lockAll()
+	defer ch.unlockAll()
+
+	for _, sh := range ch.shards {
+		for _, item := range sh.items {
+			ch.usedMemory.Add(-item.size)
+		}
+
+		sh.items = map[string]item{}
+		sh.expires = map[string]struct{}{}
+	}
+}
+
+// RemoveItem removes an item, reporting whether it existed.
+func (ch *Cache) RemoveItem(key string) bool {
+	sh := ch.shard(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return ch.removeItem(sh, key)
+}
+
+// removeItem must be called with sh.mu held.
+func (ch *Cache) removeItem(sh *shard, key string) bool {
+	_, ok := ch.lookup(sh, key)
+	ch.remove(sh, key)
+
+	return ok
+}
+
+// remove deletes an item and its accounting. It must be called with sh.mu
+// held.
+func (ch *Cache) remove(sh *shard, key string) {
+	item, ok := sh.items[key]
+
+	if !ok {
+		return
+	}
+
+	delete(sh.items, key)
+	delete(sh.expires, key)
+	ch.usedMemory.Add(-item.size)
 }
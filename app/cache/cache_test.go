@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRestoreItem(t *testing.T) {
+	tests := []struct {
+		name     string
+		idle     time.Duration
+		freq     int
+		wantIdle time.Duration
+		wantFreq int
+	}{
+		// The counter decays over the idle time, as if the item had stayed in
+		// memory without being accessed.
+		{"idle time", 10 * time.Minute, -1, 10 * time.Minute, 0},
+		{"idle time and frequency", 2 * time.Minute, 42, 2 * time.Minute, 40},
+		{"frequency", -1, 42, 0, 42},
+		{"frequency above the counter range", -1, 1000, 0, 255},
+		{"unknown", -1, -1, 0, LFU_INIT_VAL},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ch := NewCache()
+			ch.RestoreItem("key", []byte("value"), time.Time{}, test.idle, test.freq)
+
+			info, ok := ch.Inspect("key")
+
+			if !ok {
+				t.Fatal("got no item")
+			}
+
+			if info.Idle < test.wantIdle || info.Idle > test.wantIdle+time.Second {
+				t.Errorf("got idle time %v, want %v", info.Idle, test.wantIdle)
+			}
+
+			if info.Freq != test.wantFreq {
+				t.Errorf("got frequency %d, want %d", info.Freq, test.wantFreq)
+			}
+		})
+	}
+}
+
+// The number of distinct keys the benchmarks access.
+const BENCHMARK_KEYS = 100000
+
+var benchmarkValue = []byte("value")
+
+func benchmarkKeys() []string {
+	keys := make([]string, BENCHMARK_KEYS)
+
+	for index := range keys {
+		keys[index] = "key:" + strconv.Itoa(index)
+	}
+
+	return keys
+}
+
+// benchmarkCache runs "op" from every parallel goroutine, once with a single
+// shard, as if the keyspace had a single lock, and once with SHARD_COUNT
+// shards. Compare the two with "go test -bench . -cpu 1,2,4,8".
+func benchmarkCache(b *testing.B, op func(ch *Cache, key string, r *rand.Rand)) {
+	keys := benchmarkKeys()
+
+	for _, shardCount := range []int{1, SHARD_COUNT} {
+		b.Run(fmt.Sprintf("shards=%d", shardCount), func(b *testing.B) {
+			ch := newCache(shardCount)
+
+			for _, key := range keys {
+				ch.SetItem(key, benchmarkValue, time.Time{})
+			}
+
+			b.ResetTimer()
+
+			b.RunParallel(func(pb *testing.PB) {
+				r := rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64()))
+
+				for pb.Next() {
+					op(ch, keys[r.IntN(len(keys))], r)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	benchmarkCache(b, func(ch *Cache, key string, r *rand.Rand) {
+		ch.GetItem(key)
+	})
+}
+
+func BenchmarkCacheSet(b *testing.B) {
+	benchmarkCache(b, func(ch *Cache, key string, r *rand.Rand) {
+		ch.SetItem(key, benchmarkValue, time.Time{})
+	})
+}
+
+// BenchmarkCacheMixed reads four keys for every key it sets.
+func BenchmarkCacheMixed(b *testing.B) {
+	benchmarkCache(b, func(ch *Cache, key string, r *rand.Rand) {
+		if r.IntN(5) == 0 {
+			ch.SetItem(key, benchmarkValue, time.Time{})
+		} else {
+			ch.GetItem(key)
+		}
+	})
+}
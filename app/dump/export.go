@@ -0,0 +1,472 @@
+// Package dump converts the keys of RDB files to and from formats that are
+// easy to inspect, diff and migrate: JSON lines and streams of RESP commands.
+package dump
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+const (
+	FORMAT_JSON = "json"
+	FORMAT_RESP = "resp"
+)
+
+// Record is a key as exported to a JSON line.
+type Record struct {
+	DB   int    `json:"db"`
+	Key  string `json:"key"`
+	Type string `json:"type"`
+	// TTL is the time to live of the key in milliseconds, or -1 when the key
+	// does not expire.
+	TTL   int64           `json:"ttl"`
+	Value json.RawMessage `json:"value"`
+	// Encoding is "base64" when the key and every string of the value are
+	// base64 encoded, which is the case when one of them is not valid UTF-8.
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type jsonSortedSetEntry struct {
+	Member string `json:"member"`
+	// Score is a string so that infinite scores can be represented.
+	Score string `json:"score"`
+}
+
+type jsonStream struct {
+	Entries      []jsonStreamEntry `json:"entries"`
+	Length       int               `json:"length"`
+	LastID       string            `json:"last_id"`
+	FirstID      string            `json:"first_id"`
+	MaxDeletedID string            `json:"max_deleted_id"`
+	EntriesAdded int               `json:"entries_added"`
+	Groups       []jsonStreamGroup `json:"groups"`
+}
+
+type jsonStreamEntry struct {
+	ID string `json:"id"`
+	// Fields holds the flattened field/value pairs of the entry.
+	Fields []string `json:"fields"`
+}
+
+type jsonStreamGroup struct {
+	Name        string               `json:"name"`
+	LastID      string               `json:"last_id"`
+	EntriesRead int                  `json:"entries_read"`
+	Pending     []jsonStreamPending  `json:"pending"`
+	Consumers   []jsonStreamConsumer `json:"consumers"`
+}
+
+// Times are Unix times in milliseconds, 0 meaning unknown.
+type jsonStreamPending struct {
+	ID            string `json:"id"`
+	DeliveryTime  int64  `json:"delivery_time"`
+	DeliveryCount int    `json:"delivery_count"`
+}
+
+type jsonStreamConsumer struct {
+	Name       string   `json:"name"`
+	SeenTime   int64    `json:"seen_time"`
+	ActiveTime int64    `json:"active_time"`
+	Pending    []string `json:"pending"`
+}
+
+// Filter selects the keys to export. An empty Pattern or Type matches every
+// key, while a DB of 0 only selects the first database.
+type Filter struct {
+	// Pattern is a glob-style pattern keys must match.
+	Pattern string
+	// DB is the index of the database to export, or -1 for all of them.
+	DB int
+	// Type is the name of the type of the keys to export, e.g. "hash".
+	Type string
+}
+
+func (f Filter) match(entry rdb.DatabaseEntry) bool {
+	if f.DB >= 0 && entry.DatabaseIndex != f.DB {
+		return false
+	}
+
+	if f.Type != "" && rdb.TypeName(entry.Value) != f.Type {
+		return false
+	}
+
+	return f.Pattern == "" || utils.MatchPattern(f.Pattern, entry.Key)
+}
+
+// exporter writes the entries of an RDB file that match its filter. Keys
+// that already expired are skipped.
+type exporter struct {
+	rdb.NopVisitor
+	w      *bufio.Writer
+	filter Filter
+	format string
+	now    time.Time
+	// db is the database last selected in a RESP export.
+	db int
+}
+
+func (e *exporter) OnEntry(entry rdb.DatabaseEntry) error {
+	if !e.filter.match(entry) || (!entry.Expiry.IsZero() && !entry.Expiry.After(e.now)) {
+		return nil
+	}
+
+	if e.format == FORMAT_RESP {
+		return e.writeCommands(entry)
+	}
+
+	record, err := NewRecord(entry, e.now)
+
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(record)
+
+	if err != nil {
+		return err
+	}
+
+	e.w.Write(line)
+
+	return e.w.WriteByte('\n')
+}
+
+func (e *exporter) writeCommands(entry rdb.DatabaseEntry) error {
+	if entry.DatabaseIndex != e.db {
+		e.w.Write(encodeCommand("SELECT", strconv.Itoa(entry.DatabaseIndex)))
+		e.db = entry.DatabaseIndex
+	}
+
+	commands, err := Commands(entry.Key, entry.Value, entry.Expiry)
+
+	if err != nil {
+		return err
+	}
+
+	for _, argv := range commands {
+		if _, err := e.w.Write(encodeCommand(argv...)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Export writes the keys of the RDB file at "src" selected by "filter" to
+// "w", either as JSON lines or as RESP commands recreating them.
+func Export(src string, w io.Writer, format string, filter Filter) error {
+	if format != FORMAT_JSON && format != FORMAT_RESP {
+		return fmt.Errorf("unsupported export format \"%s\"", format)
+	}
+
+	fd, err := os.Open(src)
+
+	if err != nil {
+		return fmt.Errorf("failed to open \"%s\" file: %w", src, err)
+	}
+
+	defer fd.Close()
+
+	e := &exporter{
+		w:      bufio.NewWriter(w),
+		filter: filter,
+		format: format,
+		now:    time.Now(),
+		db:     -1,
+	}
+
+	if err := rdb.NewParser(rdb.ParserOpts{}).ParseReader(fd, e); err != nil {
+		return fmt.Errorf("failed to export \"%s\" file: %w", src, err)
+	}
+
+	return e.w.Flush()
+}
+
+// NewRecord converts an entry to its JSON representation, with a TTL
+// relative to "now".
+func NewRecord(entry rdb.DatabaseEntry, now time.Time) (*Record, error) {
+	record := &Record{
+		DB:   entry.DatabaseIndex,
+		Key:  entry.Key,
+		Type: rdb.TypeName(entry.Value),
+		TTL:  -1,
+	}
+
+	if !entry.Expiry.IsZero() {
+		record.TTL = max(entry.Expiry.Sub(now).Milliseconds(), 0)
+	}
+
+	valid := true
+
+	value, err := jsonValue(entry.Value, func(str string) string {
+		valid = valid && utf8.ValidString(str)
+		return str
+	})
+
+	if err == nil && (!valid || !utf8.ValidString(entry.Key)) {
+		record.Key = base64.StdEncoding.EncodeToString([]byte(entry.Key))
+		record.Encoding = "base64"
+
+		value, err = jsonValue(entry.Value, func(str string) string {
+			return base64.StdEncoding.EncodeToString([]byte(str))
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to export key \"%s\": %w", entry.Key, err)
+	}
+
+	record.Value, err = json.Marshal(value)
+
+	return record, err
+}
+
+// jsonValue converts a value to the form it takes in JSON, passing every
+// string through "encode".
+func jsonValue(value any, encode func(string) string) (any, error) {
+	encodeAll := func(strs []string) []string {
+		encoded := make([]string, len(strs))
+
+		for index, str := range strs {
+			encoded[index] = encode(str)
+		}
+
+		return encoded
+	}
+
+	switch v := value.(type) {
+	case string:
+		return encode(v), nil
+
+	case []byte:
+		return encode(string(v)), nil
+
+	case rdb.List:
+		return encodeAll(v), nil
+
+	case rdb.Set:
+		return encodeAll(v), nil
+
+	case rdb.Hash:
+		hash := map[string]string{}
+
+		for field, fieldValue := range v {
+			hash[encode(field)] = encode(fieldValue)
+		}
+
+		return hash, nil
+
+	case rdb.SortedSet:
+		entries := make([]jsonSortedSetEntry, len(v))
+
+		for index, entry := range v {
+			entries[index] = jsonSortedSetEntry{
+				Member: encode(entry.Member),
+				Score:  strconv.FormatFloat(entry.Score, 'g', -1, 64),
+			}
+		}
+
+		return entries, nil
+
+	case *rdb.Stream:
+		stream := jsonStream{
+			Entries:      []jsonStreamEntry{},
+			Length:       v.Length,
+			LastID:       v.LastID.String(),
+			FirstID:      v.FirstID.String(),
+			MaxDeletedID: v.MaxDeletedEntryID.String(),
+			EntriesAdded: v.EntriesAdded,
+			Groups:       []jsonStreamGroup{},
+		}
+
+		for _, entry := range v.Entries {
+			stream.Entries = append(stream.Entries, jsonStreamEntry{ID: entry.ID.String(), Fields: encodeAll(entry.Fields)})
+		}
+
+		for _, group := range v.Groups {
+			jsonGroup := jsonStreamGroup{
+				Name:        encode(group.Name),
+				LastID:      group.LastID.String(),
+				EntriesRead: group.EntriesRead,
+				Pending:     []jsonStreamPending{},
+				Consumers:   []jsonStreamConsumer{},
+			}
+
+			for _, entry := range group.Pending {
+				jsonGroup.Pending = append(jsonGroup.Pending, jsonStreamPending{
+					ID:            entry.ID.String(),
+					DeliveryTime:  unixMilli(entry.DeliveryTime),
+					DeliveryCount: entry.DeliveryCount,
+				})
+			}
+
+			for _, consumer := range group.Consumers {
+				jsonConsumer := jsonStreamConsumer{
+					Name:       encode(consumer.Name),
+					SeenTime:   unixMilli(consumer.SeenTime),
+					ActiveTime: unixMilli(consumer.ActiveTime),
+					Pending:    []string{},
+				}
+
+				for _, id := range consumer.Pending {
+					jsonConsumer.Pending = append(jsonConsumer.Pending, id.String())
+				}
+
+				jsonGroup.Consumers = append(jsonGroup.Consumers, jsonConsumer)
+			}
+
+			stream.Groups = append(stream.Groups, jsonGroup)
+		}
+
+		return stream, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", value)
+	}
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return t.UnixMilli()
+}
+
+// Commands returns the commands recreating a key, the way Redis rewrites
+// its AOF.
+func Commands(key string, value any, expiry time.Time) ([][]string, error) {
+	var commands [][]string
+
+	switch v := value.(type) {
+	case string:
+		return [][]string{setCommand(key, v, expiry)}, nil
+
+	case []byte:
+		return [][]string{setCommand(key, string(v), expiry)}, nil
+
+	case rdb.List:
+		commands = append(commands, append([]string{"RPUSH", key}, v...))
+
+	case rdb.Set:
+		commands = append(commands, append([]string{"SADD", key}, v...))
+
+	case rdb.Hash:
+		argv := []string{"HSET", key}
+
+		for _, field := range slices.Sorted(maps.Keys(v)) {
+			argv = append(argv, field, v[field])
+		}
+
+		commands = append(commands, argv)
+
+	case rdb.SortedSet:
+		argv := []string{"ZADD", key}
+
+		for _, entry := range v {
+			argv = append(argv, strconv.FormatFloat(entry.Score, 'g', -1, 64), entry.Member)
+		}
+
+		commands = append(commands, argv)
+
+	case *rdb.Stream:
+		commands = streamCommands(key, v)
+
+	default:
+		return nil, fmt.Errorf("failed to export key \"%s\": unsupported value type %T", key, value)
+	}
+
+	if !expiry.IsZero() {
+		commands = append(commands, []string{"PEXPIREAT", key, strconv.FormatInt(expiry.UnixMilli(), 10)})
+	}
+
+	return commands, nil
+}
+
+// setCommand returns the command storing a string, along with its expiry.
+func setCommand(key, value string, expiry time.Time) []string {
+	argv := []string{"SET", key, value}
+
+	if !expiry.IsZero() {
+		argv = append(argv, "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10))
+	}
+
+	return argv
+}
+
+func streamCommands(key string, stream *rdb.Stream) [][]string {
+	commands := [][]string{}
+
+	for _, entry := range stream.Entries {
+		commands = append(commands, append([]string{"XADD", key, entry.ID.String()}, entry.Fields...))
+	}
+
+	// A stream cannot be created empty, so add an entry and trim it away.
+	if len(stream.Entries) == 0 {
+		commands = append(commands, []string{"XADD", key, "MAXLEN", "0", stream.LastID.String(), "x", "y"})
+	}
+
+	commands = append(commands, []string{
+		"XSETID", key, stream.LastID.String(),
+		"ENTRIESADDED", strconv.Itoa(stream.EntriesAdded),
+		"MAXDELETEDID", stream.MaxDeletedEntryID.String(),
+	})
+
+	for _, group := range stream.Groups {
+		commands = append(commands, []string{
+			"XGROUP", "CREATE", key, group.Name, group.LastID.String(),
+			"ENTRIESREAD", strconv.Itoa(group.EntriesRead),
+		})
+
+		owners := map[rdb.StreamID]string{}
+
+		for _, consumer := range group.Consumers {
+			commands = append(commands, []string{"XGROUP", "CREATECONSUMER", key, group.Name, consumer.Name})
+
+			for _, id := range consumer.Pending {
+				owners[id] = consumer.Name
+			}
+		}
+
+		for _, entry := range group.Pending {
+			owner, ok := owners[entry.ID]
+
+			if !ok {
+				continue
+			}
+
+			commands = append(commands, []string{
+				"XCLAIM", key, group.Name, owner, "0", entry.ID.String(),
+				"TIME", strconv.FormatInt(unixMilli(entry.DeliveryTime), 10),
+				"RETRYCOUNT", strconv.Itoa(entry.DeliveryCount),
+				"FORCE", "JUSTID", "LASTID", group.LastID.String(),
+			})
+		}
+	}
+
+	return commands
+}
+
+// encodeCommand encodes a command as a RESP array of bulk strings.
+func encodeCommand(argv ...string) []byte {
+	entries := make([][]byte, len(argv))
+
+	for index, arg := range argv {
+		entries[index] = resp.EncodeBulkString(arg)
+	}
+
+	return resp.EncodeArray(entries)
+}
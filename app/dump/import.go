@@ -0,0 +1,339 @@
+package dump
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+// Import builds an RDB file at "dst" out of the JSON lines read from "r", as
+// written by Export. TTLs are relative to the time of the import.
+func Import(r io.Reader, dst string) error {
+	scanner := bufio.NewScanner(r)
+	// Lines hold whole keys, which may be large.
+	scanner.Buffer(nil, 512*1024*1024)
+
+	now := time.Now()
+	databases := map[int][]rdb.DatabaseEntry{}
+	number := 0
+
+	for scanner.Scan() {
+		number += 1
+
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("invalid record on line %d: %w", number, err)
+		}
+
+		entry, err := record.Entry(now)
+
+		if err != nil {
+			return fmt.Errorf("invalid record on line %d: %w", number, err)
+		}
+
+		databases[entry.DatabaseIndex] = append(databases[entry.DatabaseIndex], entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read records: %w", err)
+	}
+
+	// Write to a temporary file first so a failed import leaves no partial file.
+	fd, err := os.CreateTemp(path.Dir(dst), "temp-import-*.rdb")
+
+	if err != nil {
+		return fmt.Errorf("failed to create RDB file: %w", err)
+	}
+
+	defer os.Remove(fd.Name())
+	defer fd.Close()
+
+	if err := writeDatabases(fd, databases, now); err != nil {
+		return fmt.Errorf("failed to write RDB file: %w", err)
+	}
+
+	if err := fd.Close(); err != nil {
+		return fmt.Errorf("failed to write RDB file: %w", err)
+	}
+
+	return os.Rename(fd.Name(), dst)
+}
+
+func writeDatabases(w io.Writer, databases map[int][]rdb.DatabaseEntry, now time.Time) error {
+	writer := rdb.NewWriter(w)
+
+	if err := writer.WriteHeader(); err != nil {
+		return err
+	}
+
+	if err := writer.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize)); err != nil {
+		return err
+	}
+
+	if err := writer.WriteAux("ctime", strconv.FormatInt(now.Unix(), 10)); err != nil {
+		return err
+	}
+
+	for _, db := range slices.Sorted(func(yield func(int) bool) {
+		for db := range databases {
+			if !yield(db) {
+				return
+			}
+		}
+	}) {
+		entries := databases[db]
+		expiresSize := 0
+
+		for _, entry := range entries {
+			if !entry.Expiry.IsZero() {
+				expiresSize += 1
+			}
+		}
+
+		if err := writer.WriteSelectDB(db); err != nil {
+			return err
+		}
+
+		if err := writer.WriteResizeDB(len(entries), expiresSize); err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if err := writer.WriteEntry(entry.Key, entry.Value, entry.Expiry); err != nil {
+				return err
+			}
+		}
+	}
+
+	return writer.Close()
+}
+
+// Entry converts a record back to an entry, with an expiry computed from its
+// TTL relative to "now".
+func (r *Record) Entry(now time.Time) (rdb.DatabaseEntry, error) {
+	decode := func(str string) (string, error) {
+		return str, nil
+	}
+
+	switch r.Encoding {
+	case "":
+
+	case "base64":
+		decode = func(str string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(str)
+			return string(decoded), err
+		}
+
+	default:
+		return rdb.DatabaseEntry{}, fmt.Errorf("unsupported encoding \"%s\"", r.Encoding)
+	}
+
+	key, err := decode(r.Key)
+
+	if err != nil {
+		return rdb.DatabaseEntry{}, fmt.Errorf("invalid key: %w", err)
+	}
+
+	value, err := parseJSONValue(r.Type, r.Value, decode)
+
+	if err != nil {
+		return rdb.DatabaseEntry{}, fmt.Errorf("invalid value of key \"%s\": %w", key, err)
+	}
+
+	entry := rdb.DatabaseEntry{
+		DatabaseIndex: r.DB,
+		Key:           key,
+		Value:         value,
+		Idle:          -1,
+		Freq:          -1,
+	}
+
+	if r.TTL >= 0 {
+		entry.Expiry = now.Add(time.Duration(r.TTL) * time.Millisecond)
+	}
+
+	return entry, nil
+}
+
+// parseJSONValue converts a value of type "typeName" from the form it takes
+// in JSON, passing every string through "decode".
+func parseJSONValue(typeName string, raw json.RawMessage, decode func(string) (string, error)) (any, error) {
+	var err error
+
+	// decodeString keeps the first decoding error in err.
+	decodeString := func(str string) string {
+		decoded, decodeErr := decode(str)
+
+		if err == nil {
+			err = decodeErr
+		}
+
+		return decoded
+	}
+
+	decodeAll := func(strs []string) []string {
+		decoded := make([]string, len(strs))
+
+		for index, str := range strs {
+			decoded[index] = decodeString(str)
+		}
+
+		return decoded
+	}
+
+	parseID := func(str string) rdb.StreamID {
+		id, parseErr := rdb.ParseStreamID(str)
+
+		if err == nil {
+			err = parseErr
+		}
+
+		return id
+	}
+
+	var value any
+
+	switch typeName {
+	case "string":
+		var str string
+
+		if err := json.Unmarshal(raw, &str); err != nil {
+			return nil, err
+		}
+
+		value = decodeString(str)
+
+	case "list", "set":
+		var members []string
+
+		if err := json.Unmarshal(raw, &members); err != nil {
+			return nil, err
+		}
+
+		if typeName == "list" {
+			value = rdb.List(decodeAll(members))
+		} else {
+			value = rdb.Set(decodeAll(members))
+		}
+
+	case "hash":
+		var fields map[string]string
+
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+
+		hash := rdb.Hash{}
+
+		for field, fieldValue := range fields {
+			hash[decodeString(field)] = decodeString(fieldValue)
+		}
+
+		value = hash
+
+	case "zset":
+		var entries []jsonSortedSetEntry
+
+		if err := json.Unmarshal(raw, &entries); err != nil {
+			return nil, err
+		}
+
+		sortedSet := rdb.SortedSet{}
+
+		for _, entry := range entries {
+			score, scoreErr := strconv.ParseFloat(entry.Score, 64)
+
+			if scoreErr != nil {
+				return nil, fmt.Errorf("invalid score \"%s\"", entry.Score)
+			}
+
+			sortedSet = append(sortedSet, rdb.SortedSetEntry{Member: decodeString(entry.Member), Score: score})
+		}
+
+		value = sortedSet
+
+	case "stream":
+		var jsonValue jsonStream
+
+		if err := json.Unmarshal(raw, &jsonValue); err != nil {
+			return nil, err
+		}
+
+		stream := &rdb.Stream{
+			Length:            jsonValue.Length,
+			LastID:            parseID(jsonValue.LastID),
+			FirstID:           parseID(jsonValue.FirstID),
+			MaxDeletedEntryID: parseID(jsonValue.MaxDeletedID),
+			EntriesAdded:      jsonValue.EntriesAdded,
+		}
+
+		for _, entry := range jsonValue.Entries {
+			stream.Entries = append(stream.Entries, rdb.StreamEntry{ID: parseID(entry.ID), Fields: decodeAll(entry.Fields)})
+		}
+
+		for _, jsonGroup := range jsonValue.Groups {
+			group := rdb.StreamConsumerGroup{
+				Name:        decodeString(jsonGroup.Name),
+				LastID:      parseID(jsonGroup.LastID),
+				EntriesRead: jsonGroup.EntriesRead,
+			}
+
+			for _, entry := range jsonGroup.Pending {
+				group.Pending = append(group.Pending, rdb.StreamPendingEntry{
+					ID:            parseID(entry.ID),
+					DeliveryTime:  fromUnixMilli(entry.DeliveryTime),
+					DeliveryCount: entry.DeliveryCount,
+				})
+			}
+
+			for _, jsonConsumer := range jsonGroup.Consumers {
+				consumer := rdb.StreamConsumer{
+					Name:       decodeString(jsonConsumer.Name),
+					SeenTime:   fromUnixMilli(jsonConsumer.SeenTime),
+					ActiveTime: fromUnixMilli(jsonConsumer.ActiveTime),
+				}
+
+				for _, id := range jsonConsumer.Pending {
+					consumer.Pending = append(consumer.Pending, parseID(id))
+				}
+
+				group.Consumers = append(group.Consumers, consumer)
+			}
+
+			stream.Groups = append(stream.Groups, group)
+		}
+
+		value = stream
+
+	default:
+		return nil, fmt.Errorf("unsupported type \"%s\"", typeName)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+
+	return time.UnixMilli(ms)
+}
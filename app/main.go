@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 
+	"github.com/codecrafters-io/redis-starter-go/app/dump"
+	"github.com/codecrafters-io/redis-starter-go/app/memory"
 	"github.com/codecrafters-io/redis-starter-go/app/server"
 	"github.com/urfave/cli/v2"
 )
@@ -14,9 +16,31 @@ func main() {
 		Action: func(ctx *cli.Context) error {
 			server := server.NewServer(server.ServerOpts{
 				Config: server.NewConfig(map[string]string{
-					"dir":        ctx.String("dir"),
-					"dbfilename": ctx.String("dbfilename"),
-					"replicaof":  ctx.String("replicaof"),
+					"aof-load-truncated":          ctx.String("aof-load-truncated"),
+					"aof-use-rdb-preamble":        ctx.String("aof-use-rdb-preamble"),
+					"auto-aof-rewrite-min-size":   ctx.String("auto-aof-rewrite-min-size"),
+					"auto-aof-rewrite-percentage": ctx.String("auto-aof-rewrite-percentage"),
+					"appenddirname":               ctx.String("appenddirname"),
+					"appendfilename":              ctx.String("appendfilename"),
+					"appendfsync":                 ctx.String("appendfsync"),
+					"appendonly":                  ctx.String("appendonly"),
+					"client-output-buffer-limit":  ctx.String("client-output-buffer-limit"),
+					"dir":                         ctx.String("dir"),
+					"dbfilename":                  ctx.String("dbfilename"),
+					"maxmemory":                   ctx.String("maxmemory"),
+					"maxmemory-policy":            ctx.String("maxmemory-policy"),
+					"maxmemory-samples":           ctx.String("maxmemory-samples"),
+					"replicaof":                   ctx.String("replicaof"),
+					"min-replicas-max-lag":        ctx.String("min-replicas-max-lag"),
+					"min-replicas-to-write":       ctx.String("min-replicas-to-write"),
+					"notify-keyspace-events":      ctx.String("notify-keyspace-events"),
+					"proto-max-bulk-len":          ctx.String("proto-max-bulk-len"),
+					"repl-diskless-sync":          ctx.String("repl-diskless-sync"),
+					"repl-diskless-sync-delay":    ctx.String("repl-diskless-sync-delay"),
+					"replica-read-only":           ctx.String("replica-read-only"),
+					"replica-serve-stale-data":    ctx.String("replica-serve-stale-data"),
+					"sanitize-dump-payload":       ctx.String("sanitize-dump-payload"),
+					"tracking-table-max-keys":     ctx.String("tracking-table-max-keys"),
 				}),
 				Port: ctx.Int("port"),
 			})
@@ -27,7 +51,176 @@ func main() {
 
 			return nil
 		},
+		Commands: []*cli.Command{
+			{
+				Name:      "check-aof",
+				Usage:     "Check an AOF manifest or file for corruption",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:  "fix",
+						Usage: "truncate a file cut short to its last valid command",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return cli.Exit("check-aof requires the path of an AOF manifest or file", 1)
+					}
+
+					return server.CheckAof(ctx.Args().First(), ctx.Bool("fix"), os.Stdout)
+				},
+			},
+			{
+				Name:      "check-rdb",
+				Usage:     "Check an RDB file for corruption",
+				ArgsUsage: "<file>",
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return cli.Exit("check-rdb requires the path of an RDB file", 1)
+					}
+
+					return server.CheckRdb(ctx.Args().First(), os.Stdout)
+				},
+			},
+			{
+				Name:      "rdb-export",
+				Usage:     "Export the keys of an RDB file as JSON lines or as a RESP command stream",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format, \"json\" or \"resp\"",
+						Value: dump.FORMAT_JSON,
+					},
+					&cli.StringFlag{
+						Name:  "pattern",
+						Usage: "only export keys matching this glob-style pattern",
+					},
+					&cli.IntFlag{
+						Name:  "db",
+						Usage: "only export keys of this database",
+						Value: -1,
+					},
+					&cli.StringFlag{
+						Name:  "type",
+						Usage: "only export keys of this type",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return cli.Exit("rdb-export requires the path of an RDB file", 1)
+					}
+
+					return dump.Export(ctx.Args().First(), os.Stdout, ctx.String("format"), dump.Filter{
+						Pattern: ctx.String("pattern"),
+						DB:      ctx.Int("db"),
+						Type:    ctx.String("type"),
+					})
+				},
+			},
+			{
+				Name:      "rdb-memory",
+				Usage:     "Estimate the memory used by the keys of an RDB file",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "report",
+						Usage: "report to produce, one of \"keys\", \"top\", \"prefixes\", \"expiry\" or \"encodings\"",
+						Value: memory.REPORT_KEYS,
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format, \"csv\" or \"json\"",
+						Value: memory.FORMAT_CSV,
+					},
+					&cli.IntFlag{
+						Name:  "top",
+						Usage: "number of keys of every type listed by the \"top\" report",
+						Value: 10,
+					},
+					&cli.StringFlag{
+						Name:  "delimiter",
+						Usage: "separator ending the key prefixes of the \"prefixes\" report",
+						Value: ":",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 1 {
+						return cli.Exit("rdb-memory requires the path of an RDB file", 1)
+					}
+
+					return memory.Analyze(ctx.Args().First(), os.Stdout, memory.ReportOpts{
+						Report:    ctx.String("report"),
+						Format:    ctx.String("format"),
+						Top:       ctx.Int("top"),
+						Delimiter: ctx.String("delimiter"),
+					})
+				},
+			},
+			{
+				Name:      "rdb-import",
+				Usage:     "Build an RDB file from JSON lines as written by rdb-export",
+				ArgsUsage: "<json file or -> <rdb file>",
+				Action: func(ctx *cli.Context) error {
+					if ctx.NArg() != 2 {
+						return cli.Exit("rdb-import requires the path of a JSON lines file and of the RDB file to write", 1)
+					}
+
+					src := os.Stdin
+
+					if ctx.Args().Get(0) != "-" {
+						fd, err := os.Open(ctx.Args().Get(0))
+
+						if err != nil {
+							return err
+						}
+
+						defer fd.Close()
+
+						src = fd
+					}
+
+					return dump.Import(src, ctx.Args().Get(1))
+				},
+			},
+		},
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:     "aof-load-truncated",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "aof-use-rdb-preamble",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "auto-aof-rewrite-min-size",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "auto-aof-rewrite-percentage",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "appenddirname",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "appendfilename",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "appendfsync",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "appendonly",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "client-output-buffer-limit",
+				Required: false,
+			},
 			&cli.StringFlag{
 				Name:     "dbfilename",
 				Required: false,
@@ -45,6 +238,58 @@ func main() {
 				Name:     "replicaof",
 				Required: false,
 			},
+			&cli.StringFlag{
+				Name:     "maxmemory",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "maxmemory-policy",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "maxmemory-samples",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "min-replicas-max-lag",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "min-replicas-to-write",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "notify-keyspace-events",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "proto-max-bulk-len",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "repl-diskless-sync",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "repl-diskless-sync-delay",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "replica-read-only",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "replica-serve-stale-data",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "sanitize-dump-payload",
+				Required: false,
+			},
+			&cli.StringFlag{
+				Name:     "tracking-table-max-keys",
+				Required: false,
+			},
 		},
 	}
 
@@ -0,0 +1,558 @@
+// Package memory estimates how much memory Redis needs to hold a key, based
+// on the encodings and allocator size classes of a 64 bit Redis 7 build.
+package memory
+
+import (
+	"math/bits"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+// Thresholds under which Redis keeps values in a compact encoding, as set by
+// its default configuration.
+const (
+	HASH_MAX_LISTPACK_ENTRIES = 128
+	HASH_MAX_LISTPACK_VALUE   = 64
+	LIST_MAX_LISTPACK_SIZE    = 8 * 1024
+	SET_MAX_INTSET_ENTRIES    = 512
+	SET_MAX_LISTPACK_ENTRIES  = 128
+	SET_MAX_LISTPACK_VALUE    = 64
+	ZSET_MAX_LISTPACK_ENTRIES = 128
+	ZSET_MAX_LISTPACK_VALUE   = 64
+	// Strings up to this length are allocated along with their object.
+	EMBSTR_MAX_LENGTH = 44
+	// The number of entries of a stream stored in a single listpack.
+	STREAM_NODE_MAX_ENTRIES = 100
+)
+
+// Sizes of the structures Redis allocates, in bytes.
+const (
+	DICT_ENTRY_SIZE      = 24
+	DICT_SIZE            = 56
+	OBJECT_SIZE          = 16
+	POINTER_SIZE         = 8
+	QUICK_LIST_SIZE      = 40
+	QUICK_LIST_NODE_SIZE = 32
+	SKIP_LIST_SIZE       = 32
+	SKIP_LIST_NODE_SIZE  = 32
+	// Each level of a skiplist node holds a pointer and a span.
+	SKIP_LIST_LEVEL_SIZE = 16
+	STREAM_SIZE          = 64
+	STREAM_CG_SIZE       = 56
+	STREAM_CONSUMER_SIZE = 56
+	STREAM_NACK_SIZE     = 40
+	RAX_SIZE             = 24
+	RAX_NODE_SIZE        = 40
+	LISTPACK_HEADER_SIZE = 6
+	INTSET_HEADER_SIZE   = 8
+)
+
+// Usage estimates the memory used by a key holding "value", including the
+// key itself and its entry in the keyspace and, when "expires" is set, in the
+// expires dictionary.
+func Usage(key string, value any, expires bool) int64 {
+	return SampledUsage(key, value, expires, 0)
+}
+
+// SampledUsage is like Usage, but estimates the memory used by the elements
+// of aggregate values from the first "samples" of them, as MEMORY USAGE does.
+// All the elements are measured when "samples" is 0.
+func SampledUsage(key string, value any, expires bool, samples int) int64 {
+	size := DICT_ENTRY_SIZE + sdsSize(len(key)) + valueUsage(value, samples)
+
+	if expires {
+		size += DICT_ENTRY_SIZE
+	}
+
+	return int64(size)
+}
+
+// ValueUsage estimates the memory used by a value, object header included.
+func ValueUsage(value any) int {
+	return valueUsage(value, 0)
+}
+
+func valueUsage(value any, samples int) int {
+	switch v := value.(type) {
+	case string:
+		return stringUsage(v)
+
+	case []byte:
+		return stringUsage(string(v))
+
+	case rdb.List:
+		return OBJECT_SIZE + listUsage(v, samples)
+
+	case rdb.Set:
+		return OBJECT_SIZE + setUsage(v, samples)
+
+	case rdb.Hash:
+		return OBJECT_SIZE + hashUsage(v, samples)
+
+	case rdb.SortedSet:
+		return OBJECT_SIZE + sortedSetUsage(v, samples)
+
+	case *rdb.Stream:
+		return OBJECT_SIZE + streamUsage(v, samples)
+
+	default:
+		return OBJECT_SIZE
+	}
+}
+
+// Encoding returns the name of the encoding Redis picks to hold a value, as
+// reported by OBJECT ENCODING.
+func Encoding(value any) string {
+	switch v := value.(type) {
+	case string:
+		return stringEncoding(v)
+
+	case []byte:
+		return stringEncoding(string(v))
+
+	case rdb.List:
+		if listpackSize(v) <= LIST_MAX_LISTPACK_SIZE {
+			return "listpack"
+		}
+
+		return "quicklist"
+
+	case rdb.Set:
+		return setEncoding(v)
+
+	case rdb.Hash:
+		if len(v) <= HASH_MAX_LISTPACK_ENTRIES && hashFitsListpack(v) {
+			return "listpack"
+		}
+
+		return "hashtable"
+
+	case rdb.SortedSet:
+		if len(v) <= ZSET_MAX_LISTPACK_ENTRIES && sortedSetFitsListpack(v) {
+			return "listpack"
+		}
+
+		return "skiplist"
+
+	case *rdb.Stream:
+		return "stream"
+
+	default:
+		return "unknown"
+	}
+}
+
+// Elements returns the number of elements of a value and the length of its
+// largest one. Strings count as a single element.
+func Elements(value any) (count, largest int) {
+	measure := func(strs ...string) {
+		for _, str := range strs {
+			largest = max(largest, len(str))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		return 1, len(v)
+
+	case []byte:
+		return 1, len(v)
+
+	case rdb.List:
+		measure(v...)
+		return len(v), largest
+
+	case rdb.Set:
+		measure(v...)
+		return len(v), largest
+
+	case rdb.Hash:
+		for field, fieldValue := range v {
+			measure(field, fieldValue)
+		}
+
+		return len(v), largest
+
+	case rdb.SortedSet:
+		for _, entry := range v {
+			measure(entry.Member)
+		}
+
+		return len(v), largest
+
+	case *rdb.Stream:
+		for _, entry := range v.Entries {
+			measure(entry.Fields...)
+		}
+
+		return len(v.Entries), largest
+
+	default:
+		return 0, 0
+	}
+}
+
+func stringEncoding(str string) string {
+	if isInteger(str) {
+		return "int"
+	}
+
+	if len(str) <= EMBSTR_MAX_LENGTH {
+		return "embstr"
+	}
+
+	return "raw"
+}
+
+func stringUsage(str string) int {
+	switch stringEncoding(str) {
+	case "int":
+		return OBJECT_SIZE
+
+	case "embstr":
+		return mallocSize(OBJECT_SIZE + sdsHeaderSize(len(str)) + len(str) + 1)
+
+	default:
+		return OBJECT_SIZE + sdsSize(len(str))
+	}
+}
+
+func setEncoding(set rdb.Set) string {
+	if len(set) <= SET_MAX_INTSET_ENTRIES && allIntegers(set) {
+		return "intset"
+	}
+
+	if len(set) <= SET_MAX_LISTPACK_ENTRIES && allShorterThan(set, SET_MAX_LISTPACK_VALUE) {
+		return "listpack"
+	}
+
+	return "hashtable"
+}
+
+func listUsage(list rdb.List, samples int) int {
+	if listpackSize(list) <= LIST_MAX_LISTPACK_SIZE {
+		return mallocSize(listpackSize(list))
+	}
+
+	// Extrapolate the size of the entries from the sampled ones, split in
+	// full nodes.
+	if samples > 0 && len(list) > samples {
+		entries := 0
+
+		for _, element := range list[:samples] {
+			entries += listpackEntrySize(element)
+		}
+
+		entries = scale(entries, samples, len(list))
+		perNode := LIST_MAX_LISTPACK_SIZE - LISTPACK_HEADER_SIZE - 1
+		nodes := (entries + perNode - 1) / perNode
+
+		return QUICK_LIST_SIZE + nodes*(QUICK_LIST_NODE_SIZE+LISTPACK_HEADER_SIZE+1) + entries
+	}
+
+	// Split the list in nodes of at most LIST_MAX_LISTPACK_SIZE bytes.
+	size := QUICK_LIST_SIZE
+	node := LISTPACK_HEADER_SIZE + 1
+
+	for _, element := range list {
+		entry := listpackEntrySize(element)
+
+		if node+entry > LIST_MAX_LISTPACK_SIZE && node > LISTPACK_HEADER_SIZE+1 {
+			size += QUICK_LIST_NODE_SIZE + mallocSize(node)
+			node = LISTPACK_HEADER_SIZE + 1
+		}
+
+		node += entry
+	}
+
+	return size + QUICK_LIST_NODE_SIZE + mallocSize(node)
+}
+
+func setUsage(set rdb.Set, samples int) int {
+	switch setEncoding(set) {
+	case "intset":
+		width := 2
+
+		for _, member := range set {
+			n, _ := strconv.ParseInt(member, 10, 64)
+
+			if n != int64(int32(n)) {
+				width = 8
+			} else if n != int64(int16(n)) {
+				width = max(width, 4)
+			}
+		}
+
+		return mallocSize(INTSET_HEADER_SIZE + len(set)*width)
+
+	case "listpack":
+		return mallocSize(listpackSize(set))
+
+	default:
+		elements, seen := 0, 0
+
+		for _, member := range set {
+			if samples > 0 && seen >= samples {
+				break
+			}
+
+			elements += DICT_ENTRY_SIZE + sdsSize(len(member))
+			seen += 1
+		}
+
+		return dictSize(len(set)) + scale(elements, seen, len(set))
+	}
+}
+
+func hashUsage(hash rdb.Hash, samples int) int {
+	if len(hash) <= HASH_MAX_LISTPACK_ENTRIES && hashFitsListpack(hash) {
+		size := LISTPACK_HEADER_SIZE + 1
+
+		for field, value := range hash {
+			size += listpackEntrySize(field) + listpackEntrySize(value)
+		}
+
+		return mallocSize(size)
+	}
+
+	elements, seen := 0, 0
+
+	for field, value := range hash {
+		if samples > 0 && seen >= samples {
+			break
+		}
+
+		elements += DICT_ENTRY_SIZE + sdsSize(len(field)) + sdsSize(len(value))
+		seen += 1
+	}
+
+	return dictSize(len(hash)) + scale(elements, seen, len(hash))
+}
+
+func sortedSetUsage(sortedSet rdb.SortedSet, samples int) int {
+	if len(sortedSet) <= ZSET_MAX_LISTPACK_ENTRIES && sortedSetFitsListpack(sortedSet) {
+		size := LISTPACK_HEADER_SIZE + 1
+
+		for _, entry := range sortedSet {
+			size += listpackEntrySize(entry.Member) + listpackEntrySize(strconv.FormatFloat(entry.Score, 'g', 17, 64))
+		}
+
+		return mallocSize(size)
+	}
+
+	// Members are shared by the dictionary and the skiplist, whose nodes have
+	// 1.33 levels on average.
+	elements, seen := 0, 0
+
+	for _, entry := range sortedSet {
+		if samples > 0 && seen >= samples {
+			break
+		}
+
+		elements += DICT_ENTRY_SIZE + sdsSize(len(entry.Member)) + mallocSize(SKIP_LIST_NODE_SIZE+SKIP_LIST_LEVEL_SIZE*4/3)
+		seen += 1
+	}
+
+	return dictSize(len(sortedSet)) + mallocSize(SKIP_LIST_SIZE) + scale(elements, seen, len(sortedSet))
+}
+
+func streamUsage(stream *rdb.Stream, samples int) int {
+	size := mallocSize(STREAM_SIZE) + mallocSize(RAX_SIZE)
+	entries, seen := 0, 0
+
+	// Entries are stored in listpacks of STREAM_NODE_MAX_ENTRIES entries,
+	// indexed by a radix tree. Each entry holds its ID relative to the first
+	// one of its listpack, its flags and its fields and values.
+	for start := 0; start < len(stream.Entries); start += STREAM_NODE_MAX_ENTRIES {
+		if samples > 0 && seen >= samples {
+			break
+		}
+
+		node := LISTPACK_HEADER_SIZE + 1
+
+		for _, entry := range stream.Entries[start:min(start+STREAM_NODE_MAX_ENTRIES, len(stream.Entries))] {
+			node += 4 * listpackEntrySize("0")
+
+			for _, field := range entry.Fields {
+				node += listpackEntrySize(field)
+			}
+		}
+
+		entries += mallocSize(RAX_NODE_SIZE+rdb.STREAM_ID_SIZE) + mallocSize(node)
+		seen += min(STREAM_NODE_MAX_ENTRIES, len(stream.Entries)-start)
+	}
+
+	size += scale(entries, seen, len(stream.Entries))
+
+	for _, group := range stream.Groups {
+		size += mallocSize(STREAM_CG_SIZE) + mallocSize(RAX_SIZE) + sdsSize(len(group.Name))
+		size += len(group.Pending) * (mallocSize(STREAM_NACK_SIZE) + mallocSize(RAX_NODE_SIZE+rdb.STREAM_ID_SIZE))
+
+		for _, consumer := range group.Consumers {
+			size += mallocSize(STREAM_CONSUMER_SIZE) + mallocSize(RAX_SIZE) + sdsSize(len(consumer.Name))
+			size += len(consumer.Pending) * mallocSize(RAX_NODE_SIZE+rdb.STREAM_ID_SIZE)
+		}
+	}
+
+	return size
+}
+
+// scale extrapolates the size of "total" elements from the size of the
+// "seen" first ones.
+func scale(size, seen, total int) int {
+	if seen == 0 || seen == total {
+		return size
+	}
+
+	return int(int64(size) * int64(total) / int64(seen))
+}
+
+func hashFitsListpack(hash rdb.Hash) bool {
+	for field, value := range hash {
+		if len(field) > HASH_MAX_LISTPACK_VALUE || len(value) > HASH_MAX_LISTPACK_VALUE {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortedSetFitsListpack(sortedSet rdb.SortedSet) bool {
+	for _, entry := range sortedSet {
+		if len(entry.Member) > ZSET_MAX_LISTPACK_VALUE {
+			return false
+		}
+	}
+
+	return true
+}
+
+func allIntegers(strs []string) bool {
+	for _, str := range strs {
+		if !isInteger(str) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func allShorterThan(strs []string, length int) bool {
+	for _, str := range strs {
+		if len(str) > length {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isInteger reports whether Redis stores a string as a 64 bit integer, which
+// requires it to format back to the exact same string.
+func isInteger(str string) bool {
+	n, err := strconv.ParseInt(str, 10, 64)
+	return err == nil && strconv.FormatInt(n, 10) == str
+}
+
+// listpackSize returns the size of a listpack holding "strs".
+func listpackSize(strs []string) int {
+	size := LISTPACK_HEADER_SIZE + 1
+
+	for _, str := range strs {
+		size += listpackEntrySize(str)
+	}
+
+	return size
+}
+
+// listpackEntrySize returns the size of a listpack entry holding "str": its
+// encoding, its data and the length of both stored backwards.
+func listpackEntrySize(str string) int {
+	var size int
+
+	if n, err := strconv.ParseInt(str, 10, 64); err == nil && strconv.FormatInt(n, 10) == str {
+		switch {
+		case n >= 0 && n <= 127:
+			size = 1
+		case n >= -4096 && n <= 4095:
+			size = 2
+		case n == int64(int16(n)):
+			size = 3
+		case n >= -(1<<23) && n < 1<<23:
+			size = 4
+		case n == int64(int32(n)):
+			size = 5
+		default:
+			size = 9
+		}
+	} else {
+		switch {
+		case len(str) < 64:
+			size = 1 + len(str)
+		case len(str) < 4096:
+			size = 2 + len(str)
+		default:
+			size = 5 + len(str)
+		}
+	}
+
+	switch {
+	case size < 128:
+		return size + 1
+	case size < 16384:
+		return size + 2
+	case size < 2097152:
+		return size + 3
+	case size < 268435456:
+		return size + 4
+	default:
+		return size + 5
+	}
+}
+
+// dictSize returns the size of a dictionary holding "n" entries, without the
+// entries themselves.
+func dictSize(n int) int {
+	buckets := 4
+
+	if n > 4 {
+		buckets = 1 << bits.Len(uint(n-1))
+	}
+
+	return mallocSize(DICT_SIZE) + mallocSize(buckets*POINTER_SIZE)
+}
+
+func sdsHeaderSize(length int) int {
+	switch {
+	case length < 1<<8:
+		return 3
+	case length < 1<<16:
+		return 5
+	case length < 1<<32:
+		return 9
+	default:
+		return 17
+	}
+}
+
+// sdsSize returns the size of a dynamic string holding "length" bytes.
+func sdsSize(length int) int {
+	return mallocSize(sdsHeaderSize(length) + length + 1)
+}
+
+// mallocSize rounds a size up to the jemalloc size class it is allocated
+// from: multiples of 8 and 16 for small sizes, then four classes for every
+// doubling.
+func mallocSize(size int) int {
+	switch {
+	case size <= 8:
+		return 8
+	case size <= 128:
+		return (size + 15) &^ 15
+	}
+
+	step := 1 << (bits.Len(uint(size-1)) - 3)
+
+	return (size + step - 1) &^ (step - 1)
+}
@@ -0,0 +1,373 @@
+package memory
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+const (
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+)
+
+// The reports Analyze can produce.
+const (
+	// Every key with its estimated memory usage, as the file is read.
+	REPORT_KEYS = "keys"
+	// The biggest keys of every type.
+	REPORT_TOP = "top"
+	// Keys and memory aggregated by key prefix.
+	REPORT_PREFIXES = "prefixes"
+	// Keys and memory aggregated by time to live.
+	REPORT_EXPIRY = "expiry"
+	// Keys and memory aggregated by type and encoding.
+	REPORT_ENCODINGS = "encodings"
+)
+
+// ReportOpts configures Analyze.
+type ReportOpts struct {
+	Report string
+	Format string
+	// Top is the number of keys of every type listed by REPORT_TOP.
+	Top int
+	// Delimiter separates the prefix of a key from the rest of it, keys
+	// without one being grouped by their full name in REPORT_PREFIXES.
+	Delimiter string
+}
+
+// expiryBucket groups keys whose time to live is below "limit".
+type expiryBucket struct {
+	name  string
+	limit time.Duration
+}
+
+var expiryBuckets = []expiryBucket{
+	{"expired", 0},
+	{"<1m", time.Minute},
+	{"<1h", time.Hour},
+	{"<1d", 24 * time.Hour},
+	{"<7d", 7 * 24 * time.Hour},
+	{"<30d", 30 * 24 * time.Hour},
+	{">=30d", -1},
+}
+
+// KeyUsage is the estimated memory usage of a key.
+type KeyUsage struct {
+	DB       int    `json:"database"`
+	Type     string `json:"type"`
+	Key      string `json:"key"`
+	Size     int64  `json:"size_in_bytes"`
+	Encoding string `json:"encoding"`
+	Elements int    `json:"num_elements"`
+	Largest  int    `json:"len_largest_element"`
+	// Expiry is empty when the key does not expire.
+	Expiry string `json:"expiry"`
+}
+
+// Aggregate is the number of keys of a group and the memory they use.
+type Aggregate struct {
+	Keys int   `json:"keys"`
+	Size int64 `json:"size_in_bytes"`
+}
+
+// analyzer computes the usage of every key of an RDB file and either writes
+// it or aggregates it.
+type analyzer struct {
+	rdb.NopVisitor
+	opts ReportOpts
+	now  time.Time
+	// write is set for REPORT_KEYS.
+	write func(usage KeyUsage) error
+	// top holds the biggest keys of every type, biggest first.
+	top       map[string][]KeyUsage
+	prefixes  map[string]*Aggregate
+	expiry    map[string]*Aggregate
+	encodings map[[2]string]*Aggregate
+}
+
+func (a *analyzer) OnEntry(entry rdb.DatabaseEntry) error {
+	usage := KeyUsage{
+		DB:       entry.DatabaseIndex,
+		Type:     rdb.TypeName(entry.Value),
+		Key:      entry.Key,
+		Size:     Usage(entry.Key, entry.Value, !entry.Expiry.IsZero()),
+		Encoding: Encoding(entry.Value),
+	}
+	usage.Elements, usage.Largest = Elements(entry.Value)
+
+	if !entry.Expiry.IsZero() {
+		usage.Expiry = entry.Expiry.UTC().Format(time.RFC3339Nano)
+	}
+
+	switch a.opts.Report {
+	case REPORT_KEYS:
+		return a.write(usage)
+
+	case REPORT_TOP:
+		top := a.top[usage.Type]
+		index, _ := slices.BinarySearchFunc(top, usage.Size, func(u KeyUsage, size int64) int {
+			return cmp.Compare(size, u.Size)
+		})
+
+		if index < a.opts.Top {
+			top = slices.Insert(top, index, usage)
+			a.top[usage.Type] = top[:min(len(top), a.opts.Top)]
+		}
+
+	case REPORT_PREFIXES:
+		prefix := entry.Key
+
+		if a.opts.Delimiter != "" {
+			prefix, _, _ = strings.Cut(entry.Key, a.opts.Delimiter)
+		}
+
+		add(a.prefixes, prefix, usage.Size)
+
+	case REPORT_EXPIRY:
+		add(a.expiry, a.expiryBucket(entry.Expiry), usage.Size)
+
+	case REPORT_ENCODINGS:
+		add(a.encodings, [2]string{usage.Type, usage.Encoding}, usage.Size)
+	}
+
+	return nil
+}
+
+func (a *analyzer) expiryBucket(expiry time.Time) string {
+	if expiry.IsZero() {
+		return "none"
+	}
+
+	ttl := expiry.Sub(a.now)
+
+	for _, bucket := range expiryBuckets {
+		if bucket.limit < 0 || ttl < bucket.limit {
+			return bucket.name
+		}
+	}
+
+	return ""
+}
+
+func add[K comparable](aggregates map[K]*Aggregate, key K, size int64) {
+	aggregate, ok := aggregates[key]
+
+	if !ok {
+		aggregate = &Aggregate{}
+		aggregates[key] = aggregate
+	}
+
+	aggregate.Keys += 1
+	aggregate.Size += size
+}
+
+// Analyze estimates the memory used by the keys of the RDB file at "src" and
+// writes the report selected by "opts" to "w". Keys are read one at a time,
+// so files much larger than the available memory can be analyzed.
+func Analyze(src string, w io.Writer, opts ReportOpts) error {
+	if opts.Format != FORMAT_CSV && opts.Format != FORMAT_JSON {
+		return fmt.Errorf("unsupported report format \"%s\"", opts.Format)
+	}
+
+	fd, err := os.Open(src)
+
+	if err != nil {
+		return fmt.Errorf("failed to open \"%s\" file: %w", src, err)
+	}
+
+	defer fd.Close()
+
+	out := bufio.NewWriter(w)
+	table := newTable(out, opts.Format)
+
+	a := &analyzer{
+		opts:      opts,
+		now:       time.Now(),
+		top:       map[string][]KeyUsage{},
+		prefixes:  map[string]*Aggregate{},
+		expiry:    map[string]*Aggregate{},
+		encodings: map[[2]string]*Aggregate{},
+	}
+
+	switch opts.Report {
+	case REPORT_KEYS:
+		table.header(keyColumns...)
+		a.write = func(usage KeyUsage) error {
+			return table.row(usage, keyRow(usage)...)
+		}
+
+	case REPORT_TOP, REPORT_PREFIXES, REPORT_EXPIRY, REPORT_ENCODINGS:
+
+	default:
+		return fmt.Errorf("unsupported report \"%s\"", opts.Report)
+	}
+
+	if err := rdb.NewParser(rdb.ParserOpts{}).ParseReader(fd, a); err != nil {
+		return fmt.Errorf("failed to analyze \"%s\" file: %w", src, err)
+	}
+
+	if err := a.writeAggregates(table); err != nil {
+		return err
+	}
+
+	if err := table.flush(); err != nil {
+		return err
+	}
+
+	return out.Flush()
+}
+
+var keyColumns = []string{"database", "type", "key", "size_in_bytes", "encoding", "num_elements", "len_largest_element", "expiry"}
+
+func keyRow(usage KeyUsage) []string {
+	return []string{
+		strconv.Itoa(usage.DB),
+		usage.Type,
+		usage.Key,
+		strconv.FormatInt(usage.Size, 10),
+		usage.Encoding,
+		strconv.Itoa(usage.Elements),
+		strconv.Itoa(usage.Largest),
+		usage.Expiry,
+	}
+}
+
+// writeAggregates writes the aggregated reports, biggest groups first.
+func (a *analyzer) writeAggregates(table *table) error {
+	switch a.opts.Report {
+	case REPORT_TOP:
+		table.header(keyColumns...)
+
+		for _, name := range slices.Sorted(maps.Keys(a.top)) {
+			for _, usage := range a.top[name] {
+				if err := table.row(usage, keyRow(usage)...); err != nil {
+					return err
+				}
+			}
+		}
+
+	case REPORT_PREFIXES:
+		table.header("prefix", "keys", "size_in_bytes")
+
+		for _, prefix := range sortedBySize(a.prefixes, strings.Compare) {
+			aggregate := a.prefixes[prefix]
+			row := struct {
+				Prefix string `json:"prefix"`
+				*Aggregate
+			}{prefix, aggregate}
+
+			if err := table.row(row, prefix, strconv.Itoa(aggregate.Keys), strconv.FormatInt(aggregate.Size, 10)); err != nil {
+				return err
+			}
+		}
+
+	case REPORT_EXPIRY:
+		table.header("expiry", "keys", "size_in_bytes")
+		buckets := []string{"none"}
+
+		for _, bucket := range expiryBuckets {
+			buckets = append(buckets, bucket.name)
+		}
+
+		for _, bucket := range buckets {
+			aggregate, ok := a.expiry[bucket]
+
+			if !ok {
+				aggregate = &Aggregate{}
+			}
+
+			row := struct {
+				Expiry string `json:"expiry"`
+				*Aggregate
+			}{bucket, aggregate}
+
+			if err := table.row(row, bucket, strconv.Itoa(aggregate.Keys), strconv.FormatInt(aggregate.Size, 10)); err != nil {
+				return err
+			}
+		}
+
+	case REPORT_ENCODINGS:
+		table.header("type", "encoding", "keys", "size_in_bytes")
+
+		for _, key := range sortedBySize(a.encodings, func(a, b [2]string) int {
+			return cmp.Or(strings.Compare(a[0], b[0]), strings.Compare(a[1], b[1]))
+		}) {
+			aggregate := a.encodings[key]
+			row := struct {
+				Type     string `json:"type"`
+				Encoding string `json:"encoding"`
+				*Aggregate
+			}{key[0], key[1], aggregate}
+
+			if err := table.row(row, key[0], key[1], strconv.Itoa(aggregate.Keys), strconv.FormatInt(aggregate.Size, 10)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sortedBySize returns the keys of "aggregates" by decreasing size, then
+// in the order defined by "compare".
+func sortedBySize[K comparable](aggregates map[K]*Aggregate, compare func(a, b K) int) []K {
+	return slices.SortedFunc(maps.Keys(aggregates), func(a, b K) int {
+		return cmp.Or(cmp.Compare(aggregates[b].Size, aggregates[a].Size), compare(a, b))
+	})
+}
+
+// table writes the rows of a report as CSV, or as JSON lines of the values
+// they were built from.
+type table struct {
+	w      io.Writer
+	format string
+	csv    *csv.Writer
+}
+
+func newTable(w io.Writer, format string) *table {
+	return &table{
+		w:      w,
+		format: format,
+		csv:    csv.NewWriter(w),
+	}
+}
+
+func (t *table) header(columns ...string) {
+	if t.format == FORMAT_CSV {
+		t.csv.Write(columns)
+	}
+}
+
+func (t *table) row(value any, fields ...string) error {
+	if t.format == FORMAT_CSV {
+		return t.csv.Write(fields)
+	}
+
+	line, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+	_, err = t.w.Write(line)
+
+	return err
+}
+
+func (t *table) flush() error {
+	t.csv.Flush()
+	return t.csv.Error()
+}
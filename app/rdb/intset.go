@@ -0,0 +1,49 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+// An intset is laid out as <encoding><length><contents>, where the encoding
+// is the size in bytes of every integer in the sorted contents array.
+const (
+	INTSET_HEADER_SIZE = 8
+)
+
+// decodeIntset returns the members of an intset. When "sanitize" is set the
+// members are checked to be sorted and unique.
+func decodeIntset(buf []byte, sanitize bool) ([]string, error) {
+	if len(buf) < INTSET_HEADER_SIZE {
+		return nil, fmt.Errorf("intset of %d bytes is too short", len(buf))
+	}
+
+	encoding := int(binary.LittleEndian.Uint32(buf[0:4]))
+	length := int(binary.LittleEndian.Uint32(buf[4:8]))
+
+	if encoding != 2 && encoding != 4 && encoding != 8 {
+		return nil, fmt.Errorf("unknown intset encoding %d", encoding)
+	}
+
+	if len(buf)-INTSET_HEADER_SIZE != length*encoding {
+		return nil, fmt.Errorf("intset of %d %d-byte integers does not match payload size %d", length, encoding, len(buf))
+	}
+
+	members := make([]string, length)
+	var previous int64
+
+	for index := range length {
+		position := INTSET_HEADER_SIZE + index*encoding
+		value := readInt(buf[position:], encoding)
+
+		if sanitize && index > 0 && value <= previous {
+			return nil, fmt.Errorf("intset member %d at index %d is out of order", value, index)
+		}
+
+		members[index] = strconv.FormatInt(value, 10)
+		previous = value
+	}
+
+	return members, nil
+}
@@ -0,0 +1,90 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildIntset returns an intset of "size" byte integers holding "values".
+func buildIntset(size int, values ...int64) []byte {
+	buf := binary.LittleEndian.AppendUint32(nil, uint32(size))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(values)))
+
+	for _, value := range values {
+		for index := range size {
+			buf = append(buf, byte(value>>(8*index)))
+		}
+	}
+
+	return buf
+}
+
+func TestDecodeIntset(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []string
+	}{
+		{"empty", buildIntset(2), []string{}},
+		{"16 bit", buildIntset(2, -32768, -1, 0, 32767), []string{"-32768", "-1", "0", "32767"}},
+		{"32 bit", buildIntset(4, -2147483648, 65536, 2147483647), []string{"-2147483648", "65536", "2147483647"}},
+		{"64 bit", buildIntset(8, -1<<63, 1<<32, 1<<63-1), []string{"-9223372036854775808", "4294967296", "9223372036854775807"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeIntset(test.payload, true)
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeIntsetCorrupt(t *testing.T) {
+	valid := buildIntset(2, 1, 2, 3)
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		sanitize bool
+		err      string
+	}{
+		{"too short", valid[:7], false, "too short"},
+		{"unknown encoding", patch(valid, 0, 0x03), false, "unknown intset encoding 3"},
+		{"truncated", valid[:len(valid)-1], false, "does not match payload size"},
+		{"wrong length", patch(valid, 4, 0x04), false, "does not match payload size"},
+		{"out of order", buildIntset(4, 1, 3, 2), true, "member 2 at index 2 is out of order"},
+		{"duplicate", buildIntset(8, 5, 5), true, "member 5 at index 1 is out of order"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeIntset(test.payload, test.sanitize)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeIntsetUnsanitized(t *testing.T) {
+	// The order of the members is only checked when sanitizing.
+	got, err := decodeIntset(buildIntset(2, 3, 1), false)
+
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if want := []string{"3", "1"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
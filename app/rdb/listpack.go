@@ -0,0 +1,265 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A listpack is laid out as <total-bytes><num-elements><entry>...<entry><end>.
+// Every entry is made of its encoding, its contents and a "backlen" holding
+// the size of the first two, which allows the listpack to be walked backwards.
+const (
+	LISTPACK_HEADER_SIZE = 6
+	LISTPACK_END         = 0xFF
+	// Element counts above this value are not stored in the header.
+	LISTPACK_MAX_COUNT = 0xFFFF
+)
+
+const (
+	LISTPACK_STRING_32_BIT = 0xF0
+	LISTPACK_INT_16_BIT    = 0xF1
+	LISTPACK_INT_24_BIT    = 0xF2
+	LISTPACK_INT_32_BIT    = 0xF3
+	LISTPACK_INT_64_BIT    = 0xF4
+)
+
+func decodeListpackEntry(buf []byte) (string, int, error) {
+	if len(buf) == 0 {
+		return "", 0, errTruncatedEntry
+	}
+
+	encoding := buf[0]
+
+	switch {
+	// 0xxxxxxx: 7 bit unsigned integer
+	case encoding&0x80 == 0:
+		return strconv.Itoa(int(encoding)), 1, nil
+
+	// 10xxxxxx: string of up to 63 bytes
+	case encoding&0xC0 == 0x80:
+		return readEntryString(buf, 1, int(encoding&0x3F))
+
+	// 110xxxxx yyyyyyyy: 13 bit signed integer
+	case encoding&0xE0 == 0xC0:
+		if len(buf) < 2 {
+			return "", 0, errTruncatedEntry
+		}
+
+		value := int(encoding&0x1F)<<8 | int(buf[1])
+
+		if value >= 1<<12 {
+			value -= 1 << 13
+		}
+
+		return strconv.Itoa(value), 2, nil
+
+	// 1110xxxx yyyyyyyy: string of up to 4095 bytes
+	case encoding&0xF0 == 0xE0:
+		if len(buf) < 2 {
+			return "", 0, errTruncatedEntry
+		}
+
+		return readEntryString(buf, 2, int(encoding&0x0F)<<8|int(buf[1]))
+	}
+
+	size := 0
+
+	switch encoding {
+	case LISTPACK_STRING_32_BIT:
+		if len(buf) < 5 {
+			return "", 0, errTruncatedEntry
+		}
+
+		return readEntryString(buf, 5, int(binary.LittleEndian.Uint32(buf[1:5])))
+
+	case LISTPACK_INT_16_BIT:
+		size = 2
+
+	case LISTPACK_INT_24_BIT:
+		size = 3
+
+	case LISTPACK_INT_32_BIT:
+		size = 4
+
+	case LISTPACK_INT_64_BIT:
+		size = 8
+
+	default:
+		return "", 0, fmt.Errorf("unknown entry encoding %x", encoding)
+	}
+
+	if len(buf) < 1+size {
+		return "", 0, errTruncatedEntry
+	}
+
+	return strconv.FormatInt(readInt(buf[1:], size), 10), 1 + size, nil
+}
+
+// listpackBacklenSize returns the number of bytes used to store the backlen
+// of an entry whose encoding and contents take up "length" bytes.
+func listpackBacklenSize(length int) int {
+	switch {
+	case length <= 127:
+		return 1
+	case length < 16383:
+		return 2
+	case length < 2097151:
+		return 3
+	case length < 268435455:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// decodeListpackBacklen reads a backlen front to back. The first byte holds
+// the most significant 7 bits and every byte after it has its MSB set.
+func decodeListpackBacklen(buf []byte) int {
+	length := 0
+
+	for _, b := range buf {
+		length = length<<7 | int(b&0x7F)
+	}
+
+	return length
+}
+
+// decodeListpack returns the entries of a listpack. When "sanitize" is set
+// the header fields and backlens are validated against the entries.
+func decodeListpack(buf []byte, sanitize bool) ([]string, error) {
+	if len(buf) < LISTPACK_HEADER_SIZE+1 {
+		return nil, fmt.Errorf("listpack of %d bytes is too short", len(buf))
+	}
+
+	totalBytes := int(binary.LittleEndian.Uint32(buf[0:4]))
+	count := int(binary.LittleEndian.Uint16(buf[4:6]))
+
+	if sanitize && totalBytes != len(buf) {
+		return nil, fmt.Errorf("listpack header size %d does not match payload size %d", totalBytes, len(buf))
+	}
+
+	entries := []string{}
+	position := LISTPACK_HEADER_SIZE
+
+	for {
+		if position >= len(buf) {
+			return nil, errors.New("listpack is missing its end marker")
+		}
+
+		if buf[position] == LISTPACK_END {
+			break
+		}
+
+		value, n, err := decodeListpackEntry(buf[position:])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid listpack entry at %d: %w", position, err)
+		}
+
+		backlenSize := listpackBacklenSize(n)
+
+		if position+n+backlenSize > len(buf) {
+			return nil, fmt.Errorf("invalid listpack entry at %d: %w", position, errTruncatedEntry)
+		}
+
+		if backlen := decodeListpackBacklen(buf[position+n : position+n+backlenSize]); sanitize && backlen != n {
+			return nil, fmt.Errorf("listpack entry at %d has backlen %d, expected %d", position, backlen, n)
+		}
+
+		position += n + backlenSize
+		entries = append(entries, value)
+	}
+
+	if sanitize {
+		if position != len(buf)-1 {
+			return nil, fmt.Errorf("listpack has %d trailing bytes", len(buf)-1-position)
+		}
+
+		if count != LISTPACK_MAX_COUNT && count != len(entries) {
+			return nil, fmt.Errorf("listpack header count %d does not match entry count %d", count, len(entries))
+		}
+	}
+
+	return entries, nil
+}
+
+// appendListpackEntry appends "value" to a listpack buffer using the
+// smallest encoding able to hold it, followed by its backlen. Strings that
+// hold an integer are stored as integers, like Redis does.
+func appendListpackEntry(buf []byte, value string) []byte {
+	start := len(buf)
+
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(number, 10) == value {
+		switch {
+		case number >= 0 && number <= 127:
+			buf = append(buf, byte(number))
+
+		case number >= -4096 && number <= 4095:
+			buf = append(buf, 0xC0|byte(uint16(number)>>8)&0x1F, byte(number))
+
+		case number >= math.MinInt16 && number <= math.MaxInt16:
+			buf = append(buf, LISTPACK_INT_16_BIT)
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(number))
+
+		case number >= -(1<<23) && number < 1<<23:
+			buf = append(buf, LISTPACK_INT_24_BIT, byte(number), byte(number>>8), byte(number>>16))
+
+		case number >= math.MinInt32 && number <= math.MaxInt32:
+			buf = append(buf, LISTPACK_INT_32_BIT)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(number))
+
+		default:
+			buf = append(buf, LISTPACK_INT_64_BIT)
+			buf = binary.LittleEndian.AppendUint64(buf, uint64(number))
+		}
+	} else {
+		switch {
+		case len(value) < 64:
+			buf = append(buf, 0x80|byte(len(value)))
+
+		case len(value) < 4096:
+			buf = append(buf, 0xE0|byte(len(value)>>8), byte(len(value)))
+
+		default:
+			buf = append(buf, LISTPACK_STRING_32_BIT)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+		}
+
+		buf = append(buf, value...)
+	}
+
+	// The backlen is the reverse of what decodeListpackBacklen reads: the
+	// most significant 7 bits come first and every other byte has its MSB set.
+	length := len(buf) - start
+	size := listpackBacklenSize(length)
+
+	for index := size - 1; index >= 0; index-- {
+		b := byte(length>>(7*index)) & 0x7F
+
+		if index != size-1 {
+			b |= 0x80
+		}
+
+		buf = append(buf, b)
+	}
+
+	return buf
+}
+
+// encodeListpack returns a listpack holding "entries".
+func encodeListpack(entries []string) []byte {
+	buf := make([]byte, LISTPACK_HEADER_SIZE, 64)
+
+	for _, entry := range entries {
+		buf = appendListpackEntry(buf, entry)
+	}
+
+	buf = append(buf, LISTPACK_END)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(min(len(entries), LISTPACK_MAX_COUNT)))
+
+	return buf
+}
@@ -0,0 +1,121 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildListpack returns a listpack holding entries made of an encoding and
+// its contents, with the header and backlens filled in.
+func buildListpack(entries ...[]byte) []byte {
+	buf := make([]byte, LISTPACK_HEADER_SIZE)
+
+	for _, entry := range entries {
+		buf = append(buf, entry...)
+		size := listpackBacklenSize(len(entry))
+
+		for index := size - 1; index >= 0; index-- {
+			b := byte(len(entry)>>(7*index)) & 0x7F
+
+			if index != size-1 {
+				b |= 0x80
+			}
+
+			buf = append(buf, b)
+		}
+	}
+
+	buf = append(buf, LISTPACK_END)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(len(entries)))
+
+	return buf
+}
+
+func TestDecodeListpack(t *testing.T) {
+	long := strings.Repeat("a", 200)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []string
+	}{
+		{"empty", buildListpack(), []string{}},
+		{"7 bit unsigned integer", buildListpack([]byte{0x00}, []byte{0x7F}), []string{"0", "127"}},
+		{"6 bit string", buildListpack([]byte("\x83foo")), []string{"foo"}},
+		{"13 bit integer", buildListpack([]byte{0xCF, 0xFF}, []byte{0xDF, 0xFF}, []byte{0xD0, 0x00}), []string{"4095", "-1", "-4096"}},
+		{"12 bit string", buildListpack(append([]byte{0xE0, 0xC8}, long...)), []string{long}},
+		{"32 bit string", buildListpack([]byte("\xF0\x05\x00\x00\x00hello")), []string{"hello"}},
+		{"16 bit integer", buildListpack([]byte{0xF1, 0x00, 0x80}), []string{"-32768"}},
+		{"24 bit integer", buildListpack([]byte{0xF2, 0x00, 0x00, 0x80}), []string{"-8388608"}},
+		{"32 bit integer", buildListpack([]byte{0xF3, 0xFF, 0xFF, 0xFF, 0x7F}), []string{"2147483647"}},
+		{"64 bit integer", buildListpack([]byte{0xF4, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}), []string{"-1"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeListpack(test.payload, true)
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeListpackCorrupt(t *testing.T) {
+	valid := buildListpack([]byte("\x83foo"), []byte{0x01})
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		sanitize bool
+		err      string
+	}{
+		{"too short", []byte{0x07, 0x00, 0x00}, false, "too short"},
+		{"missing end marker", valid[:len(valid)-1], false, "missing its end marker"},
+		{"truncated string", buildListpack([]byte("\x85ab")), false, "past the end"},
+		{"truncated integer", buildListpack([]byte{0xF3, 0x01}), false, "past the end"},
+		{"truncated backlen", []byte{0x07, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01}, false, "past the end"},
+		{"unknown encoding", buildListpack([]byte{0xF5}), false, "unknown entry encoding f5"},
+		{"wrong total size", valid[:len(valid)-1], true, "header size"},
+		{"wrong backlen", patch(valid, LISTPACK_HEADER_SIZE+4, 0x03), true, "backlen 3, expected 4"},
+		{"wrong count", patch(valid, 4, 0x03), true, "header count 3"},
+		{"trailing bytes", append(patch(valid, 0, byte(len(valid)+1)), 0x00), true, "1 trailing bytes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeListpack(test.payload, test.sanitize)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestEncodeListpack(t *testing.T) {
+	entries := []string{
+		"0", "127", "128", "-4096", "4095", "-32768", "32767", "-8388608", "8388607",
+		"-2147483648", "2147483647", "-9223372036854775808", "9223372036854775807",
+		"", "foo", "007", "1.5", "18446744073709551616",
+		strings.Repeat("a", 63), strings.Repeat("b", 4095), strings.Repeat("c", 4096),
+	}
+
+	got, err := decodeListpack(encodeListpack(entries), true)
+
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if !slices.Equal(got, entries) {
+		t.Errorf("got %q, want %q", got, entries)
+	}
+}
@@ -0,0 +1,125 @@
+package rdb
+
+import (
+	"fmt"
+	"io"
+)
+
+// Op codes used by modules to serialize their data. Module data saved along
+// with these op codes can be skipped without knowing anything about the module.
+const (
+	MODULE_OPCODE_EOF = iota
+	MODULE_OPCODE_SINT
+	MODULE_OPCODE_UINT
+	MODULE_OPCODE_FLOAT
+	MODULE_OPCODE_DOUBLE
+	MODULE_OPCODE_STRING
+)
+
+const (
+	// Characters a module type name is made of, indexed by their 6 bit code.
+	MODULE_TYPE_NAME_CHARSET = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+	MODULE_TYPE_NAME_LENGTH  = 9
+	// The lower 10 bits of a module ID hold the encoding version.
+	MODULE_ENCODING_VERSION_BITS = 10
+)
+
+// ModuleAux describes auxiliary data saved by a module outside of the keyspace.
+type ModuleAux struct {
+	ModuleID uint64
+	// Name is the name of the module type that saved the data.
+	Name            string
+	EncodingVersion int
+	// When is either 1 or 2, for data saved before or after the keyspace.
+	When int
+}
+
+// moduleTypeName decodes the name of a module type from the 54 bits that
+// precede the encoding version in a module ID.
+func moduleTypeName(moduleID uint64) string {
+	name := make([]byte, MODULE_TYPE_NAME_LENGTH)
+	id := moduleID >> MODULE_ENCODING_VERSION_BITS
+
+	for index := MODULE_TYPE_NAME_LENGTH - 1; index >= 0; index-- {
+		name[index] = MODULE_TYPE_NAME_CHARSET[id&63]
+		id >>= 6
+	}
+
+	return string(name)
+}
+
+func (p *Parser) parseModuleAux() (ModuleAux, error) {
+	errMsg := func(err error) error {
+		return fmt.Errorf("failed to parse module auxiliary data: %w", err)
+	}
+
+	var aux ModuleAux
+	offset := p.r.offset
+
+	moduleID, _, err := p.parseLength()
+
+	if err != nil {
+		return aux, errMsg(err)
+	}
+
+	aux.ModuleID = uint64(moduleID)
+	aux.Name = moduleTypeName(aux.ModuleID)
+	aux.EncodingVersion = int(aux.ModuleID & (1<<MODULE_ENCODING_VERSION_BITS - 1))
+
+	whenOpCode, err := p.parseSize()
+
+	if err != nil {
+		return aux, errMsg(err)
+	}
+
+	if whenOpCode != MODULE_OPCODE_UINT {
+		return aux, p.corruptionError(offset, "invalid \"when\" op code %d in auxiliary data of module \"%s\"", whenOpCode, aux.Name)
+	}
+
+	if aux.When, err = p.parseSize(); err != nil {
+		return aux, errMsg(err)
+	}
+
+	if err := p.skipModuleValue(aux.Name); err != nil {
+		return aux, err
+	}
+
+	return aux, nil
+}
+
+// skipModuleValue reads past data serialized by a module up to and including
+// its EOF op code.
+func (p *Parser) skipModuleValue(name string) error {
+	for {
+		offset := p.r.offset
+		opCode, err := p.parseSize()
+
+		if err != nil {
+			return fmt.Errorf("failed to parse data of module \"%s\": %w", name, err)
+		}
+
+		switch opCode {
+		case MODULE_OPCODE_EOF:
+			return nil
+
+		case MODULE_OPCODE_SINT, MODULE_OPCODE_UINT:
+			_, _, err = p.parseLength()
+
+		case MODULE_OPCODE_FLOAT:
+			_, err = io.CopyN(io.Discard, p.r, 4)
+
+		case MODULE_OPCODE_DOUBLE:
+			_, err = io.CopyN(io.Discard, p.r, 8)
+
+		case MODULE_OPCODE_STRING:
+			_, err = p.parseString()
+
+		default:
+			return p.corruptionError(offset, "unknown op code %d in data of module \"%s\"", opCode, name)
+		}
+
+		if err != nil {
+			return fmt.Errorf("failed to parse data of module \"%s\": %w", name, err)
+		}
+	}
+}
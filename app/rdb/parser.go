@@ -1,14 +1,13 @@
 package rdb
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"slices"
 	"strconv"
 	"time"
 
@@ -20,10 +19,46 @@ type DatabaseEntry struct {
 	Key           string
 	Value         any
 	Expiry        time.Time
+	// Idle is the time since the key was last accessed, stored by servers
+	// running an LRU eviction policy. It is -1 when not stored.
+	Idle time.Duration
+	// Freq is the LFU access frequency counter of the key, stored by servers
+	// running an LFU eviction policy. It is -1 when not stored.
+	Freq int
+}
+
+// SlotInfo holds the number of keys stored in a cluster hash slot.
+type SlotInfo struct {
+	Slot        int
+	Size        int
+	ExpiresSize int
 }
 
 type Parser struct {
-	r *bufio.Reader
+	functions []string
+	// key is the key of the entry being parsed, or of the last one parsed.
+	key       string
+	moduleAux []ModuleAux
+	opts      ParserOpts
+	r         *reader
+	slotInfo  []SlotInfo
+	version   int
+}
+
+type ParserOpts struct {
+	// When set, the parser performs deep validation of every value it loads
+	// (e.g. duplicate set members or hash fields) instead of trusting the
+	// payload, similar to Redis' "sanitize-dump-payload" option.
+	SanitizePayload bool
+}
+
+// CorruptionError is returned when the contents of an RDB file are
+// truncated, fail checksum verification or fail payload sanitization.
+type CorruptionError struct {
+	// Offset is the position in the file, in bytes, where corruption was detected.
+	Offset int64
+	Reason string
+	Err    error
 }
 
 const (
@@ -39,6 +74,18 @@ const (
 	OP_RESIZE_DB = 0xFB
 	// Database Selector
 	OP_SELECT_DB = 0xFE
+	// Time in seconds since the following key was last accessed
+	OP_IDLE = 0xF8
+	// LFU access frequency of the following key
+	OP_FREQ = 0xF9
+	// Auxiliary data saved by a module
+	OP_MODULE_AUX = 0xF7
+	// Function library, in the format used by Redis 7.0 pre-releases
+	OP_FUNCTION_PRE_GA = 0xF6
+	// Function library
+	OP_FUNCTION2 = 0xF5
+	// Key counts of a cluster hash slot
+	OP_SLOT_INFO = 0xF4
 )
 
 const (
@@ -48,8 +95,9 @@ const (
 )
 
 const (
-	LENGTH_ENCODING_MASK  = 0b11000000 // Mask for the two MSBs in length encoding
-	LENGTH_ENCODING_SHIFT = 6          // Number of bits to shift to get encoding type
+	LENGTH_ENCODING_MASK   = 0b11000000 // Mask for the two MSBs in length encoding
+	LENGTH_ENCODING_SHIFT  = 6          // Number of bits to shift to get encoding type
+	LENGTH_ENCODING_64_BIT = 0x81       // First byte of a length stored in the following 8 bytes
 )
 
 const (
@@ -67,12 +115,47 @@ const (
 	SET_ENCODING
 	SORTED_SET_ENCODING
 	HASH_MAP_ENCODING
-	ZIP_MAP_ENCODING = iota + 4
+	SORTED_SET_2_ENCODING
+	MODULE_ENCODING
+	MODULE_2_ENCODING
+	ZIP_MAP_ENCODING = iota + 1
 	ZIP_LIST_ENCODING
 	INT_SET_ENCODING
 	SORTED_SET_IN_ZIP_LIST_ENCODING
 	HASH_MAP_IN_ZIP_LIST_ENCODING
 	LIST_IN_QUICK_LIST_ENCODING
+	STREAM_LIST_PACKS_ENCODING
+	HASH_MAP_IN_LIST_PACK_ENCODING
+	SORTED_SET_IN_LIST_PACK_ENCODING
+	LIST_IN_QUICK_LIST_2_ENCODING
+	STREAM_LIST_PACKS_2_ENCODING
+	SET_IN_LIST_PACK_ENCODING
+	STREAM_LIST_PACKS_3_ENCODING
+)
+
+// Quicklist nodes in LIST_IN_QUICK_LIST_2_ENCODING are either a single plain
+// element or a listpack of elements.
+const (
+	QUICK_LIST_NODE_PLAIN  = 1
+	QUICK_LIST_NODE_PACKED = 2
+)
+
+// Special length values used when a double is stored as a string.
+const (
+	DOUBLE_NAN               = 253
+	DOUBLE_POSITIVE_INFINITY = 254
+	DOUBLE_NEGATIVE_INFINITY = 255
+)
+
+const (
+	// The first RDB version to append a CRC64 checksum after the EOF op code.
+	CHECKSUM_MIN_VERSION = 5
+	// The largest buffer allocated up front for a string, so that a corrupt
+	// length fails at the end of the input instead of allocating it.
+	STRING_PREALLOCATE_LIMIT = 64 * 1024
+	// An LZF back reference of 3 bytes expands to at most 264 bytes, which
+	// bounds the uncompressed length of a compressed string.
+	LZF_MAX_EXPANSION = 88
 )
 
 var (
@@ -80,12 +163,22 @@ var (
 	errExpectedLengthEncodedInt = errors.New("expected a length-encoded integer")
 )
 
-func NewParser() *Parser {
-	return &Parser{}
+func NewParser(opts ParserOpts) *Parser {
+	return &Parser{
+		opts: opts,
+	}
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("corrupt rdb file at offset %d: %s", e.Offset, e.Reason)
 }
 
-func isSectionIndicator(opCode byte) bool {
-	return slices.Contains([]byte{OP_AUX, OP_EOF, OP_SELECT_DB}, opCode)
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
+
+func (p *Parser) corruptionError(offset int64, format string, args ...any) error {
+	return &CorruptionError{Offset: offset, Reason: fmt.Sprintf(format, args...)}
 }
 
 func (p *Parser) checkHeader() error {
@@ -105,135 +198,91 @@ func (p *Parser) checkHeader() error {
 		return fmt.Errorf("failed to parse redis version number from file: %w", err)
 	}
 
-	if _, err := strconv.Atoi(string(versionNumberBuf)); err != nil {
+	version, err := strconv.Atoi(string(versionNumberBuf))
+
+	if err != nil {
 		return fmt.Errorf("%w: redis version number is invalid", err)
 	}
 
+	p.version = version
+
 	return nil
 }
 
-func (p *Parser) parseAuxFields() error {
-	errMsg := "failed to parse metadata section"
-
-	// we currently don't do anything with the data from this section
-	for {
-		nextByte, err := p.r.Peek(1)
-
-		if err != nil {
-			return fmt.Errorf("%s:%w", errMsg, err)
-		}
-
-		if isSectionIndicator(nextByte[0]) {
-			break
-		}
-
-		if _, err := p.parseString(); err != nil {
-			return fmt.Errorf("%s:%w", errMsg, err)
-		}
-
-		if _, err := p.parseString(); err != nil {
-			return fmt.Errorf("%s:%w", errMsg, err)
-		}
+// verifyChecksum reads the checksum that follows the EOF op code and compares
+// it against the checksum of every byte read before it.
+func (p *Parser) verifyChecksum() error {
+	if p.version < CHECKSUM_MIN_VERSION {
+		return nil
 	}
 
-	return nil
-}
+	expected := p.r.crc
+	offset := p.r.offset
+	buf := make([]byte, 8)
 
-func (p *Parser) parseDatabase() ([]DatabaseEntry, error) {
-	errMsg := func(err error) error {
-		return fmt.Errorf("failed to parse database: %w", err)
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return &CorruptionError{Offset: offset, Reason: "missing checksum", Err: err}
 	}
 
-	dbIndex, err := p.parseSize()
+	checksum := binary.LittleEndian.Uint64(buf)
 
-	if err != nil {
-		return nil, err
+	// Files written with "rdbchecksum no" carry a zero checksum, which Redis skips.
+	if checksum == 0 {
+		return nil
 	}
 
-	if _, _, err := p.parseDatabaseHashTableSizes(); err != nil {
-		return nil, errMsg(err)
+	if checksum != expected {
+		return p.corruptionError(offset, "checksum mismatch, expected %016x but got %016x", expected, checksum)
 	}
 
-	entries := []DatabaseEntry{}
-
-	for {
-		buf, err := p.r.Peek(1)
-
-		if err != nil {
-			return nil, errMsg(err)
-		}
-
-		if isSectionIndicator(buf[0]) {
-			return entries, nil
-		}
-
-		entry, err := p.parseDatabaseEntry(dbIndex)
-
-		if err != nil {
-			return nil, err
-		}
-
-		entries = append(entries, entry)
-	}
+	return nil
 }
 
-func (p *Parser) parseDatabaseEntry(dbIndex int) (DatabaseEntry, error) {
-	var entry DatabaseEntry
-	expiry := time.Time{}
+func (p *Parser) parseAuxField() (string, string, error) {
+	errMsg := "failed to parse auxiliary field"
 
-	b, err := p.r.Peek(1)
+	key, err := p.parseString()
 
 	if err != nil {
-		return entry, fmt.Errorf("failed to parse database entry: %w", err)
-	}
-
-	if slices.Contains([]byte{OP_EXPIRE_TIME, OP_EXPIRE_TIME_MS}, b[0]) {
-		timestamp, err := p.parseDatabaseEntryExpiry()
-
-		if err != nil {
-			return entry, err
-		}
-
-		expiry = timestamp
+		return "", "", fmt.Errorf("%s:%w", errMsg, err)
 	}
 
-	valueEncoding, err := p.parseValueEncoding()
+	value, err := p.parseString()
 
 	if err != nil {
-		return entry, err
+		return "", "", fmt.Errorf("%s:%w", errMsg, err)
 	}
 
+	return key, value, nil
+}
+
+// parseDatabaseEntry reads the key and value of an entry whose type was just
+// read, on top of the expiry, idle time and frequency that preceded it.
+func (p *Parser) parseDatabaseEntry(entry DatabaseEntry, valueEncoding ValueEncoding) (DatabaseEntry, error) {
 	key, err := p.parseString()
 
 	if err != nil {
 		return entry, err
 	}
 
+	p.key = key
 	value, err := p.parseValue(valueEncoding)
 
 	if err != nil {
 		return entry, err
 	}
 
-	entry.DatabaseIndex = dbIndex
-	entry.Expiry = expiry
 	entry.Key = key
 	entry.Value = value
 
 	return entry, nil
 }
 
-func (p *Parser) parseDatabaseEntryExpiry() (time.Time, error) {
+func (p *Parser) parseDatabaseEntryExpiry(opCode byte) (time.Time, error) {
 	errMsg := func(err error) error {
 		return fmt.Errorf("failed to parse expire timestamp: %w", err)
 	}
 
-	opCode, err := p.r.ReadByte()
-
-	if err != nil {
-		return time.Time{}, errMsg(err)
-	}
-
 	switch opCode {
 	case OP_EXPIRE_TIME:
 		bufSize := 4
@@ -264,52 +313,65 @@ func (p *Parser) parseDatabaseHashTableSizes() (int, int, error) {
 		return fmt.Errorf("failed to parse database hash table sizes: %w", err)
 	}
 
-	b, err := p.r.Peek(1)
+	hashTableSize, err := p.parseSize()
 
 	if err != nil {
 		return 0, 0, errMsg(err)
 	}
 
-	if b[0] != OP_RESIZE_DB {
-		return 0, 0, nil
-	}
+	expireHashTableSize, err := p.parseSize()
 
-	// Read and discard the 'OP_RESIZE_DB' op code.
-	if _, err := p.r.ReadByte(); err != nil {
+	if err != nil {
 		return 0, 0, errMsg(err)
 	}
 
-	hashTableSize, err := p.parseSize()
+	return hashTableSize, expireHashTableSize, nil
+}
 
-	if err != nil {
-		return 0, 0, errMsg(err)
+func (p *Parser) parseSlotInfo() (SlotInfo, error) {
+	errMsg := func(err error) error {
+		return fmt.Errorf("failed to parse slot info: %w", err)
 	}
 
-	expireHashTableSize, err := p.parseSize()
+	var info SlotInfo
+	var err error
 
-	if err != nil {
-		return 0, 0, errMsg(err)
+	if info.Slot, err = p.parseSize(); err != nil {
+		return info, errMsg(err)
 	}
 
-	return hashTableSize, expireHashTableSize, nil
+	if info.Size, err = p.parseSize(); err != nil {
+		return info, errMsg(err)
+	}
+
+	if info.ExpiresSize, err = p.parseSize(); err != nil {
+		return info, errMsg(err)
+	}
+
+	return info, nil
 }
 
-func (p *Parser) parseHashMap() (map[string]string, error) {
+func (p *Parser) parseHashMap() (Hash, error) {
 	size, err := p.parseSize()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse hash map: %w", err)
 	}
 
-	hashMap := make(map[string]string, size)
+	hashMap := make(Hash, size)
 
 	for range size {
+		offset := p.r.offset
 		key, err := p.parseString()
 
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse hash map entry: %w", err)
 		}
 
+		if _, ok := hashMap[key]; ok && p.opts.SanitizePayload {
+			return nil, p.corruptionError(offset, "duplicate hash field \"%s\"", key)
+		}
+
 		value, err := p.parseString()
 
 		if err != nil {
@@ -355,12 +417,21 @@ func (p *Parser) parseLength() (int, bool, error) {
 	case LENGTH_ENCODING_32_BIT:
 		{
 			size := 4
+
+			if firstByte == LENGTH_ENCODING_64_BIT {
+				size = 8
+			}
+
 			buf := make([]byte, size)
 
 			if _, err := io.ReadAtLeast(p.r, buf, size); err != nil {
 				return 0, false, fmt.Errorf("%s:%w", errMsg, err)
 			}
 
+			if size == 8 {
+				return int(binary.BigEndian.Uint64(buf)), false, nil
+			}
+
 			return int(binary.BigEndian.Uint32(buf)), false, nil
 		}
 
@@ -369,14 +440,105 @@ func (p *Parser) parseLength() (int, bool, error) {
 	}
 }
 
-func (p *Parser) parseList() ([]string, error) {
+// parseDouble reads a score stored as a length-prefixed decimal string, where
+// the length values 253, 254 and 255 stand for NaN, +inf and -inf.
+func (p *Parser) parseDouble() (float64, error) {
+	length, err := p.r.ReadByte()
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse double: %w", err)
+	}
+
+	switch length {
+	case DOUBLE_NAN:
+		return math.NaN(), nil
+
+	case DOUBLE_POSITIVE_INFINITY:
+		return math.Inf(1), nil
+
+	case DOUBLE_NEGATIVE_INFINITY:
+		return math.Inf(-1), nil
+	}
+
+	offset := p.r.offset
+	buf := make([]byte, length)
+
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return 0, fmt.Errorf("failed to parse double: %w", err)
+	}
+
+	value, err := strconv.ParseFloat(string(buf), 64)
+
+	if err != nil {
+		return 0, p.corruptionError(offset, "invalid double \"%s\"", buf)
+	}
+
+	return value, nil
+}
+
+func (p *Parser) parseBinaryDouble() (float64, error) {
+	buf := make([]byte, 8)
+
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return 0, fmt.Errorf("failed to parse binary double: %w", err)
+	}
+
+	return math.Float64frombits(binary.LittleEndian.Uint64(buf)), nil
+}
+
+func (p *Parser) parseSortedSet(valueEncoding ValueEncoding) (SortedSet, error) {
+	offset := p.r.offset
+	size, err := p.parseSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sorted set size: %w", err)
+	}
+
+	sortedSet := make(SortedSet, 0, size)
+	members := make(map[string]struct{}, size)
+
+	for range size {
+		member, err := p.parseString()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sorted set member: %w", err)
+		}
+
+		var score float64
+
+		if valueEncoding == SORTED_SET_2_ENCODING {
+			score, err = p.parseBinaryDouble()
+		} else {
+			score, err = p.parseDouble()
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse score of sorted set member \"%s\": %w", member, err)
+		}
+
+		if _, ok := members[member]; ok && p.opts.SanitizePayload {
+			return nil, p.corruptionError(offset, "duplicate sorted set member \"%s\"", member)
+		}
+
+		if math.IsNaN(score) && p.opts.SanitizePayload {
+			return nil, p.corruptionError(offset, "sorted set member \"%s\" has a NaN score", member)
+		}
+
+		members[member] = struct{}{}
+		sortedSet = append(sortedSet, SortedSetEntry{Member: member, Score: score})
+	}
+
+	return sortedSet, nil
+}
+
+func (p *Parser) parseList() (List, error) {
 	listSize, err := p.parseSize()
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse list size: %w", err)
 	}
 
-	list := make([]string, listSize)
+	list := make(List, listSize)
 
 	for index := range listSize {
 		entry, err := p.parseString()
@@ -391,6 +553,207 @@ func (p *Parser) parseList() ([]string, error) {
 	return list, nil
 }
 
+func (p *Parser) parseSet() (Set, error) {
+	offset := p.r.offset
+	list, err := p.parseList()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.toSet(offset, list)
+}
+
+// parseBlob reads a string holding a serialized data structure along with
+// the offset it starts at, so decoding errors can point back into the file.
+func (p *Parser) parseBlob() ([]byte, int64, error) {
+	offset := p.r.offset
+	blob, err := p.parseString()
+
+	if err != nil {
+		return nil, offset, err
+	}
+
+	return []byte(blob), offset, nil
+}
+
+// parseEncodedBlob reads a blob and decodes it with "decode", which is one of
+// the ziplist, listpack, intset or zipmap decoders.
+func (p *Parser) parseEncodedBlob(name string, decode func([]byte, bool) ([]string, error)) ([]string, int64, error) {
+	blob, offset, err := p.parseBlob()
+
+	if err != nil {
+		return nil, offset, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+
+	entries, err := decode(blob, p.opts.SanitizePayload)
+
+	if err != nil {
+		return nil, offset, &CorruptionError{Offset: offset, Reason: fmt.Sprintf("invalid %s: %v", name, err), Err: err}
+	}
+
+	return entries, offset, nil
+}
+
+func (p *Parser) parseQuickList() (List, error) {
+	nodes, err := p.parseSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse quicklist size: %w", err)
+	}
+
+	list := List{}
+
+	for range nodes {
+		entries, _, err := p.parseEncodedBlob("ziplist", decodeZiplist)
+
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, entries...)
+	}
+
+	return list, nil
+}
+
+func (p *Parser) parseQuickList2() (List, error) {
+	nodes, err := p.parseSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse quicklist size: %w", err)
+	}
+
+	list := List{}
+
+	for range nodes {
+		offset := p.r.offset
+		container, err := p.parseSize()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse quicklist node container: %w", err)
+		}
+
+		switch container {
+		case QUICK_LIST_NODE_PLAIN:
+			entry, err := p.parseString()
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse quicklist node: %w", err)
+			}
+
+			list = append(list, entry)
+
+		case QUICK_LIST_NODE_PACKED:
+			entries, offset, err := p.parseEncodedBlob("listpack", decodeListpack)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if len(entries) == 0 && p.opts.SanitizePayload {
+				return nil, p.corruptionError(offset, "empty quicklist node")
+			}
+
+			list = append(list, entries...)
+
+		default:
+			return nil, p.corruptionError(offset, "unknown quicklist node container %d", container)
+		}
+	}
+
+	return list, nil
+}
+
+func (p *Parser) parseEncodedHashMap(name string, decode func([]byte, bool) ([]string, error)) (Hash, error) {
+	entries, offset, err := p.parseEncodedBlob(name, decode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries)%2 != 0 {
+		return nil, p.corruptionError(offset, "%s holds an odd number of hash entries", name)
+	}
+
+	hashMap := make(Hash, len(entries)/2)
+
+	for index := 0; index < len(entries); index += 2 {
+		key := entries[index]
+
+		if _, ok := hashMap[key]; ok && p.opts.SanitizePayload {
+			return nil, p.corruptionError(offset, "duplicate hash field \"%s\"", key)
+		}
+
+		hashMap[key] = entries[index+1]
+	}
+
+	return hashMap, nil
+}
+
+func (p *Parser) parseEncodedSet(name string, decode func([]byte, bool) ([]string, error)) (Set, error) {
+	entries, offset, err := p.parseEncodedBlob(name, decode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.toSet(offset, entries)
+}
+
+func (p *Parser) parseEncodedSortedSet(name string, decode func([]byte, bool) ([]string, error)) (SortedSet, error) {
+	entries, offset, err := p.parseEncodedBlob(name, decode)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries)%2 != 0 {
+		return nil, p.corruptionError(offset, "%s holds an odd number of sorted set entries", name)
+	}
+
+	sortedSet := make(SortedSet, 0, len(entries)/2)
+	members := make(map[string]struct{}, len(entries)/2)
+
+	for index := 0; index < len(entries); index += 2 {
+		member := entries[index]
+		score, err := strconv.ParseFloat(entries[index+1], 64)
+
+		if err != nil {
+			return nil, p.corruptionError(offset, "invalid score \"%s\" for sorted set member \"%s\"", entries[index+1], member)
+		}
+
+		if _, ok := members[member]; ok && p.opts.SanitizePayload {
+			return nil, p.corruptionError(offset, "duplicate sorted set member \"%s\"", member)
+		}
+
+		members[member] = struct{}{}
+		sortedSet = append(sortedSet, SortedSetEntry{Member: member, Score: score})
+	}
+
+	return sortedSet, nil
+}
+
+// toSet converts the members parsed from the value at "offset" to a set,
+// checking them for duplicates when sanitizing the payload.
+func (p *Parser) toSet(offset int64, members []string) (Set, error) {
+	if !p.opts.SanitizePayload {
+		return Set(members), nil
+	}
+
+	seen := make(map[string]struct{}, len(members))
+
+	for _, member := range members {
+		if _, ok := seen[member]; ok {
+			return nil, p.corruptionError(offset, "duplicate set member \"%s\"", member)
+		}
+
+		seen[member] = struct{}{}
+	}
+
+	return Set(members), nil
+}
+
 func (p *Parser) parseCompressedString() (string, error) {
 	errMsg := "failed to parse compressed string"
 	compressedLength, err := p.parseSize()
@@ -405,13 +768,17 @@ func (p *Parser) parseCompressedString() (string, error) {
 		return "", fmt.Errorf("failed to parse uncompressed length:%w", err)
 	}
 
-	inputBuf := make([]byte, compressedLength)
-	outputBuf := make([]byte, uncompressedLength)
+	inputBuf, err := p.readBytes(compressedLength)
 
-	if _, err := io.ReadAtLeast(p.r, inputBuf, compressedLength); err != nil {
+	if err != nil {
 		return "", fmt.Errorf("%s:%w", errMsg, err)
 	}
 
+	if uncompressedLength > compressedLength*LZF_MAX_EXPANSION {
+		return "", p.corruptionError(p.r.offset, "uncompressed length %d is out of range", uncompressedLength)
+	}
+
+	outputBuf := make([]byte, uncompressedLength)
 	n, err := lzf.Decompress(inputBuf, outputBuf)
 
 	if err != nil {
@@ -436,9 +803,29 @@ func (p *Parser) parseSize() (int, error) {
 		return 0, errExpectedLengthEncodedInt
 	}
 
+	if size < 0 {
+		return 0, p.corruptionError(p.r.offset, "size %d is out of range", size)
+	}
+
 	return size, nil
 }
 
+// readBytes reads "length" bytes, growing the buffer as they arrive.
+func (p *Parser) readBytes(length int) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(min(length, STRING_PREALLOCATE_LIMIT))
+
+	if _, err := io.CopyN(&buf, p.r, int64(length)); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 func (p *Parser) parseString() (string, error) {
 	errMsg := "failed to parse string"
 	length, isEncoded, err := p.parseLength()
@@ -448,9 +835,13 @@ func (p *Parser) parseString() (string, error) {
 	}
 
 	if !isEncoded {
-		buf := make([]byte, length)
+		if length < 0 {
+			return "", p.corruptionError(p.r.offset, "string length %d is out of range", length)
+		}
+
+		buf, err := p.readBytes(length)
 
-		if _, err := io.ReadAtLeast(p.r, buf, length); err != nil {
+		if err != nil {
 			return "", fmt.Errorf("%s:%w", errMsg, err)
 		}
 
@@ -466,7 +857,7 @@ func (p *Parser) parseString() (string, error) {
 				return "", fmt.Errorf("%s:%w", errMsg, err)
 			}
 
-			return strconv.Itoa(int(intByte)), nil
+			return strconv.Itoa(int(int8(intByte))), nil
 		}
 
 	case INTEGER_STRING_16_BIT:
@@ -478,7 +869,7 @@ func (p *Parser) parseString() (string, error) {
 				return "", fmt.Errorf("%s:%w", errMsg, err)
 			}
 
-			return strconv.Itoa(int(binary.LittleEndian.Uint16(buf))), nil
+			return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
 		}
 
 	case INTEGER_STRING_32_BIT:
@@ -490,7 +881,7 @@ func (p *Parser) parseString() (string, error) {
 				return "", fmt.Errorf("%s:%w", errMsg, err)
 			}
 
-			return strconv.Itoa(int(binary.LittleEndian.Uint32(buf))), nil
+			return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
 		}
 
 	case COMPRESSED_STRING:
@@ -510,71 +901,274 @@ func (p *Parser) parseValue(valueEncoding ValueEncoding) (any, error) {
 	case STRING_ENCODING:
 		return p.parseString()
 
-	case LIST_ENCODING, SET_ENCODING:
+	case LIST_ENCODING:
 		return p.parseList()
 
+	case SET_ENCODING:
+		return p.parseSet()
+
+	case SORTED_SET_ENCODING, SORTED_SET_2_ENCODING:
+		return p.parseSortedSet(valueEncoding)
+
 	case HASH_MAP_ENCODING:
 		return p.parseHashMap()
 
+	case ZIP_MAP_ENCODING:
+		return p.parseEncodedHashMap("zipmap", decodeZipmap)
+
+	case ZIP_LIST_ENCODING:
+		entries, _, err := p.parseEncodedBlob("ziplist", decodeZiplist)
+		return List(entries), err
+
+	case INT_SET_ENCODING:
+		return p.parseEncodedSet("intset", decodeIntset)
+
+	case SORTED_SET_IN_ZIP_LIST_ENCODING:
+		return p.parseEncodedSortedSet("ziplist", decodeZiplist)
+
+	case HASH_MAP_IN_ZIP_LIST_ENCODING:
+		return p.parseEncodedHashMap("ziplist", decodeZiplist)
+
+	case LIST_IN_QUICK_LIST_ENCODING:
+		return p.parseQuickList()
+
+	case HASH_MAP_IN_LIST_PACK_ENCODING:
+		return p.parseEncodedHashMap("listpack", decodeListpack)
+
+	case SORTED_SET_IN_LIST_PACK_ENCODING:
+		return p.parseEncodedSortedSet("listpack", decodeListpack)
+
+	case LIST_IN_QUICK_LIST_2_ENCODING:
+		return p.parseQuickList2()
+
+	case SET_IN_LIST_PACK_ENCODING:
+		return p.parseEncodedSet("listpack", decodeListpack)
+
+	case STREAM_LIST_PACKS_ENCODING, STREAM_LIST_PACKS_2_ENCODING, STREAM_LIST_PACKS_3_ENCODING:
+		return p.parseStream(valueEncoding)
+
 	default:
 		return nil, fmt.Errorf("unknown value encoding: %d", valueEncoding)
 	}
 }
 
-func (p *Parser) parseValueEncoding() (ValueEncoding, error) {
-	b, err := p.r.ReadByte()
+// Parse reads the whole RDB file at "src" into memory. Use ParseReader to
+// process large files one record at a time.
+func (p *Parser) Parse(src string) ([]DatabaseEntry, error) {
+	fd, err := os.Open(src)
 
 	if err != nil {
-		return 0, fmt.Errorf("failed to read value encoding: %w", err)
+		return nil, fmt.Errorf("failed to open file '%s': %w", src, err)
+	}
+
+	defer fd.Close()
+
+	p.functions = nil
+	p.moduleAux = nil
+	p.slotInfo = nil
+
+	c := &collector{entries: []DatabaseEntry{}, p: p}
+
+	if err := p.ParseReader(fd, c); err != nil {
+		return nil, err
 	}
 
-	return ValueEncoding(b), nil
+	return c.entries, nil
 }
 
-func (p *Parser) Parse(src string) ([]DatabaseEntry, error) {
-	fd, err := os.Open(src)
+// ParseReader parses an RDB payload from "r" and hands every record to "v"
+// as soon as it is read, so only one entry is held in memory at a time. The
+// parser may buffer data past the end of the payload, so payloads followed by
+// other data must be wrapped in an io.LimitReader.
+func (p *Parser) ParseReader(r io.Reader, v Visitor) error {
+	p.r = newReader(r)
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file '%s': %w", src, err)
+	err := p.parse(v)
+	var corruptionErr *CorruptionError
+
+	// Keep the reason and offset of errors that already describe a
+	// truncation, such as a missing checksum.
+	if errors.As(err, &corruptionErr) {
+		return err
 	}
 
-	defer fd.Close()
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return &CorruptionError{Offset: p.r.offset, Reason: "unexpected end of file", Err: err}
+	}
 
-	p.r = bufio.NewReader(fd)
+	return err
+}
 
+// Key returns the key of the last entry the parser started reading, which
+// is the one being parsed when parsing fails.
+func (p *Parser) Key() string {
+	return p.key
+}
+
+// Offset returns the number of bytes of the file consumed so far.
+func (p *Parser) Offset() int64 {
+	if p.r == nil {
+		return 0
+	}
+
+	return p.r.offset
+}
+
+// Functions returns the source code of the function libraries stored in the
+// file read by the last call to Parse.
+func (p *Parser) Functions() []string {
+	return p.functions
+}
+
+// ModuleAux returns the auxiliary module data stored in the file read by the
+// last call to Parse. The data itself is skipped as we have no modules to
+// hand it to.
+func (p *Parser) ModuleAux() []ModuleAux {
+	return p.moduleAux
+}
+
+// SlotInfo returns the cluster slot sizes stored in the file read by the last
+// call to Parse.
+func (p *Parser) SlotInfo() []SlotInfo {
+	return p.slotInfo
+}
+
+func (p *Parser) parse(v Visitor) error {
 	if err := p.checkHeader(); err != nil {
-		return nil, err
+		return err
 	}
 
-	entries := []DatabaseEntry{}
+	dbIndex := 0
+	// The expiry, idle time and frequency of a key are stored before its type.
+	entry := DatabaseEntry{Idle: -1, Freq: -1}
 
 	for {
 		opCode, err := p.r.ReadByte()
 
 		if err != nil {
-			return nil, err
+			return err
 		}
 
 		switch opCode {
+		case OP_EXPIRE_TIME, OP_EXPIRE_TIME_MS:
+			expiry, err := p.parseDatabaseEntryExpiry(opCode)
+
+			if err != nil {
+				return err
+			}
+
+			entry.Expiry = expiry
+
+		case OP_IDLE:
+			idle, err := p.parseSize()
+
+			if err != nil {
+				return fmt.Errorf("failed to parse idle time: %w", err)
+			}
+
+			entry.Idle = time.Duration(idle) * time.Second
+
+		case OP_FREQ:
+			freq, err := p.r.ReadByte()
+
+			if err != nil {
+				return fmt.Errorf("failed to parse access frequency: %w", err)
+			}
+
+			entry.Freq = int(freq)
+
 		case OP_AUX:
-			if err := p.parseAuxFields(); err != nil {
-				return nil, err
+			key, value, err := p.parseAuxField()
+
+			if err != nil {
+				return err
+			}
+
+			if err := v.OnAux(key, value); err != nil {
+				return err
 			}
 
 		case OP_SELECT_DB:
-			dbEntries, err := p.parseDatabase()
+			index, err := p.parseSize()
 
 			if err != nil {
-				return nil, err
+				return fmt.Errorf("failed to parse database index: %w", err)
 			}
 
-			entries = append(entries, dbEntries...)
+			dbIndex = index
+
+			if err := v.OnSelectDB(index); err != nil {
+				return err
+			}
+
+		case OP_RESIZE_DB:
+			size, expiresSize, err := p.parseDatabaseHashTableSizes()
+
+			if err != nil {
+				return err
+			}
+
+			if err := v.OnResizeDB(size, expiresSize); err != nil {
+				return err
+			}
+
+		case OP_SLOT_INFO:
+			info, err := p.parseSlotInfo()
+
+			if err != nil {
+				return err
+			}
+
+			if err := v.OnSlotInfo(info); err != nil {
+				return err
+			}
+
+		case OP_MODULE_AUX:
+			aux, err := p.parseModuleAux()
+
+			if err != nil {
+				return err
+			}
+
+			if err := v.OnModuleAux(aux); err != nil {
+				return err
+			}
+
+		case OP_FUNCTION2:
+			library, err := p.parseString()
+
+			if err != nil {
+				return fmt.Errorf("failed to parse function library: %w", err)
+			}
+
+			if err := v.OnFunction(library); err != nil {
+				return err
+			}
+
+		case OP_FUNCTION_PRE_GA:
+			return fmt.Errorf("%w: pre-release function format is not supported", errInvalidSyntax)
 
 		case OP_EOF:
-			return entries, nil
+			if err := p.verifyChecksum(); err != nil {
+				return err
+			}
+
+			return v.OnEnd()
 
 		default:
-			return nil, fmt.Errorf("%w: unknown op code %x", errInvalidSyntax, opCode)
+			// Any other op code is the value type of the next key.
+			entry.DatabaseIndex = dbIndex
+			entry, err = p.parseDatabaseEntry(entry, ValueEncoding(opCode))
+
+			if err != nil {
+				return err
+			}
+
+			if err := v.OnEntry(entry); err != nil {
+				return err
+			}
+
+			entry = DatabaseEntry{Idle: -1, Freq: -1}
 		}
 	}
 }
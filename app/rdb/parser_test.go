@@ -0,0 +1,34 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseInvalidStringLength(t *testing.T) {
+	tests := []struct {
+		name   string
+		length []byte
+	}{
+		{"negative 64 bit length", []byte{LENGTH_ENCODING_64_BIT, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xf0}},
+		{"huge 64 bit length", []byte{LENGTH_ENCODING_64_BIT, 0x3f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{"32 bit length near 4 GiB", []byte{LENGTH_ENCODING_32_BIT << LENGTH_ENCODING_SHIFT, 0xff, 0xff, 0xff, 0xf0}},
+		// A compressed string of 4 bytes claiming to expand to 1 GiB.
+		{"huge uncompressed length", []byte{LENGTH_ENCODING_MASK | COMPRESSED_STRING, 4, LENGTH_ENCODING_32_BIT << LENGTH_ENCODING_SHIFT, 0x40, 0, 0, 0, 0, 0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := append([]byte("REDIS0011"), byte(STRING_ENCODING))
+			payload = append(payload, test.length...)
+
+			err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(payload), NopVisitor{})
+			var corruptionErr *CorruptionError
+
+			if !errors.As(err, &corruptionErr) {
+				t.Errorf("got error %v, want a corruption error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package rdb
+
+import (
+	"bufio"
+	"hash/crc64"
+	"io"
+)
+
+// The polynomial used by Redis for RDB checksums (CRC-64/Jones), in the
+// reversed bit order expected by the "hash/crc64" package.
+const crc64JonesPolynomial = 0x95AC9329AC4BC9B5
+
+var crc64JonesTable = crc64.MakeTable(crc64JonesPolynomial)
+
+// reader wraps the buffered source of an RDB file and keeps track of the
+// number of bytes consumed so far along with their running CRC64 checksum.
+// Bytes that are only peeked at are not counted until they are read.
+type reader struct {
+	br     *bufio.Reader
+	crc    uint64
+	offset int64
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{
+		br: bufio.NewReader(r),
+	}
+}
+
+// updateChecksum feeds the consumed bytes into the checksum. Redis computes
+// the CRC with a zero initial value and no final XOR, whereas the standard
+// library inverts the value on the way in and out, so we undo both.
+func (r *reader) updateChecksum(buf []byte) {
+	r.crc = ^crc64.Update(^r.crc, crc64JonesTable, buf)
+	r.offset += int64(len(buf))
+}
+
+func (r *reader) Peek(n int) ([]byte, error) {
+	return r.br.Peek(n)
+}
+
+func (r *reader) Read(buf []byte) (int, error) {
+	n, err := r.br.Read(buf)
+	r.updateChecksum(buf[:n])
+
+	return n, err
+}
+
+func (r *reader) ReadByte() (byte, error) {
+	b, err := r.br.ReadByte()
+
+	if err != nil {
+		return 0, err
+	}
+
+	r.updateChecksum([]byte{b})
+
+	return b, nil
+}
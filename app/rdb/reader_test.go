@@ -0,0 +1,126 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// An empty dataset saved by Redis 7.2.0, checksum included.
+const emptyRdbHex = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+
+func emptyRdb(t *testing.T) []byte {
+	t.Helper()
+
+	payload, err := hex.DecodeString(emptyRdbHex)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
+func TestReaderChecksum(t *testing.T) {
+	// The check value of CRC-64/Jones, from the self test of Redis' crc64.c.
+	r := newReader(strings.NewReader("123456789"))
+
+	if _, err := r.Read(make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	for range 5 {
+		if _, err := r.ReadByte(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.crc != 0xe9c6d914c4b8d9ca {
+		t.Errorf("got checksum %016x, want e9c6d914c4b8d9ca", r.crc)
+	}
+
+	if r.offset != 9 {
+		t.Errorf("got offset %d, want 9", r.offset)
+	}
+}
+
+func TestParseRedisChecksum(t *testing.T) {
+	payload := emptyRdb(t)
+	r := newReader(bytes.NewReader(payload[:len(payload)-8]))
+
+	if _, err := r.Read(make([]byte, len(payload))); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := binary.LittleEndian.Uint64(payload[len(payload)-8:]); r.crc != want {
+		t.Errorf("got checksum %016x, want %016x", r.crc, want)
+	}
+
+	if err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(payload), NopVisitor{}); err != nil {
+		t.Errorf("failed to parse file saved by Redis: %v", err)
+	}
+}
+
+func TestParseChecksumMismatch(t *testing.T) {
+	checksumOffset := int64(len(emptyRdb(t)) - 8)
+
+	tests := []struct {
+		name  string
+		index int
+	}{
+		// "7.2.0" becomes "7.3.0", which still parses.
+		{"flipped byte in aux value", bytes.Index(emptyRdb(t), []byte("7.2.0")) + 2},
+		{"flipped byte in checksum", int(checksumOffset) + 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload := emptyRdb(t)
+			payload[test.index] ^= 0x01
+
+			err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(payload), NopVisitor{})
+			var corruptionErr *CorruptionError
+
+			if !errors.As(err, &corruptionErr) {
+				t.Fatalf("got error %v, want a CorruptionError", err)
+			}
+
+			if corruptionErr.Offset != checksumOffset {
+				t.Errorf("got offset %d, want %d", corruptionErr.Offset, checksumOffset)
+			}
+
+			if !strings.Contains(corruptionErr.Reason, "checksum mismatch") {
+				t.Errorf("got reason %q, want a checksum mismatch", corruptionErr.Reason)
+			}
+		})
+	}
+}
+
+func TestParseMissingChecksum(t *testing.T) {
+	payload := emptyRdb(t)
+	payload = payload[:len(payload)-3]
+
+	err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(payload), NopVisitor{})
+	var corruptionErr *CorruptionError
+
+	if !errors.As(err, &corruptionErr) || corruptionErr.Reason != "missing checksum" {
+		t.Fatalf("got error %v, want a missing checksum", err)
+	}
+
+	if want := int64(len(payload) - 5); corruptionErr.Offset != want {
+		t.Errorf("got offset %d, want %d", corruptionErr.Offset, want)
+	}
+}
+
+func TestParseZeroChecksum(t *testing.T) {
+	// Files saved with "rdbchecksum no" are not verified.
+	payload := emptyRdb(t)
+	copy(payload[len(payload)-8:], make([]byte, 8))
+
+	if err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(payload), NopVisitor{}); err != nil {
+		t.Errorf("got error %v, want none", err)
+	}
+}
@@ -0,0 +1,480 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Flags stored with every entry of a stream listpack.
+const (
+	STREAM_ITEM_FLAG_DELETED     = 1 << 0
+	STREAM_ITEM_FLAG_SAME_FIELDS = 1 << 1
+)
+
+const (
+	// Stream IDs are stored as two big endian 64 bit integers.
+	STREAM_ID_SIZE = 16
+)
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+type StreamEntry struct {
+	ID StreamID
+	// Fields holds the flattened field/value pairs of the entry.
+	Fields []string
+}
+
+// StreamPendingEntry is an entry that was delivered to a consumer of a group
+// but has not been acknowledged yet.
+type StreamPendingEntry struct {
+	ID            StreamID
+	DeliveryTime  time.Time
+	DeliveryCount int
+}
+
+type StreamConsumer struct {
+	Name     string
+	SeenTime time.Time
+	// ActiveTime is zero for streams saved before Redis 7.2.
+	ActiveTime time.Time
+	// Pending holds the IDs of the group's pending entries owned by the consumer.
+	Pending []StreamID
+}
+
+type StreamConsumerGroup struct {
+	Name   string
+	LastID StreamID
+	// EntriesRead is -1 when the number of entries read by the group is unknown.
+	EntriesRead int
+	Pending     []StreamPendingEntry
+	Consumers   []StreamConsumer
+}
+
+// Stream is the value of a key holding a Redis stream.
+type Stream struct {
+	Entries []StreamEntry
+	// Length is the number of entries in the stream, as stored in the file.
+	Length            int
+	LastID            StreamID
+	FirstID           StreamID
+	MaxDeletedEntryID StreamID
+	EntriesAdded      int
+	Groups            []StreamConsumerGroup
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// ParseStreamID parses an ID formatted as "<ms>-<seq>".
+func ParseStreamID(str string) (StreamID, error) {
+	ms, seq, ok := strings.Cut(str, "-")
+
+	if !ok {
+		return StreamID{}, fmt.Errorf("invalid stream ID \"%s\"", str)
+	}
+
+	msValue, msErr := strconv.ParseUint(ms, 10, 64)
+	seqValue, seqErr := strconv.ParseUint(seq, 10, 64)
+
+	if msErr != nil || seqErr != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID \"%s\"", str)
+	}
+
+	return StreamID{Ms: msValue, Seq: seqValue}, nil
+}
+
+func (id StreamID) Less(other StreamID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+func encodeStreamID(id StreamID) []byte {
+	buf := binary.BigEndian.AppendUint64(make([]byte, 0, STREAM_ID_SIZE), id.Ms)
+
+	return binary.BigEndian.AppendUint64(buf, id.Seq)
+}
+
+func decodeStreamID(buf []byte) StreamID {
+	return StreamID{
+		Ms:  binary.BigEndian.Uint64(buf[0:8]),
+		Seq: binary.BigEndian.Uint64(buf[8:16]),
+	}
+}
+
+// decodeStreamListpack returns the live entries of a listpack holding stream
+// entries whose IDs are relative to "masterID". The listpack begins with a
+// master entry: <count><deleted><num-fields><field>...<field><0>, and every
+// entry after it is laid out as
+// <flags><ms-diff><seq-diff>[<num-fields>]<field|value>...<lp-count>, where
+// the field names are omitted when they match the master entry's.
+func decodeStreamListpack(masterID StreamID, items []string) ([]StreamEntry, error) {
+	position := 0
+
+	next := func() (int, error) {
+		if position >= len(items) {
+			return 0, errTruncatedEntry
+		}
+
+		value, err := strconv.Atoi(items[position])
+		position += 1
+
+		if err != nil {
+			return 0, fmt.Errorf("expected an integer at index %d", position-1)
+		}
+
+		return value, nil
+	}
+
+	count, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	deleted, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	numMasterFields, err := next()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if numMasterFields < 0 || position+numMasterFields >= len(items) {
+		return nil, errTruncatedEntry
+	}
+
+	masterFields := items[position : position+numMasterFields]
+	// Skip the master fields along with the zero terminator of the master entry.
+	position += numMasterFields + 1
+
+	entries := []StreamEntry{}
+	total := 0
+
+	for position < len(items) {
+		flags, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		msDiff, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		seqDiff, err := next()
+
+		if err != nil {
+			return nil, err
+		}
+
+		entry := StreamEntry{
+			ID: StreamID{Ms: masterID.Ms + uint64(msDiff), Seq: masterID.Seq + uint64(seqDiff)},
+		}
+
+		if flags&STREAM_ITEM_FLAG_SAME_FIELDS != 0 {
+			if position+len(masterFields) > len(items) {
+				return nil, errTruncatedEntry
+			}
+
+			for index, field := range masterFields {
+				entry.Fields = append(entry.Fields, field, items[position+index])
+			}
+
+			position += len(masterFields)
+		} else {
+			numFields, err := next()
+
+			if err != nil {
+				return nil, err
+			}
+
+			if numFields < 0 || position+numFields*2 > len(items) {
+				return nil, errTruncatedEntry
+			}
+
+			entry.Fields = append(entry.Fields, items[position:position+numFields*2]...)
+			position += numFields * 2
+		}
+
+		// Skip the "lp-count" used to walk the listpack backwards.
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+
+		total += 1
+
+		if flags&STREAM_ITEM_FLAG_DELETED == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	if count != len(entries) || deleted != total-len(entries) {
+		return nil, fmt.Errorf("master entry counts %d/%d do not match %d live and %d deleted entries", count, deleted, len(entries), total-len(entries))
+	}
+
+	return entries, nil
+}
+
+func (p *Parser) parseRawStreamID() (StreamID, error) {
+	buf := make([]byte, STREAM_ID_SIZE)
+
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return StreamID{}, fmt.Errorf("failed to parse stream ID: %w", err)
+	}
+
+	return decodeStreamID(buf), nil
+}
+
+// parseStreamID reads a stream ID stored as two length-encoded integers.
+func (p *Parser) parseStreamID() (StreamID, error) {
+	ms, _, err := p.parseLength()
+
+	if err != nil {
+		return StreamID{}, fmt.Errorf("failed to parse stream ID: %w", err)
+	}
+
+	seq, _, err := p.parseLength()
+
+	if err != nil {
+		return StreamID{}, fmt.Errorf("failed to parse stream ID: %w", err)
+	}
+
+	return StreamID{Ms: uint64(ms), Seq: uint64(seq)}, nil
+}
+
+func (p *Parser) parseMillisecondTime() (time.Time, error) {
+	buf := make([]byte, 8)
+
+	if _, err := io.ReadFull(p.r, buf); err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse millisecond time: %w", err)
+	}
+
+	ms := int64(binary.LittleEndian.Uint64(buf))
+
+	if ms == -1 {
+		return time.Time{}, nil
+	}
+
+	return time.UnixMilli(ms), nil
+}
+
+func (p *Parser) parseStreamConsumerGroup(valueEncoding ValueEncoding) (StreamConsumerGroup, error) {
+	var group StreamConsumerGroup
+	offset := p.r.offset
+
+	name, err := p.parseString()
+
+	if err != nil {
+		return group, fmt.Errorf("failed to parse consumer group name: %w", err)
+	}
+
+	group.Name = name
+
+	if group.LastID, err = p.parseStreamID(); err != nil {
+		return group, err
+	}
+
+	group.EntriesRead = -1
+
+	if valueEncoding != STREAM_LIST_PACKS_ENCODING {
+		entriesRead, _, err := p.parseLength()
+
+		if err != nil {
+			return group, fmt.Errorf("failed to parse entries read by consumer group \"%s\": %w", name, err)
+		}
+
+		group.EntriesRead = entriesRead
+	}
+
+	pendingCount, err := p.parseSize()
+
+	if err != nil {
+		return group, fmt.Errorf("failed to parse pending entries of consumer group \"%s\": %w", name, err)
+	}
+
+	pending := make(map[StreamID]struct{}, pendingCount)
+
+	for range pendingCount {
+		var entry StreamPendingEntry
+
+		if entry.ID, err = p.parseRawStreamID(); err != nil {
+			return group, err
+		}
+
+		if entry.DeliveryTime, err = p.parseMillisecondTime(); err != nil {
+			return group, err
+		}
+
+		if entry.DeliveryCount, err = p.parseSize(); err != nil {
+			return group, fmt.Errorf("failed to parse delivery count: %w", err)
+		}
+
+		if _, ok := pending[entry.ID]; ok && p.opts.SanitizePayload {
+			return group, p.corruptionError(offset, "duplicate pending entry %s in consumer group \"%s\"", entry.ID, name)
+		}
+
+		pending[entry.ID] = struct{}{}
+		group.Pending = append(group.Pending, entry)
+	}
+
+	consumerCount, err := p.parseSize()
+
+	if err != nil {
+		return group, fmt.Errorf("failed to parse consumers of consumer group \"%s\": %w", name, err)
+	}
+
+	for range consumerCount {
+		var consumer StreamConsumer
+
+		if consumer.Name, err = p.parseString(); err != nil {
+			return group, fmt.Errorf("failed to parse consumer name: %w", err)
+		}
+
+		if consumer.SeenTime, err = p.parseMillisecondTime(); err != nil {
+			return group, err
+		}
+
+		if valueEncoding == STREAM_LIST_PACKS_3_ENCODING {
+			if consumer.ActiveTime, err = p.parseMillisecondTime(); err != nil {
+				return group, err
+			}
+		}
+
+		consumerPendingCount, err := p.parseSize()
+
+		if err != nil {
+			return group, fmt.Errorf("failed to parse pending entries of consumer \"%s\": %w", consumer.Name, err)
+		}
+
+		for range consumerPendingCount {
+			id, err := p.parseRawStreamID()
+
+			if err != nil {
+				return group, err
+			}
+
+			if _, ok := pending[id]; !ok {
+				return group, p.corruptionError(offset, "pending entry %s of consumer \"%s\" is missing from consumer group \"%s\"", id, consumer.Name, name)
+			}
+
+			consumer.Pending = append(consumer.Pending, id)
+		}
+
+		group.Consumers = append(group.Consumers, consumer)
+	}
+
+	return group, nil
+}
+
+func (p *Parser) parseStream(valueEncoding ValueEncoding) (*Stream, error) {
+	offset := p.r.offset
+	nodes, err := p.parseSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stream size: %w", err)
+	}
+
+	stream := &Stream{}
+
+	for range nodes {
+		nodeKey, nodeOffset, err := p.parseBlob()
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse stream node key: %w", err)
+		}
+
+		if len(nodeKey) != STREAM_ID_SIZE {
+			return nil, p.corruptionError(nodeOffset, "stream node key is %d bytes long, expected %d", len(nodeKey), STREAM_ID_SIZE)
+		}
+
+		items, listpackOffset, err := p.parseEncodedBlob("listpack", decodeListpack)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(items) == 0 {
+			return nil, p.corruptionError(listpackOffset, "empty listpack inside stream")
+		}
+
+		entries, err := decodeStreamListpack(decodeStreamID(nodeKey), items)
+
+		if err != nil {
+			return nil, &CorruptionError{Offset: listpackOffset, Reason: fmt.Sprintf("invalid stream listpack: %v", err), Err: err}
+		}
+
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	if stream.Length, err = p.parseSize(); err != nil {
+		return nil, fmt.Errorf("failed to parse stream length: %w", err)
+	}
+
+	if stream.LastID, err = p.parseStreamID(); err != nil {
+		return nil, err
+	}
+
+	if valueEncoding == STREAM_LIST_PACKS_ENCODING {
+		// Older streams do not record these fields, so derive them from the entries.
+		stream.EntriesAdded = stream.Length
+
+		if len(stream.Entries) > 0 {
+			stream.FirstID = stream.Entries[0].ID
+		}
+	} else {
+		if stream.FirstID, err = p.parseStreamID(); err != nil {
+			return nil, err
+		}
+
+		if stream.MaxDeletedEntryID, err = p.parseStreamID(); err != nil {
+			return nil, err
+		}
+
+		if stream.EntriesAdded, err = p.parseSize(); err != nil {
+			return nil, fmt.Errorf("failed to parse stream entries added: %w", err)
+		}
+	}
+
+	if p.opts.SanitizePayload {
+		if stream.Length != len(stream.Entries) {
+			return nil, p.corruptionError(offset, "stream length %d does not match entry count %d", stream.Length, len(stream.Entries))
+		}
+
+		for index, entry := range stream.Entries {
+			if (index > 0 && !stream.Entries[index-1].ID.Less(entry.ID)) || stream.LastID.Less(entry.ID) {
+				return nil, p.corruptionError(offset, "stream entry %s is out of order", entry.ID)
+			}
+		}
+	}
+
+	groupCount, err := p.parseSize()
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse stream consumer groups: %w", err)
+	}
+
+	for range groupCount {
+		group, err := p.parseStreamConsumerGroup(valueEncoding)
+
+		if err != nil {
+			return nil, err
+		}
+
+		stream.Groups = append(stream.Groups, group)
+	}
+
+	return stream, nil
+}
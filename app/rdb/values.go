@@ -0,0 +1,46 @@
+package rdb
+
+// List is the value of a key holding a Redis list.
+type List []string
+
+// Set is the value of a key holding a Redis set.
+type Set []string
+
+// Hash is the value of a key holding a Redis hash.
+type Hash map[string]string
+
+type SortedSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// SortedSet is the value of a key holding a Redis sorted set, in the order
+// the members were stored in the file.
+type SortedSet []SortedSetEntry
+
+// TypeName returns the name of the type of a value as reported by Redis,
+// e.g. "string" or "zset". Strings may be held as a string or a []byte.
+func TypeName(value any) string {
+	switch value.(type) {
+	case string, []byte:
+		return "string"
+
+	case List:
+		return "list"
+
+	case Set:
+		return "set"
+
+	case SortedSet:
+		return "zset"
+
+	case Hash:
+		return "hash"
+
+	case *Stream:
+		return "stream"
+
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,55 @@
+package rdb
+
+// Visitor receives the records of an RDB file as they are parsed. Returning
+// an error from any of its methods stops parsing and returns that error.
+type Visitor interface {
+	OnAux(key, value string) error
+	OnSelectDB(index int) error
+	OnResizeDB(size, expiresSize int) error
+	OnEntry(entry DatabaseEntry) error
+	OnFunction(library string) error
+	OnSlotInfo(info SlotInfo) error
+	OnModuleAux(aux ModuleAux) error
+	// OnEnd is called once the whole file has been parsed and its checksum verified.
+	OnEnd() error
+}
+
+// NopVisitor ignores every record. Embed it in visitors that are only
+// interested in some of them.
+type NopVisitor struct{}
+
+func (NopVisitor) OnAux(key, value string) error          { return nil }
+func (NopVisitor) OnSelectDB(index int) error             { return nil }
+func (NopVisitor) OnResizeDB(size, expiresSize int) error { return nil }
+func (NopVisitor) OnEntry(entry DatabaseEntry) error      { return nil }
+func (NopVisitor) OnFunction(library string) error        { return nil }
+func (NopVisitor) OnSlotInfo(info SlotInfo) error         { return nil }
+func (NopVisitor) OnModuleAux(aux ModuleAux) error        { return nil }
+func (NopVisitor) OnEnd() error                           { return nil }
+
+// collector gathers every record of a file in memory, for Parse.
+type collector struct {
+	NopVisitor
+	entries []DatabaseEntry
+	p       *Parser
+}
+
+func (c *collector) OnEntry(entry DatabaseEntry) error {
+	c.entries = append(c.entries, entry)
+	return nil
+}
+
+func (c *collector) OnFunction(library string) error {
+	c.p.functions = append(c.p.functions, library)
+	return nil
+}
+
+func (c *collector) OnSlotInfo(info SlotInfo) error {
+	c.p.slotInfo = append(c.p.slotInfo, info)
+	return nil
+}
+
+func (c *collector) OnModuleAux(aux ModuleAux) error {
+	c.p.moduleAux = append(c.p.moduleAux, aux)
+	return nil
+}
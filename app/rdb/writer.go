@@ -0,0 +1,355 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// The RDB version written by Writer, matching Redis 7.2.
+	RDB_VERSION = 11
+	// The maximum number of stream entries stored in a single listpack node.
+	STREAM_NODE_MAX_ENTRIES = 100
+)
+
+// Writer encodes an RDB file. Records are written in the order the methods
+// are called: WriteHeader first, then any number of aux fields, database
+// selectors and entries, and finally Close, which appends the end of file
+// marker and the checksum.
+type Writer struct {
+	bw  *bufio.Writer
+	crc uint64
+	err error
+}
+
+// EntryOpts holds the eviction state of a key, which WriteEntry stores
+// along with it. Like DatabaseEntry.Idle and Freq, a field set to -1 is not
+// stored.
+type EntryOpts struct {
+	// Idle is the time since the key was last accessed, which Redis stores
+	// when running an LRU eviction policy.
+	Idle time.Duration
+	// Freq is the LFU access frequency counter of the key, which Redis
+	// stores when running an LFU eviction policy.
+	Freq int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		bw: bufio.NewWriter(w),
+	}
+}
+
+// Write implements io.Writer, keeping track of the running checksum. Once a
+// write fails, every following write returns the same error.
+func (w *Writer) Write(buf []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n, err := w.bw.Write(buf)
+	w.crc = ^crc64.Update(^w.crc, crc64JonesTable, buf[:n])
+	w.err = err
+
+	return n, err
+}
+
+func (w *Writer) writeByte(b byte) {
+	w.Write([]byte{b})
+}
+
+// writeLength writes a length using the smallest of the length encodings.
+func (w *Writer) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		w.writeByte(byte(length))
+
+	case length < 1<<14:
+		w.Write([]byte{byte(LENGTH_ENCODING_14_BIT<<LENGTH_ENCODING_SHIFT) | byte(length>>8), byte(length)})
+
+	case length <= math.MaxUint32:
+		w.writeByte(LENGTH_ENCODING_32_BIT << LENGTH_ENCODING_SHIFT)
+		w.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+
+	default:
+		w.writeByte(LENGTH_ENCODING_64_BIT)
+		w.Write(binary.BigEndian.AppendUint64(nil, length))
+	}
+}
+
+// writeString writes a string, storing it as an integer when it holds one
+// that fits in 32 bits.
+func (w *Writer) writeString(str string) {
+	if number, err := strconv.ParseInt(str, 10, 32); err == nil && strconv.FormatInt(number, 10) == str {
+		encoded := byte(LENGTH_ENCODING_MASK)
+
+		switch {
+		case number >= math.MinInt8 && number <= math.MaxInt8:
+			w.Write([]byte{encoded | INTEGER_STRING_8_BIT, byte(number)})
+
+		case number >= math.MinInt16 && number <= math.MaxInt16:
+			w.writeByte(encoded | INTEGER_STRING_16_BIT)
+			w.Write(binary.LittleEndian.AppendUint16(nil, uint16(number)))
+
+		default:
+			w.writeByte(encoded | INTEGER_STRING_32_BIT)
+			w.Write(binary.LittleEndian.AppendUint32(nil, uint32(number)))
+		}
+
+		return
+	}
+
+	w.writeLength(uint64(len(str)))
+	w.Write([]byte(str))
+}
+
+func (w *Writer) writeBinaryDouble(value float64) {
+	w.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(value)))
+}
+
+func (w *Writer) writeStreamID(id StreamID) {
+	w.writeLength(id.Ms)
+	w.writeLength(id.Seq)
+}
+
+func (w *Writer) writeRawStreamID(id StreamID) {
+	w.Write(encodeStreamID(id))
+}
+
+func (w *Writer) writeMillisecondTime(t time.Time) {
+	ms := int64(-1)
+
+	if !t.IsZero() {
+		ms = t.UnixMilli()
+	}
+
+	w.Write(binary.LittleEndian.AppendUint64(nil, uint64(ms)))
+}
+
+// WriteHeader writes the magic string and the RDB version.
+func (w *Writer) WriteHeader() error {
+	w.Write(fmt.Appendf(nil, "REDIS%04d", RDB_VERSION))
+
+	return w.err
+}
+
+func (w *Writer) WriteAux(key, value string) error {
+	w.writeByte(OP_AUX)
+	w.writeString(key)
+	w.writeString(value)
+
+	return w.err
+}
+
+func (w *Writer) WriteSelectDB(index int) error {
+	w.writeByte(OP_SELECT_DB)
+	w.writeLength(uint64(index))
+
+	return w.err
+}
+
+// WriteResizeDB writes the number of keys, and keys with an expiry, stored
+// in the selected database, which lets loaders presize their hash tables.
+func (w *Writer) WriteResizeDB(size, expiresSize int) error {
+	w.writeByte(OP_RESIZE_DB)
+	w.writeLength(uint64(size))
+	w.writeLength(uint64(expiresSize))
+
+	return w.err
+}
+
+// WriteEntry writes a key of the selected database. "value" must be one of
+// the types returned by the Parser, or a []byte holding a string. The
+// eviction state of the key is only stored when "opts" is given.
+func (w *Writer) WriteEntry(key string, value any, expiry time.Time, opts ...EntryOpts) error {
+	if !expiry.IsZero() {
+		w.writeByte(OP_EXPIRE_TIME_MS)
+		w.Write(binary.LittleEndian.AppendUint64(nil, uint64(expiry.UnixMilli())))
+	}
+
+	for _, o := range opts {
+		if o.Idle >= 0 {
+			w.writeByte(OP_IDLE)
+			w.writeLength(uint64(o.Idle / time.Second))
+		}
+
+		if o.Freq >= 0 {
+			w.writeByte(OP_FREQ)
+			w.writeByte(byte(min(o.Freq, math.MaxUint8)))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		w.writeKey(STRING_ENCODING, key)
+		w.writeString(v)
+
+	case []byte:
+		w.writeKey(STRING_ENCODING, key)
+		w.writeString(string(v))
+
+	case List:
+		w.writeKey(LIST_ENCODING, key)
+		w.writeStrings(v)
+
+	case Set:
+		w.writeKey(SET_ENCODING, key)
+		w.writeStrings(v)
+
+	case Hash:
+		w.writeKey(HASH_MAP_ENCODING, key)
+		w.writeLength(uint64(len(v)))
+
+		for field, fieldValue := range v {
+			w.writeString(field)
+			w.writeString(fieldValue)
+		}
+
+	case SortedSet:
+		w.writeKey(SORTED_SET_2_ENCODING, key)
+		w.writeLength(uint64(len(v)))
+
+		for _, entry := range v {
+			w.writeString(entry.Member)
+			w.writeBinaryDouble(entry.Score)
+		}
+
+	case *Stream:
+		w.writeKey(STREAM_LIST_PACKS_3_ENCODING, key)
+		w.writeStream(v)
+
+	default:
+		return fmt.Errorf("failed to write key \"%s\": unsupported value type %T", key, value)
+	}
+
+	return w.err
+}
+
+// writeKey writes the value type of an entry followed by its key.
+func (w *Writer) writeKey(valueEncoding ValueEncoding, key string) {
+	w.writeByte(byte(valueEncoding))
+	w.writeString(key)
+}
+
+func (w *Writer) writeStrings(entries []string) {
+	w.writeLength(uint64(len(entries)))
+
+	for _, entry := range entries {
+		w.writeString(entry)
+	}
+}
+
+// writeStream stores the entries of a stream in listpack nodes laid out as
+// decodeStreamListpack expects, using the fields of the first entry of every
+// node as its master fields.
+func (w *Writer) writeStream(stream *Stream) {
+	nodes := (len(stream.Entries) + STREAM_NODE_MAX_ENTRIES - 1) / STREAM_NODE_MAX_ENTRIES
+	w.writeLength(uint64(nodes))
+
+	for start := 0; start < len(stream.Entries); start += STREAM_NODE_MAX_ENTRIES {
+		entries := stream.Entries[start:min(start+STREAM_NODE_MAX_ENTRIES, len(stream.Entries))]
+		masterID := entries[0].ID
+		masterFields := []string{}
+
+		for index := 0; index+1 < len(entries[0].Fields); index += 2 {
+			masterFields = append(masterFields, entries[0].Fields[index])
+		}
+
+		items := []string{strconv.Itoa(len(entries)), "0", strconv.Itoa(len(masterFields))}
+		items = append(items, masterFields...)
+		items = append(items, "0")
+
+		for _, entry := range entries {
+			// Like Redis, store the differences as signed integers: the
+			// sequence of an entry is lower than the master's when its
+			// millisecond time is higher.
+			msDiff := strconv.FormatInt(int64(entry.ID.Ms-masterID.Ms), 10)
+			seqDiff := strconv.FormatInt(int64(entry.ID.Seq-masterID.Seq), 10)
+
+			if hasStreamFields(entry, masterFields) {
+				items = append(items, strconv.Itoa(STREAM_ITEM_FLAG_SAME_FIELDS), msDiff, seqDiff)
+
+				for index := 1; index < len(entry.Fields); index += 2 {
+					items = append(items, entry.Fields[index])
+				}
+
+				items = append(items, strconv.Itoa(len(masterFields)+3))
+			} else {
+				items = append(items, "0", msDiff, seqDiff, strconv.Itoa(len(entry.Fields)/2))
+				items = append(items, entry.Fields...)
+				items = append(items, strconv.Itoa(len(entry.Fields)/2*2+4))
+			}
+		}
+
+		w.writeString(string(encodeStreamID(masterID)))
+		w.writeString(string(encodeListpack(items)))
+	}
+
+	w.writeLength(uint64(stream.Length))
+	w.writeStreamID(stream.LastID)
+	w.writeStreamID(stream.FirstID)
+	w.writeStreamID(stream.MaxDeletedEntryID)
+	w.writeLength(uint64(stream.EntriesAdded))
+	w.writeLength(uint64(len(stream.Groups)))
+
+	for _, group := range stream.Groups {
+		w.writeString(group.Name)
+		w.writeStreamID(group.LastID)
+		// An unknown entries read counter is stored as the largest 64 bit value.
+		w.writeLength(uint64(int64(group.EntriesRead)))
+		w.writeLength(uint64(len(group.Pending)))
+
+		for _, entry := range group.Pending {
+			w.writeRawStreamID(entry.ID)
+			w.writeMillisecondTime(entry.DeliveryTime)
+			w.writeLength(uint64(entry.DeliveryCount))
+		}
+
+		w.writeLength(uint64(len(group.Consumers)))
+
+		for _, consumer := range group.Consumers {
+			w.writeString(consumer.Name)
+			w.writeMillisecondTime(consumer.SeenTime)
+			w.writeMillisecondTime(consumer.ActiveTime)
+			w.writeLength(uint64(len(consumer.Pending)))
+
+			for _, id := range consumer.Pending {
+				w.writeRawStreamID(id)
+			}
+		}
+	}
+}
+
+// hasStreamFields reports whether the field names of "entry" are "fields".
+func hasStreamFields(entry StreamEntry, fields []string) bool {
+	if len(entry.Fields) != len(fields)*2 {
+		return false
+	}
+
+	for index, field := range fields {
+		if entry.Fields[index*2] != field {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Close writes the end of file marker and the checksum, and flushes the
+// buffered output. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	w.writeByte(OP_EOF)
+	w.Write(binary.LittleEndian.AppendUint64(nil, w.crc))
+
+	if w.err != nil {
+		return w.err
+	}
+
+	return w.bw.Flush()
+}
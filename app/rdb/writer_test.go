@@ -0,0 +1,206 @@
+package rdb
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder keeps every entry and aux field of a parsed file.
+type recorder struct {
+	NopVisitor
+	aux     map[string]string
+	entries []DatabaseEntry
+}
+
+func (r *recorder) OnAux(key, value string) error {
+	r.aux[key] = value
+	return nil
+}
+
+func (r *recorder) OnEntry(entry DatabaseEntry) error {
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func testStream() *Stream {
+	stream := &Stream{}
+
+	// Enough entries for more than one listpack node, with entries whose
+	// fields differ from the master fields of their node, and IDs whose
+	// sequence is lower than that of the first entry of their node.
+	for index := range STREAM_NODE_MAX_ENTRIES + 50 {
+		entry := StreamEntry{
+			ID:     StreamID{Ms: 1700000000000 + uint64(index/3), Seq: uint64(index % 3)},
+			Fields: []string{"sensor", "temperature", "value", fmt.Sprint(index)},
+		}
+
+		if index%7 == 0 {
+			entry.Fields = []string{"note", strings.Repeat("n", index)}
+		}
+
+		stream.Entries = append(stream.Entries, entry)
+	}
+
+	last := stream.Entries[len(stream.Entries)-1].ID
+	seen := time.UnixMilli(1700000001000)
+
+	stream.Length = len(stream.Entries)
+	stream.LastID = last
+	stream.FirstID = stream.Entries[0].ID
+	stream.MaxDeletedEntryID = StreamID{Ms: 1699999999999, Seq: 7}
+	stream.EntriesAdded = len(stream.Entries) + 3
+	stream.Groups = []StreamConsumerGroup{
+		{
+			Name:        "readers",
+			LastID:      stream.Entries[1].ID,
+			EntriesRead: 2,
+			Pending: []StreamPendingEntry{
+				{ID: stream.Entries[0].ID, DeliveryTime: seen, DeliveryCount: 1},
+				{ID: stream.Entries[1].ID, DeliveryTime: seen.Add(time.Second), DeliveryCount: 3},
+			},
+			Consumers: []StreamConsumer{
+				{Name: "alice", SeenTime: seen, ActiveTime: seen, Pending: []StreamID{stream.Entries[0].ID}},
+				{Name: "bob", SeenTime: seen.Add(time.Second), ActiveTime: seen, Pending: []StreamID{stream.Entries[1].ID}},
+			},
+		},
+		// Groups created with an ID in the middle of the stream do not know
+		// how many entries they read.
+		{Name: "late", LastID: last, EntriesRead: -1},
+	}
+
+	return stream
+}
+
+func TestWriterRoundTrip(t *testing.T) {
+	expiry := time.UnixMilli(4102444800000)
+
+	entries := []DatabaseEntry{
+		{Key: "string", Value: "hello"},
+		{Key: "empty", Value: ""},
+		{Key: "integer", Value: "-12345"},
+		{Key: "large integer", Value: "9223372036854775807"},
+		{Key: "binary", Value: "a\x00\r\n\xff"},
+		{Key: "long string", Value: strings.Repeat("abc", 10000)},
+		{Key: "expiring", Value: "soon", Expiry: expiry},
+		{Key: "list", Value: List{"a", "1", "", strings.Repeat("b", 300)}},
+		{Key: "set", Value: Set{"x", "2", "y"}},
+		{Key: "hash", Value: Hash{"field": "value", "number": "42", "": "empty"}},
+		{Key: "zset", Value: SortedSet{{"a", -1.5}, {"b", 0}, {"c", 3}, {"inf", math.Inf(1)}}},
+		{Key: "stream", Value: testStream(), Expiry: expiry},
+		{Key: "other db", Value: "x", DatabaseIndex: 3},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+
+	if err := w.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.WriteAux("redis-ver", "7.2.0"); err != nil {
+		t.Fatal(err)
+	}
+
+	db := -1
+
+	for _, entry := range entries {
+		if entry.DatabaseIndex != db {
+			db = entry.DatabaseIndex
+
+			if err := w.WriteSelectDB(db); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := w.WriteEntry(entry.Key, entry.Value, entry.Expiry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sanitize := range []bool{false, true} {
+		t.Run(fmt.Sprintf("sanitize %t", sanitize), func(t *testing.T) {
+			r := &recorder{aux: map[string]string{}}
+
+			if err := NewParser(ParserOpts{SanitizePayload: sanitize}).ParseReader(bytes.NewReader(buf.Bytes()), r); err != nil {
+				t.Fatalf("failed to parse written file: %v", err)
+			}
+
+			if r.aux["redis-ver"] != "7.2.0" {
+				t.Errorf("got aux fields %v, want redis-ver 7.2.0", r.aux)
+			}
+
+			if len(r.entries) != len(entries) {
+				t.Fatalf("got %d entries, want %d", len(r.entries), len(entries))
+			}
+
+			for index, want := range entries {
+				got := r.entries[index]
+				want.Idle = -1
+				want.Freq = -1
+
+				if !got.Expiry.Equal(want.Expiry) {
+					t.Errorf("got expiry %v for \"%s\", want %v", got.Expiry, want.Key, want.Expiry)
+				}
+
+				got.Expiry, want.Expiry = time.Time{}, time.Time{}
+
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got entry %+v, want %+v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriterRoundTripEvictionState(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []EntryOpts
+		wantIdle time.Duration
+		wantFreq int
+	}{
+		{"none", nil, -1, -1},
+		{"idle time", []EntryOpts{{Idle: 90*time.Second + 500*time.Millisecond, Freq: -1}}, 90 * time.Second, -1},
+		{"large idle time", []EntryOpts{{Idle: 400 * 24 * time.Hour, Freq: -1}}, 400 * 24 * time.Hour, -1},
+		{"frequency", []EntryOpts{{Idle: -1, Freq: 200}}, -1, 200},
+		{"frequency above the counter range", []EntryOpts{{Idle: -1, Freq: 1000}}, -1, 255},
+		{"both", []EntryOpts{{Idle: 0, Freq: 0}}, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.WriteHeader()
+			w.WriteSelectDB(0)
+			w.WriteEntry("key", "value", time.UnixMilli(4102444800000), test.opts...)
+
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			r := &recorder{aux: map[string]string{}}
+
+			if err := NewParser(ParserOpts{}).ParseReader(bytes.NewReader(buf.Bytes()), r); err != nil {
+				t.Fatalf("failed to parse written file: %v", err)
+			}
+
+			if len(r.entries) != 1 {
+				t.Fatalf("got %d entries, want 1", len(r.entries))
+			}
+
+			if got := r.entries[0]; got.Key != "key" || got.Value != "value" || got.Idle != test.wantIdle || got.Freq != test.wantFreq {
+				t.Errorf("got entry %+v, want idle time %v and frequency %d", got, test.wantIdle, test.wantFreq)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// A ziplist is laid out as <zlbytes><zltail><zllen><entry>...<entry><zlend>.
+// Every entry is prefixed by the length of the previous entry followed by
+// the encoding of its own contents.
+const (
+	ZIPLIST_HEADER_SIZE = 10
+	ZIPLIST_END         = 0xFF
+	// Entry counts above this value are not stored in the header.
+	ZIPLIST_MAX_COUNT = 0xFFFF
+	// Previous entry lengths of 254 bytes or more use a 5 byte encoding.
+	ZIPLIST_BIG_PREVLEN = 0xFE
+)
+
+const (
+	ZIPLIST_INT_16_BIT = 0xC0
+	ZIPLIST_INT_32_BIT = 0xD0
+	ZIPLIST_INT_64_BIT = 0xE0
+	ZIPLIST_INT_24_BIT = 0xF0
+	ZIPLIST_INT_8_BIT  = 0xFE
+	// Integers between 0 and 12 are stored in the lower 4 bits of the encoding byte.
+	ZIPLIST_INT_IMMEDIATE_MIN = 0xF1
+	ZIPLIST_INT_IMMEDIATE_MAX = 0xFD
+)
+
+var errTruncatedEntry = errors.New("entry extends past the end of the payload")
+
+// readInt reads a little endian, two's complement integer of "size" bytes.
+func readInt(buf []byte, size int) int64 {
+	var value uint64
+
+	for i := size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(buf[i])
+	}
+
+	shift := 64 - 8*size
+
+	return int64(value<<shift) >> shift
+}
+
+// readEntryString returns the "length" bytes that follow the "headerSize"
+// bytes of an entry's encoding, along with the total size of the entry.
+func readEntryString(buf []byte, headerSize int, length int) (string, int, error) {
+	if length < 0 || headerSize+length > len(buf) {
+		return "", 0, errTruncatedEntry
+	}
+
+	return string(buf[headerSize : headerSize+length]), headerSize + length, nil
+}
+
+func decodeZiplistEntry(buf []byte) (string, int, error) {
+	if len(buf) == 0 {
+		return "", 0, errTruncatedEntry
+	}
+
+	encoding := buf[0]
+
+	switch encoding >> 6 {
+	case 0:
+		return readEntryString(buf, 1, int(encoding&0x3F))
+
+	case 1:
+		if len(buf) < 2 {
+			return "", 0, errTruncatedEntry
+		}
+
+		return readEntryString(buf, 2, int(encoding&0x3F)<<8|int(buf[1]))
+
+	case 2:
+		if len(buf) < 5 {
+			return "", 0, errTruncatedEntry
+		}
+
+		return readEntryString(buf, 5, int(binary.BigEndian.Uint32(buf[1:5])))
+	}
+
+	size := 0
+
+	switch encoding {
+	case ZIPLIST_INT_8_BIT:
+		size = 1
+
+	case ZIPLIST_INT_16_BIT:
+		size = 2
+
+	case ZIPLIST_INT_24_BIT:
+		size = 3
+
+	case ZIPLIST_INT_32_BIT:
+		size = 4
+
+	case ZIPLIST_INT_64_BIT:
+		size = 8
+
+	default:
+		if encoding >= ZIPLIST_INT_IMMEDIATE_MIN && encoding <= ZIPLIST_INT_IMMEDIATE_MAX {
+			return strconv.Itoa(int(encoding&0x0F) - 1), 1, nil
+		}
+
+		return "", 0, fmt.Errorf("unknown entry encoding %x", encoding)
+	}
+
+	if len(buf) < 1+size {
+		return "", 0, errTruncatedEntry
+	}
+
+	return strconv.FormatInt(readInt(buf[1:], size), 10), 1 + size, nil
+}
+
+// decodeZiplist returns the entries of a ziplist. When "sanitize" is set the
+// header fields and back references are validated against the entries.
+func decodeZiplist(buf []byte, sanitize bool) ([]string, error) {
+	if len(buf) < ZIPLIST_HEADER_SIZE+1 {
+		return nil, fmt.Errorf("ziplist of %d bytes is too short", len(buf))
+	}
+
+	totalBytes := int(binary.LittleEndian.Uint32(buf[0:4]))
+	tailOffset := int(binary.LittleEndian.Uint32(buf[4:8]))
+	count := int(binary.LittleEndian.Uint16(buf[8:10]))
+
+	if sanitize && totalBytes != len(buf) {
+		return nil, fmt.Errorf("ziplist header size %d does not match payload size %d", totalBytes, len(buf))
+	}
+
+	entries := []string{}
+	position := ZIPLIST_HEADER_SIZE
+	lastEntryOffset := ZIPLIST_HEADER_SIZE
+	prevLength := 0
+
+	for {
+		if position >= len(buf) {
+			return nil, errors.New("ziplist is missing its end marker")
+		}
+
+		if buf[position] == ZIPLIST_END {
+			break
+		}
+
+		start := position
+		storedPrevLength := int(buf[position])
+		position += 1
+
+		if storedPrevLength == ZIPLIST_BIG_PREVLEN {
+			if position+4 > len(buf) {
+				return nil, errTruncatedEntry
+			}
+
+			storedPrevLength = int(binary.LittleEndian.Uint32(buf[position : position+4]))
+			position += 4
+		}
+
+		if sanitize && storedPrevLength != prevLength {
+			return nil, fmt.Errorf("ziplist entry at %d has previous length %d, expected %d", start, storedPrevLength, prevLength)
+		}
+
+		value, n, err := decodeZiplistEntry(buf[position:])
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid ziplist entry at %d: %w", start, err)
+		}
+
+		position += n
+		prevLength = position - start
+		lastEntryOffset = start
+		entries = append(entries, value)
+	}
+
+	if sanitize {
+		if position != len(buf)-1 {
+			return nil, fmt.Errorf("ziplist has %d trailing bytes", len(buf)-1-position)
+		}
+
+		if tailOffset != lastEntryOffset {
+			return nil, fmt.Errorf("ziplist tail offset %d does not match last entry offset %d", tailOffset, lastEntryOffset)
+		}
+
+		if count != ZIPLIST_MAX_COUNT && count != len(entries) {
+			return nil, fmt.Errorf("ziplist header count %d does not match entry count %d", count, len(entries))
+		}
+	}
+
+	return entries, nil
+}
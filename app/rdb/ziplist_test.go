@@ -0,0 +1,164 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// buildZiplist returns a ziplist holding entries made of an encoding byte
+// and its contents, with the headers and previous entry lengths filled in.
+func buildZiplist(entries ...[]byte) []byte {
+	buf := make([]byte, ZIPLIST_HEADER_SIZE)
+	tail := ZIPLIST_HEADER_SIZE
+	prevLength := 0
+
+	for _, entry := range entries {
+		tail = len(buf)
+
+		if prevLength < ZIPLIST_BIG_PREVLEN {
+			buf = append(buf, byte(prevLength))
+		} else {
+			buf = append(buf, ZIPLIST_BIG_PREVLEN)
+			buf = binary.LittleEndian.AppendUint32(buf, uint32(prevLength))
+		}
+
+		buf = append(buf, entry...)
+		prevLength = len(buf) - tail
+	}
+
+	buf = append(buf, ZIPLIST_END)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(buf)))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(tail))
+	binary.LittleEndian.PutUint16(buf[8:10], uint16(len(entries)))
+
+	return buf
+}
+
+// patch returns a copy of "buf" with "values" written at "index".
+func patch(buf []byte, index int, values ...byte) []byte {
+	buf = slices.Clone(buf)
+	copy(buf[index:], values)
+
+	return buf
+}
+
+func TestDecodeZiplist(t *testing.T) {
+	long := strings.Repeat("a", 64)
+	huge := strings.Repeat("b", 300)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []string
+	}{
+		{"empty", buildZiplist(), []string{}},
+		{"6 bit string", buildZiplist([]byte("\x03foo")), []string{"foo"}},
+		{"14 bit string", buildZiplist(append([]byte{0x40, 0x40}, long...)), []string{long}},
+		{"32 bit string", buildZiplist([]byte("\x80\x00\x00\x00\x05hello")), []string{"hello"}},
+		{"8 bit integer", buildZiplist([]byte{0xFE, 0xF6}), []string{"-10"}},
+		{"16 bit integer", buildZiplist([]byte{0xC0, 0x34, 0x12}), []string{"4660"}},
+		{"24 bit integer", buildZiplist([]byte{0xF0, 0xFF, 0xFF, 0x7F}), []string{"8388607"}},
+		{"32 bit integer", buildZiplist([]byte{0xD0, 0x00, 0x00, 0x00, 0x80}), []string{"-2147483648"}},
+		{"64 bit integer", buildZiplist([]byte{0xE0, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0x7F}), []string{"9223372036854775807"}},
+		{"immediate integers", buildZiplist([]byte{0xF1}, []byte{0xFD}), []string{"0", "12"}},
+		{
+			"5 byte previous length",
+			buildZiplist(append([]byte{0x80, 0x00, 0x00, 0x01, 0x2C}, huge...), []byte{0xF2}),
+			[]string{huge, "1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeZiplist(test.payload, true)
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeZiplistCorrupt(t *testing.T) {
+	valid := buildZiplist([]byte("\x03foo"), []byte{0xF2})
+
+	tests := []struct {
+		name     string
+		payload  []byte
+		sanitize bool
+		err      string
+	}{
+		{"too short", []byte{0x0B, 0x00, 0x00}, false, "too short"},
+		{"missing end marker", valid[:len(valid)-1], false, "missing its end marker"},
+		{"truncated string", buildZiplist([]byte("\x05ab")), false, "past the end"},
+		{"truncated integer", buildZiplist([]byte{0xD0, 0x01}), false, "past the end"},
+		{"truncated previous length", patch(buildZiplist([]byte{0xF1}), ZIPLIST_HEADER_SIZE, ZIPLIST_BIG_PREVLEN), false, "past the end"},
+		{"unknown encoding", buildZiplist([]byte{0xC1}), false, "unknown entry encoding c1"},
+		{"wrong total size", valid[:len(valid)-1], true, "header size"},
+		{"wrong previous length", patch(valid, ZIPLIST_HEADER_SIZE+5, 0x02), true, "previous length 2, expected 5"},
+		{"wrong tail offset", patch(valid, 4, ZIPLIST_HEADER_SIZE), true, "tail offset"},
+		{"wrong count", patch(valid, 8, 0x03), true, "header count 3"},
+		{"trailing bytes", append(patch(valid, 0, byte(len(valid)+1)), 0x00), true, "1 trailing bytes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeZiplist(test.payload, test.sanitize)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestDecodeZiplistUnsanitized(t *testing.T) {
+	// Header fields are only checked when sanitizing.
+	payload := patch(buildZiplist([]byte("\x03foo")), 8, 0x07)
+
+	got, err := decodeZiplist(payload, false)
+
+	if err != nil {
+		t.Fatalf("got error %v, want none", err)
+	}
+
+	if want := []string{"foo"}; !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := decodeZiplist(payload, true); err == nil {
+		t.Error("got no error when sanitizing")
+	}
+}
+
+func TestReadInt(t *testing.T) {
+	tests := []struct {
+		buf  []byte
+		want int64
+	}{
+		{[]byte{0x80}, -128},
+		{[]byte{0x7F}, 127},
+		{[]byte{0x00, 0x80}, -32768},
+		{[]byte{0xFF, 0xFF, 0xFF}, -1},
+		{[]byte{0x01, 0x00, 0x00, 0x00}, 1},
+		{[]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x80}, -1 << 63},
+	}
+
+	for _, test := range tests {
+		if got := readInt(test.buf, len(test.buf)); got != test.want {
+			t.Errorf("readInt(% x) = %d, want %d", test.buf, got, test.want)
+		}
+	}
+
+	// Bytes past "size" are ignored.
+	if got := readInt(bytes.Repeat([]byte{0xFF}, 4), 2); got != -1 {
+		t.Errorf("got %d, want -1", got)
+	}
+}
@@ -0,0 +1,100 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// A zipmap is laid out as <zmlen><len>"key"<len><free>"value"...<end>, where
+// "free" is the number of unused bytes that follow the value.
+const (
+	ZIPMAP_END = 0xFF
+	// Lengths of 254 bytes or more use a 5 byte encoding.
+	ZIPMAP_BIG_LENGTH = 0xFE
+	// Pair counts of 254 or more are not stored in the header.
+	ZIPMAP_MAX_COUNT = 0xFE
+)
+
+// readZipmapLength returns the length stored at "position" along with the
+// number of bytes used to encode it.
+func readZipmapLength(buf []byte, position int) (int, int, error) {
+	if position >= len(buf) {
+		return 0, 0, errTruncatedEntry
+	}
+
+	length := int(buf[position])
+
+	if length != ZIPMAP_BIG_LENGTH {
+		return length, 1, nil
+	}
+
+	if position+5 > len(buf) {
+		return 0, 0, errTruncatedEntry
+	}
+
+	return int(binary.LittleEndian.Uint32(buf[position+1 : position+5])), 5, nil
+}
+
+// decodeZipmap returns the flattened field/value pairs of a zipmap.
+func decodeZipmap(buf []byte, sanitize bool) ([]string, error) {
+	if len(buf) < 2 {
+		return nil, fmt.Errorf("zipmap of %d bytes is too short", len(buf))
+	}
+
+	count := int(buf[0])
+	entries := []string{}
+	position := 1
+
+	for {
+		if position >= len(buf) {
+			return nil, errors.New("zipmap is missing its end marker")
+		}
+
+		if buf[position] == ZIPMAP_END {
+			break
+		}
+
+		keyLength, headerSize, err := readZipmapLength(buf, position)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid zipmap key at %d: %w", position, err)
+		}
+
+		key, n, err := readEntryString(buf[position:], headerSize, keyLength)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid zipmap key at %d: %w", position, err)
+		}
+
+		position += n
+		valueLength, headerSize, err := readZipmapLength(buf, position)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid zipmap value at %d: %w", position, err)
+		}
+
+		// The "free" byte sits between the length and the contents of the value.
+		value, n, err := readEntryString(buf[position:], headerSize+1, valueLength)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid zipmap value at %d: %w", position, err)
+		}
+
+		free := int(buf[position+headerSize])
+		position += n + free
+		entries = append(entries, key, value)
+	}
+
+	if sanitize {
+		if position != len(buf)-1 {
+			return nil, fmt.Errorf("zipmap has %d trailing bytes", len(buf)-1-position)
+		}
+
+		if count < ZIPMAP_MAX_COUNT && count != len(entries)/2 {
+			return nil, fmt.Errorf("zipmap header count %d does not match pair count %d", count, len(entries)/2)
+		}
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,71 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDecodeZipmap(t *testing.T) {
+	long := strings.Repeat("k", 300)
+	bigKey := append([]byte{0x01, ZIPMAP_BIG_LENGTH}, binary.LittleEndian.AppendUint32(nil, 300)...)
+	bigKey = append(bigKey, long...)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    []string
+	}{
+		{"empty", []byte{0x00, ZIPMAP_END}, []string{}},
+		{"pairs", []byte("\x02\x03foo\x03\x00bar\x01a\x01\x00b\xFF"), []string{"foo", "bar", "a", "b"}},
+		// Free bytes are left after a value that shrank in place.
+		{"free bytes", []byte("\x01\x01a\x01\x02b\x00\x00\xFF"), []string{"a", "b"}},
+		{"5 byte length", append(bigKey, "\x01\x00v\xFF"...), []string{long, "v"}},
+		// Counts of 254 or more are only known by walking the zipmap.
+		{"unknown count", []byte("\xFE\x01a\x01\x00b\xFF"), []string{"a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := decodeZipmap(test.payload, true)
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeZipmapCorrupt(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		sanitize bool
+		err      string
+	}{
+		{"too short", []byte{0x00}, false, "too short"},
+		{"missing end marker", []byte("\x01\x01a\x01\x00b"), false, "missing its end marker"},
+		{"truncated key", []byte("\x01\x05ab\xFF"), false, "invalid zipmap key at 1"},
+		{"truncated key length", []byte("\x01\xFE\x01\x00"), false, "invalid zipmap key at 1"},
+		{"truncated value", []byte("\x01\x01a\x05\x00b\xFF"), false, "invalid zipmap value at 3"},
+		{"missing value", []byte("\x01\x01a"), false, "invalid zipmap value at 3"},
+		{"free bytes past the end", []byte("\x01\x01a\x01\x05b\xFF"), false, "missing its end marker"},
+		{"wrong count", []byte("\x02\x01a\x01\x00b\xFF"), true, "header count 2 does not match pair count 1"},
+		{"trailing bytes", []byte("\x01\x01a\x01\x00b\xFF\x00"), true, "1 trailing bytes"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := decodeZipmap(test.payload, test.sanitize)
+
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -16,11 +17,29 @@ const (
 	simpleStringPrefix = '+'
 )
 
+const (
+	// The largest bulk string Decode accepts, the default value of Redis'
+	// "proto-max-bulk-len" option.
+	DEFAULT_MAX_BULK_LENGTH = 512 * 1024 * 1024
+	// The largest number of elements of an array.
+	MAX_ARRAY_LENGTH = math.MaxInt32
+	// Arrays and bulk strings are allocated at most this many elements or
+	// bytes ahead of the data that was actually received, so that a large
+	// length alone cannot make us allocate it all.
+	PREALLOCATE_LIMIT = 64 * 1024
+)
+
 var (
 	ErrSyntax = errors.New("syntax error")
 )
 
 func Decode(r *bufio.Reader) (any, error) {
+	return DecodeWithMaxBulkLength(r, DEFAULT_MAX_BULK_LENGTH)
+}
+
+// DecodeWithMaxBulkLength decodes a value like Decode, but rejects bulk
+// strings longer than "maxBulkLength" bytes.
+func DecodeWithMaxBulkLength(r *bufio.Reader, maxBulkLength int64) (any, error) {
 	delim, err := r.Peek(1)
 
 	if errors.Is(err, io.EOF) {
@@ -35,10 +54,10 @@ func Decode(r *bufio.Reader) (any, error) {
 
 	switch prefix {
 	case arrayPrefix:
-		return decodeArray(r)
+		return decodeArray(r, maxBulkLength)
 
 	case bulkStringPrefix:
-		return decodeBulkString(r)
+		return decodeBulkString(r, maxBulkLength)
 
 	case integerPrefix:
 		return decodeInteger(r)
@@ -51,7 +70,7 @@ func Decode(r *bufio.Reader) (any, error) {
 	}
 }
 
-func decodeArray(r *bufio.Reader) ([]any, error) {
+func decodeArray(r *bufio.Reader, maxBulkLength int64) ([]any, error) {
 	lengthLine, err := r.ReadBytes('\n')
 
 	if err != nil {
@@ -74,22 +93,26 @@ func decodeArray(r *bufio.Reader) ([]any, error) {
 		return nil, fmt.Errorf("%w: malformed array length \"%s\"", ErrSyntax, lengthLine[1:])
 	}
 
-	arr := make([]any, length)
+	if length < 0 || length > MAX_ARRAY_LENGTH {
+		return nil, fmt.Errorf("%w: invalid array length \"%s\"", ErrSyntax, lengthLine[1:])
+	}
+
+	arr := make([]any, 0, min(length, PREALLOCATE_LIMIT))
 
-	for i := range length {
-		data, err := Decode(r)
+	for range length {
+		data, err := DecodeWithMaxBulkLength(r, maxBulkLength)
 
 		if err != nil {
 			return nil, err
 		}
 
-		arr[i] = data
+		arr = append(arr, data)
 	}
 
 	return arr, nil
 }
 
-func decodeBulkString(r *bufio.Reader) ([]byte, error) {
+func decodeBulkString(r *bufio.Reader, maxBulkLength int64) ([]byte, error) {
 	lengthLine, err := r.ReadBytes('\n')
 
 	if err != nil {
@@ -106,29 +129,36 @@ func decodeBulkString(r *bufio.Reader) ([]byte, error) {
 		return nil, fmt.Errorf("%w: bulk strings must begin with a \"%c\" prefix not \"%c\"", ErrSyntax, bulkStringPrefix, prefix)
 	}
 
-	length, err := strconv.Atoi((string(lengthLine[1:])))
+	length, err := strconv.ParseInt(string(lengthLine[1:]), 10, 64)
 
 	if err != nil {
 		return nil, fmt.Errorf("%w: malformed bulk string length \"%s\"", ErrSyntax, lengthLine[1:])
 	}
 
-	if length == 0 {
-		return []byte(""), nil
+	if length < 0 || length > maxBulkLength {
+		return nil, fmt.Errorf("%w: invalid bulk string length \"%s\"", ErrSyntax, lengthLine[1:])
 	}
 
-	dataLine, err := r.ReadBytes('\n')
+	// Read the data by length rather than up to the next newline, since bulk
+	// strings are binary safe and may contain CRLF sequences of their own.
+	// The buffer grows with the data received rather than with the length
+	// announced.
+	var dataLine bytes.Buffer
+	dataLine.Grow(int(min(length+2, PREALLOCATE_LIMIT)))
+
+	if _, err := io.CopyN(&dataLine, r, length+2); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
 
-	if err != nil {
 		return nil, fmt.Errorf("failed to read bulk string data from buffer: %w", err)
 	}
 
-	dataLine = bytes.TrimRight(dataLine, "\r\n")
-
-	if strLength := len(dataLine); strLength != length {
-		return nil, fmt.Errorf("%w: bulk string length %d does not match expected length: %d", ErrSyntax, strLength, length)
+	if !bytes.HasSuffix(dataLine.Bytes(), []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: bulk string length does not match expected length: %d", ErrSyntax, length)
 	}
 
-	return dataLine, nil
+	return dataLine.Bytes()[:length], nil
 }
 
 func decodeInteger(r *bufio.Reader) (int, error) {
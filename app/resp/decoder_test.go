@@ -0,0 +1,80 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+	}{
+		{"simple string", "+OK\r\n", []byte("OK")},
+		{"integer", ":-42\r\n", -42},
+		{"bulk string", "$5\r\nhello\r\n", []byte("hello")},
+		{"binary bulk string", "$4\r\na\r\nb\r\n", []byte("a\r\nb")},
+		{"empty bulk string", "$0\r\n\r\n", []byte{}},
+		{"array", "*2\r\n$3\r\nGET\r\n:1\r\n", []any{[]byte("GET"), 1}},
+		{"empty array", "*0\r\n", []any{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Decode(bufio.NewReader(strings.NewReader(test.input)))
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeInvalidLength(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"negative bulk string length", "$-2\r\n"},
+		{"bulk string above the limit", "$1025\r\n"},
+		{"huge bulk string", "$9223372036854775807\r\n"},
+		{"negative array length", "*-1\r\n"},
+		{"huge array", "*9223372036854775807\r\n"},
+		{"bulk string above the limit in an array", "*1\r\n$1025\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := DecodeWithMaxBulkLength(bufio.NewReader(strings.NewReader(test.input)), 1024)
+
+			if !errors.Is(err, ErrSyntax) {
+				t.Errorf("got error %v, want a syntax error", err)
+			}
+		})
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	// The announced length is not allocated up front, and the missing data is
+	// reported as a truncation.
+	r := bufio.NewReader(strings.NewReader("$536870912\r\nabc"))
+
+	if _, err := Decode(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("got error %v, want an unexpected EOF", err)
+	}
+
+	r = bufio.NewReader(strings.NewReader("*3\r\n:1\r\n"))
+
+	if _, err := Decode(r); !errors.Is(err, io.EOF) {
+		t.Errorf("got error %v, want an EOF", err)
+	}
+}
@@ -17,10 +17,26 @@ func EncodeError(message string) []byte {
 	return fmt.Appendf(nil, "-ERR %s\r\n", message)
 }
 
+// EncodeErrorCode encodes an error whose first word is "code" instead of the
+// generic "ERR", which lets clients tell specific errors apart.
+func EncodeErrorCode(code, message string) []byte {
+	return fmt.Appendf(nil, "-%s %s\r\n", code, message)
+}
+
+func EncodeInteger(value int) []byte {
+	return fmt.Appendf(nil, ":%d\r\n", value)
+}
+
 func EncodeNull() []byte {
 	return []byte("$-1\r\n")
 }
 
+// EncodeNullArray encodes the null value of RESP2 arrays, which tells it
+// apart from an empty array.
+func EncodeNullArray() []byte {
+	return []byte("*-1\r\n")
+}
+
 func EncodeSimpleString(str string) []byte {
 	return fmt.Appendf(nil, "+%s\r\n", str)
 }
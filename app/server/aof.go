@@ -0,0 +1,907 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+const (
+	// How often the "everysec" policy flushes the AOF to disk.
+	AOF_FSYNC_INTERVAL = time.Second
+	// The types of the files listed in the AOF manifest.
+	AOF_BASE_FILE    = 'b'
+	AOF_INCR_FILE    = 'i'
+	AOF_HISTORY_FILE = 'h'
+)
+
+var (
+	errAofTruncated = errors.New("unexpected end of file")
+)
+
+// aofPreambleError is returned when the RDB preamble of an AOF file is
+// corrupt.
+type aofPreambleError struct {
+	// key is the key being parsed when the error occurred.
+	key string
+	err error
+}
+
+func (e *aofPreambleError) Error() string {
+	return fmt.Sprintf("invalid RDB preamble: %v", e.err)
+}
+
+func (e *aofPreambleError) Unwrap() error {
+	return e.err
+}
+
+// aofFile is a file of a multi-part AOF as listed in its manifest.
+type aofFile struct {
+	name     string
+	seq      int
+	fileType byte
+}
+
+// aofManifest lists the files making up the AOF: a base file holding a
+// snapshot of the dataset, followed by the incremental files holding the
+// writes executed since. History files are leftovers of a rewrite waiting
+// to be deleted.
+type aofManifest struct {
+	base    *aofFile
+	incrs   []*aofFile
+	history []*aofFile
+}
+
+// parseAofManifest parses the lines of a manifest, formatted as
+// "file <name> seq <seq> type <type>".
+func parseAofManifest(data []byte) (*aofManifest, error) {
+	manifest := &aofManifest{}
+
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if len(fields)%2 != 0 {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: \"%s\"", number+1, line)
+		}
+
+		file := &aofFile{}
+
+		for index := 0; index < len(fields); index += 2 {
+			switch fields[index] {
+			case "file":
+				file.name = fields[index+1]
+
+			case "seq":
+				seq, err := strconv.Atoi(fields[index+1])
+
+				if err != nil {
+					return nil, fmt.Errorf("invalid AOF manifest line %d: bad sequence \"%s\"", number+1, fields[index+1])
+				}
+
+				file.seq = seq
+
+			case "type":
+				file.fileType = fields[index+1][0]
+			}
+		}
+
+		if file.name == "" {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: missing file name", number+1)
+		}
+
+		switch file.fileType {
+		case AOF_BASE_FILE:
+			if manifest.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest: found more than one base file")
+			}
+
+			manifest.base = file
+
+		case AOF_INCR_FILE:
+			manifest.incrs = append(manifest.incrs, file)
+
+		case AOF_HISTORY_FILE:
+			manifest.history = append(manifest.history, file)
+
+		default:
+			return nil, fmt.Errorf("invalid AOF manifest line %d: unknown file type", number+1)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (m *aofManifest) encode() []byte {
+	var buf bytes.Buffer
+
+	files := append([]*aofFile{}, m.history...)
+
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+
+	for _, file := range append(files, m.incrs...) {
+		fmt.Fprintf(&buf, "file %s seq %d type %c\n", file.name, file.seq, file.fileType)
+	}
+
+	return buf.Bytes()
+}
+
+// files returns the files to load, in order.
+func (m *aofManifest) files() []*aofFile {
+	files := []*aofFile{}
+
+	if m.base != nil {
+		files = append(files, m.base)
+	}
+
+	return append(files, m.incrs...)
+}
+
+func (m *aofManifest) nextBaseSeq() int {
+	if m.base == nil {
+		return 1
+	}
+
+	return m.base.seq + 1
+}
+
+func (m *aofManifest) nextIncrSeq() int {
+	if len(m.incrs) == 0 {
+		return 1
+	}
+
+	return m.incrs[len(m.incrs)-1].seq + 1
+}
+
+// appendOnlyFile logs every write applied to the dataset, in the same form
+// as it is propagated to replicas, so the dataset can be rebuilt by
+// replaying the files listed in its manifest on startup.
+type appendOnlyFile struct {
+	mu sync.Mutex
+	// dir is the directory holding the files of the AOF, which are named
+	// after filename.
+	dir      string
+	filename string
+	manifest *aofManifest
+	// incr is the incremental file writes are appended to.
+	incr *os.File
+	// fsyncPolicy is the "appendfsync" option: "always" flushes the file to
+	// disk after every write, "everysec" once per second and "no" leaves it
+	// to the operating system.
+	fsyncPolicy string
+	// db is the database last selected in the incremental file.
+	db int
+	// pendingFsync is set when the incremental file was written since it
+	// was last flushed to disk.
+	pendingFsync bool
+	lastFsync    time.Time
+	// lastWriteErr is set when writing or flushing the incremental file
+	// failed, until a retry succeeds. Writes are refused meanwhile.
+	lastWriteErr   error
+	lastWriteRetry time.Time
+	// unwritten holds the commands that failed to be written, which are
+	// retried before anything else is appended.
+	unwritten []byte
+	// The size of all files, and of the base file, which is the size of
+	// the AOF after it was last rewritten.
+	currentSize int64
+	baseSize    int64
+	rewriting   bool
+	// lastRewriteErr is the outcome of the last rewrite.
+	lastRewriteErr error
+}
+
+// openAof reads the manifest of the AOF stored in "dir", if any. An AOF
+// written as a single file before multi-part AOFs is adopted as the base
+// file.
+func openAof(dir, filename, fsyncPolicy string) (*appendOnlyFile, error) {
+	a := &appendOnlyFile{
+		dir:         dir,
+		filename:    filename,
+		fsyncPolicy: fsyncPolicy,
+		db:          -1,
+		lastFsync:   time.Now(),
+	}
+
+	data, err := os.ReadFile(a.manifestPath())
+
+	if err == nil {
+		if a.manifest, err = parseAofManifest(data); err != nil {
+			return nil, err
+		}
+
+		// Finish the cleanup of a rewrite interrupted by a crash.
+		if len(a.manifest.history) > 0 {
+			if err := a.deleteHistory(); err != nil {
+				return nil, err
+			}
+		}
+
+		return a, nil
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read AOF manifest: %w", err)
+	}
+
+	if _, err := os.Stat(a.filePath(filename)); err == nil {
+		a.manifest = &aofManifest{
+			base: &aofFile{name: filename, seq: 1, fileType: AOF_BASE_FILE},
+		}
+
+		if err := a.persistManifest(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+func (a *appendOnlyFile) filePath(name string) string {
+	return path.Join(a.dir, name)
+}
+
+func (a *appendOnlyFile) manifestPath() string {
+	return a.filePath(a.filename + ".manifest")
+}
+
+func (a *appendOnlyFile) baseName(seq int, preamble bool) string {
+	if preamble {
+		return fmt.Sprintf("%s.%d.base.rdb", a.filename, seq)
+	}
+
+	return fmt.Sprintf("%s.%d.base.aof", a.filename, seq)
+}
+
+func (a *appendOnlyFile) incrName(seq int) string {
+	return fmt.Sprintf("%s.%d.incr.aof", a.filename, seq)
+}
+
+// persistManifest saves the manifest. It is written to a temporary file
+// first and renamed, so a crash leaves either the old or the new manifest.
+func (a *appendOnlyFile) persistManifest() error {
+	temp := a.filePath("temp-" + a.filename + ".manifest")
+
+	if err := writeFileSync(temp, func(fd *os.File) error {
+		_, err := fd.Write(a.manifest.encode())
+		return err
+	}); err != nil {
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+
+	if err := os.Rename(temp, a.manifestPath()); err != nil {
+		return fmt.Errorf("failed to write AOF manifest: %w", err)
+	}
+
+	return syncDir(a.dir)
+}
+
+// deleteHistory deletes the history files and removes them from the manifest.
+func (a *appendOnlyFile) deleteHistory() error {
+	for _, file := range a.manifest.history {
+		if err := os.Remove(a.filePath(file.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete AOF history file: %w", err)
+		}
+	}
+
+	a.manifest.history = nil
+
+	return a.persistManifest()
+}
+
+// writeBase writes a base file holding a snapshot of "data", using the RDB
+// format when "preamble" is set and commands otherwise. It returns the
+// name of the file. "policy" is the eviction policy passed to writeRdb.
+func (a *appendOnlyFile) writeBase(seq int, data *cache.Cache, preamble bool, policy string) (string, error) {
+	name := a.baseName(seq, preamble)
+	temp := a.filePath(fmt.Sprintf("temp-rewriteaof-%d.aof", os.Getpid()))
+
+	err := writeFileSync(temp, func(fd *os.File) error {
+		if preamble {
+			return writeRdb(fd, data, policy)
+		}
+
+		return writeAofCommands(fd, data)
+	})
+
+	if err == nil {
+		err = os.Rename(temp, a.filePath(name))
+	}
+
+	if err != nil {
+		os.Remove(temp)
+		return "", fmt.Errorf("failed to write AOF base file: %w", err)
+	}
+
+	return name, nil
+}
+
+// openIncr starts appending writes to a new incremental file. It must be
+// called with a.mu held.
+func (a *appendOnlyFile) openIncr() error {
+	file := &aofFile{
+		name:     a.incrName(a.manifest.nextIncrSeq()),
+		seq:      a.manifest.nextIncrSeq(),
+		fileType: AOF_INCR_FILE,
+	}
+
+	fd, err := os.OpenFile(a.filePath(file.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return fmt.Errorf("failed to open AOF incremental file: %w", err)
+	}
+
+	a.manifest.incrs = append(a.manifest.incrs, file)
+
+	if err := a.persistManifest(); err != nil {
+		a.manifest.incrs = a.manifest.incrs[:len(a.manifest.incrs)-1]
+		fd.Close()
+
+		return err
+	}
+
+	a.closeIncr()
+	a.incr = fd
+	a.db = -1
+
+	return nil
+}
+
+// closeIncr flushes and closes the incremental file. It must be called
+// with a.mu held.
+func (a *appendOnlyFile) closeIncr() error {
+	if a.incr == nil {
+		return nil
+	}
+
+	fsyncErr := a.fsync()
+	err := errors.Join(fsyncErr, a.incr.Close())
+	a.incr = nil
+
+	return err
+}
+
+// start prepares the AOF for appending writes. A new AOF starts with a base
+// file holding "data", the dataset loaded so far, while an existing one is
+// appended to its last incremental file.
+func (a *appendOnlyFile) start(data *cache.Cache, preamble bool, policy string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := os.MkdirAll(a.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create AOF directory: %w", err)
+	}
+
+	if a.manifest == nil {
+		name, err := a.writeBase(1, data, preamble, policy)
+
+		if err != nil {
+			return err
+		}
+
+		a.manifest = &aofManifest{
+			base: &aofFile{name: name, seq: 1, fileType: AOF_BASE_FILE},
+		}
+	}
+
+	if len(a.manifest.incrs) > 0 {
+		last := a.manifest.incrs[len(a.manifest.incrs)-1]
+		fd, err := os.OpenFile(a.filePath(last.name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+		if err != nil {
+			return fmt.Errorf("failed to open AOF incremental file: %w", err)
+		}
+
+		a.incr = fd
+	} else if err := a.openIncr(); err != nil {
+		return err
+	}
+
+	a.baseSize = a.sizeOf(a.manifest.base)
+	a.currentSize = a.baseSize
+
+	for _, file := range a.manifest.incrs {
+		a.currentSize += a.sizeOf(file)
+	}
+
+	return nil
+}
+
+// sizeOf returns the size of a file of the AOF, or 0 when it is missing.
+func (a *appendOnlyFile) sizeOf(file *aofFile) int64 {
+	if file == nil {
+		return 0
+	}
+
+	info, err := os.Stat(a.filePath(file.name))
+
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// append logs a write command executed against database "db".
+func (a *appendOnlyFile) append(db int, argv [][]byte) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if db != a.db {
+		a.unwritten = append(a.unwritten, encodeCommand([][]byte{[]byte(SELECT), []byte(strconv.Itoa(db))})...)
+		a.db = db
+	}
+
+	a.unwritten = append(a.unwritten, encodeCommand(argv)...)
+
+	return a.flush()
+}
+
+// flush writes the commands that were not written yet to the incremental
+// file. It must be called with a.mu held.
+func (a *appendOnlyFile) flush() error {
+	if len(a.unwritten) == 0 {
+		return nil
+	}
+
+	n, err := a.incr.Write(a.unwritten)
+
+	// Drop what was written of a failed write, since a partial command would
+	// make the file look truncated. What cannot be dropped is not retried.
+	if err != nil && n > 0 {
+		if info, statErr := a.incr.Stat(); statErr == nil && a.incr.Truncate(info.Size()-int64(n)) == nil {
+			n = 0
+		}
+	}
+
+	a.currentSize += int64(n)
+	a.unwritten = a.unwritten[n:]
+
+	if err != nil {
+		a.lastWriteErr = err
+		return fmt.Errorf("failed to write to AOF: %w", err)
+	}
+
+	a.unwritten = nil
+	a.lastWriteErr = nil
+	a.pendingFsync = true
+
+	if a.fsyncPolicy == "always" {
+		return a.fsync()
+	}
+
+	return nil
+}
+
+// writeError returns the error that keeps the AOF from being written to,
+// if any.
+func (a *appendOnlyFile) writeError() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.lastWriteErr
+}
+
+// fsync flushes the incremental file to disk. It must be called with a.mu
+// held.
+func (a *appendOnlyFile) fsync() error {
+	if !a.pendingFsync {
+		return nil
+	}
+
+	if err := a.incr.Sync(); err != nil {
+		a.lastWriteErr = err
+		return fmt.Errorf("failed to fsync AOF: %w", err)
+	}
+
+	a.pendingFsync = false
+	a.lastFsync = time.Now()
+
+	if len(a.unwritten) == 0 {
+		a.lastWriteErr = nil
+	}
+
+	return nil
+}
+
+// fsyncIfDue flushes the file to disk when the "everysec" policy calls for
+// it. Writes and flushes that failed are retried once per interval instead.
+func (a *appendOnlyFile) fsyncIfDue() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.lastWriteErr != nil {
+		if time.Since(a.lastWriteRetry) < AOF_FSYNC_INTERVAL {
+			return nil
+		}
+
+		a.lastWriteRetry = time.Now()
+
+		if err := a.flush(); err != nil {
+			return err
+		}
+
+		return a.fsync()
+	}
+
+	if a.fsyncPolicy != "everysec" || time.Since(a.lastFsync) < AOF_FSYNC_INTERVAL {
+		return nil
+	}
+
+	return a.fsync()
+}
+
+// shouldRewrite reports whether the AOF grew by "percentage" percent since
+// it was last rewritten, while being at least "minSize" bytes.
+func (a *appendOnlyFile) shouldRewrite(percentage int, minSize int64) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting || percentage <= 0 || a.currentSize < minSize {
+		return false
+	}
+
+	base := max(a.baseSize, 1)
+
+	return (a.currentSize-base)*100/base >= int64(percentage)
+}
+
+// beginRewrite switches writes to a new incremental file, so the files
+// listed so far can be replaced by a base file holding a snapshot of the
+// dataset taken now. It returns the sequence of that base file.
+func (a *appendOnlyFile) beginRewrite() (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.rewriting {
+		return 0, fmt.Errorf("Background append only file rewriting already in progress")
+	}
+
+	// The commands that failed to be written belong to the files the
+	// rewrite replaces, or they would be applied twice.
+	if err := a.flush(); err != nil {
+		return 0, err
+	}
+
+	if err := a.openIncr(); err != nil {
+		return 0, err
+	}
+
+	a.rewriting = true
+
+	return a.manifest.nextBaseSeq(), nil
+}
+
+// finishRewrite makes the base file "name" replace the base and incremental
+// files it holds the contents of, leaving the incremental file opened by
+// beginRewrite. The switch happens when the new manifest is renamed over
+// the old one.
+func (a *appendOnlyFile) finishRewrite(name string, seq int, err error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewriting = false
+
+	if err == nil {
+		manifest := a.manifest
+		history := manifest.incrs[:len(manifest.incrs)-1]
+
+		if manifest.base != nil {
+			history = append([]*aofFile{manifest.base}, history...)
+		}
+
+		for _, file := range history {
+			manifest.history = append(manifest.history, &aofFile{name: file.name, seq: file.seq, fileType: AOF_HISTORY_FILE})
+		}
+
+		manifest.base = &aofFile{name: name, seq: seq, fileType: AOF_BASE_FILE}
+		manifest.incrs = manifest.incrs[len(manifest.incrs)-1:]
+
+		if err = a.persistManifest(); err == nil {
+			err = a.deleteHistory()
+		}
+
+		a.baseSize = a.sizeOf(manifest.base)
+		a.currentSize = a.baseSize + a.sizeOf(manifest.incrs[0])
+	}
+
+	a.lastRewriteErr = err
+
+	return err
+}
+
+func (a *appendOnlyFile) close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return errors.Join(a.flush(), a.closeIncr())
+}
+
+// writeAofCommands writes the contents of "data" as the commands recreating
+// it.
+func writeAofCommands(w io.Writer, data *cache.Cache) error {
+	bw := bufio.NewWriter(w)
+	now := time.Now()
+
+	// todo: support multiple logical databases
+	bw.Write(encodeCommand([][]byte{[]byte(SELECT), []byte("0")}))
+
+	var err error
+
+	data.Range(func(key string, item any, expiry time.Time) bool {
+		if !expiry.IsZero() && !expiry.After(now) {
+			return true
+		}
+
+		var value []byte
+
+		switch v := item.(type) {
+		case []byte:
+			value = v
+
+		case string:
+			value = []byte(v)
+
+		default:
+			err = fmt.Errorf("cannot rewrite key \"%s\" of type %T as commands, enable aof-use-rdb-preamble", key, v)
+			return false
+		}
+
+		argv := [][]byte{[]byte(SET), []byte(key), value}
+
+		if !expiry.IsZero() {
+			argv = append(argv, []byte("PXAT"), strconv.AppendInt(nil, expiry.UnixMilli(), 10))
+		}
+
+		bw.Write(encodeCommand(argv))
+
+		return true
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+// writeFileSync creates the file at "filePath" with the contents written by
+// "write", and flushes it to disk.
+func writeFileSync(filePath string, write func(fd *os.File) error) error {
+	fd, err := os.Create(filePath)
+
+	if err != nil {
+		return err
+	}
+
+	if err := write(fd); err != nil {
+		fd.Close()
+		return err
+	}
+
+	if err := fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+
+	return fd.Close()
+}
+
+// syncDir flushes the entries of a directory to disk, making renames in it
+// durable.
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	return fd.Sync()
+}
+
+// aofDir returns the directory holding the AOF, built from the "dir" and
+// "appenddirname" options.
+func (s *Server) aofDir() string {
+	return path.Join(s.config.Get("dir"), s.config.Get("appenddirname"))
+}
+
+// appendToAof logs a write command executed on "conn" to the AOF, when
+// enabled. It must be called with s.writeMu held, so commands are logged in
+// the order they were executed.
+func (s *Server) appendToAof(conn *connection, argv [][]byte) {
+	if s.aof == nil {
+		return
+	}
+
+	if err := s.aof.append(conn.db, argv); err != nil {
+		fmt.Printf("%v\n", err)
+	}
+}
+
+// loadAof rebuilds the dataset from the files listed in the AOF manifest.
+func (s *Server) loadAof(a *appendOnlyFile) error {
+	files := a.manifest.files()
+
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	for index, file := range files {
+		if err := s.loadAofFile(a.filePath(file.name), index == len(files)-1); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	s.lastSave = time.Now()
+	s.mu.Unlock()
+
+	s.stats.update(func(st *stats) {
+		st.dirty = 0
+	})
+
+	return nil
+}
+
+// loadAofFile replays a file of the AOF. A command cut short at the end of
+// the "last" file, e.g. by a crash, is dropped from the file when the
+// "aof-load-truncated" option is set.
+func (s *Server) loadAofFile(filePath string, last bool) error {
+	fd, err := os.OpenFile(filePath, os.O_RDWR, 0)
+
+	if err != nil {
+		return fmt.Errorf("failed to open \"%s\" file: %w", filePath, err)
+	}
+
+	defer fd.Close()
+
+	// Commands are replayed on a pseudo connection that discards the replies.
+	conn := &connection{isMaster: true, stats: s.stats}
+	sanitize := s.config.Get("sanitize-dump-payload") == "yes"
+
+	offset, size, err := readAofFile(fd, &rdbLoader{cache: s.cache}, sanitize, func(command any) {
+		s.handleCommands(conn, command)
+	})
+
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, errAofTruncated) || !last || s.config.Get("aof-load-truncated") != "yes" {
+		return fmt.Errorf("failed to load \"%s\" file: %w", filePath, err)
+	}
+
+	fmt.Printf("AOF \"%s\" is truncated, dropping the last %d bytes\n", filePath, size-offset)
+
+	if err := fd.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate \"%s\" file: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// readAofFile reads a file of the AOF: an optional RDB preamble, whose
+// records are handed to "v", followed by commands handed to "onCommand".
+// It returns the offset up to which the file is valid and its size. When
+// the file ends in the middle of a command, the error wraps
+// errAofTruncated.
+func readAofFile(fd *os.File, v rdb.Visitor, sanitize bool, onCommand func(command any)) (int64, int64, error) {
+	info, err := fd.Stat()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reader := bufio.NewReader(fd)
+
+	// consumed returns the offset of the first byte not processed yet.
+	consumed := func() int64 {
+		offset, _ := fd.Seek(0, io.SeekCurrent)
+		return offset - int64(reader.Buffered())
+	}
+
+	if magic, _ := reader.Peek(len("REDIS")); bytes.Equal(magic, []byte("REDIS")) {
+		parser := rdb.NewParser(rdb.ParserOpts{
+			SanitizePayload: sanitize,
+		})
+
+		// The parser reads from our buffered reader as is, so the commands
+		// start right after the preamble.
+		if err := parser.ParseReader(reader, v); err != nil {
+			return parser.Offset(), info.Size(), &aofPreambleError{key: parser.Key(), err: err}
+		}
+	}
+
+	for {
+		offset := consumed()
+		command, err := resp.Decode(reader)
+
+		if err == nil {
+			onCommand(command)
+			continue
+		}
+
+		if offset == info.Size() {
+			return offset, info.Size(), nil
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return offset, info.Size(), fmt.Errorf("%w at offset %d", errAofTruncated, offset)
+		}
+
+		return offset, info.Size(), fmt.Errorf("bad format at offset %d: %w", offset, err)
+	}
+}
+
+// startAof opens the AOF for the writes that follow.
+func (s *Server) startAof(a *appendOnlyFile) error {
+	if err := a.start(s.cache, s.config.Get("aof-use-rdb-preamble") == "yes", s.config.Get("maxmemory-policy")); err != nil {
+		return err
+	}
+
+	s.aof = a
+
+	return nil
+}
+
+// rewriteAof compacts the AOF into a new base file holding a snapshot of
+// the dataset. The snapshot is written in the background unless "wait" is
+// set, while writes go to a new incremental file.
+func (s *Server) rewriteAof(wait bool) error {
+	if s.aof == nil {
+		return fmt.Errorf("Append only file is disabled")
+	}
+
+	// The snapshot must match the point where writes switch files.
+	s.writeMu.Lock()
+	seq, err := s.aof.beginRewrite()
+	data := s.cache.Snapshot()
+	s.writeMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	a := s.aof
+	preamble := s.config.Get("aof-use-rdb-preamble") == "yes"
+	policy := s.config.Get("maxmemory-policy")
+
+	rewrite := func() error {
+		name, err := a.writeBase(seq, data, preamble, policy)
+
+		if err := a.finishRewrite(name, seq, err); err != nil {
+			fmt.Printf("Failed to rewrite AOF: %v\n", err)
+			return err
+		}
+
+		return nil
+	}
+
+	if wait {
+		return rewrite()
+	}
+
+	go rewrite()
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAofWriteErrorIsRetried(t *testing.T) {
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "appendonly.aof.1.incr.aof")
+
+	if err := os.WriteFile(filePath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes to a file opened for reading fail.
+	readOnly, err := os.Open(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer readOnly.Close()
+
+	a := &appendOnlyFile{dir: dir, incr: readOnly, fsyncPolicy: "everysec", db: -1, lastFsync: time.Now()}
+
+	if err := a.append(0, [][]byte{[]byte("SET"), []byte("k"), []byte("v")}); err == nil {
+		t.Fatal("got no error writing to a read only file")
+	}
+
+	if a.writeError() == nil {
+		t.Fatal("got no write error after a failed write")
+	}
+
+	writable, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a.incr = writable
+
+	if err := a.fsyncIfDue(); err != nil {
+		t.Fatalf("got error %v retrying the write", err)
+	}
+
+	if err := a.writeError(); err != nil {
+		t.Errorf("got write error %v after the retry succeeded", err)
+	}
+
+	if err := a.append(0, [][]byte{[]byte("DEL"), []byte("k")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The failed command is written once, after the database it was
+	// executed against is selected.
+	want := "*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nDEL\r\n$1\r\nk\r\n"
+
+	if string(data) != want {
+		t.Errorf("got AOF %q, want %q", data, want)
+	}
+}
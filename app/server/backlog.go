@@ -0,0 +1,53 @@
+package server
+
+const (
+	// The smallest backlog we allocate, regardless of "repl-backlog-size".
+	MIN_BACKLOG_SIZE = 16 * 1024
+)
+
+// backlog is a circular buffer holding the most recent part of the
+// replication stream. Replicas that briefly lose their link can resume
+// from it instead of performing a full resynchronization.
+type backlog struct {
+	buf []byte
+	// next is the position the next byte is written at.
+	next int
+	// length is the number of bytes of history held in buf.
+	length int
+}
+
+func newBacklog(size int) *backlog {
+	return &backlog{
+		buf: make([]byte, max(size, MIN_BACKLOG_SIZE)),
+	}
+}
+
+func (b *backlog) write(data []byte) {
+	size := len(b.buf)
+
+	if len(data) >= size {
+		copy(b.buf, data[len(data)-size:])
+		b.next = 0
+		b.length = size
+		return
+	}
+
+	n := copy(b.buf[b.next:], data)
+	copy(b.buf, data[n:])
+
+	b.next = (b.next + len(data)) % size
+	b.length = min(b.length+len(data), size)
+}
+
+// tail returns the last "n" bytes written to the backlog, which must not be
+// more than it holds.
+func (b *backlog) tail(n int) []byte {
+	size := len(b.buf)
+	data := make([]byte, n)
+	start := (b.next - n + size) % size
+
+	copied := copy(data, b.buf[start:min(start+n, size)])
+	copy(data[copied:], b.buf[:n-copied])
+
+	return data
+}
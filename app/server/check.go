@@ -0,0 +1,194 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+)
+
+// keyCounter counts the keys of an RDB payload by type.
+type keyCounter struct {
+	rdb.NopVisitor
+	keys    int
+	expires int
+	types   map[string]int
+}
+
+func newKeyCounter() *keyCounter {
+	return &keyCounter{
+		types: map[string]int{},
+	}
+}
+
+func (c *keyCounter) OnEntry(entry rdb.DatabaseEntry) error {
+	c.keys += 1
+	c.types[rdb.TypeName(entry.Value)] += 1
+
+	if !entry.Expiry.IsZero() {
+		c.expires += 1
+	}
+
+	return nil
+}
+
+func (c *keyCounter) report(w io.Writer) {
+	fmt.Fprintf(w, "Keys: %d (%d with an expiry)\n", c.keys, c.expires)
+
+	for _, name := range slices.Sorted(maps.Keys(c.types)) {
+		fmt.Fprintf(w, "  %s: %d\n", name, c.types[name])
+	}
+}
+
+// CheckRdb validates the RDB file at "filePath" and writes a report to "w":
+// the number of keys of every type, or where the file is corrupt and which
+// key was being read.
+func CheckRdb(filePath string, w io.Writer) error {
+	fd, err := os.Open(filePath)
+
+	if err != nil {
+		return fmt.Errorf("failed to open \"%s\" file: %w", filePath, err)
+	}
+
+	defer fd.Close()
+
+	fmt.Fprintf(w, "Checking RDB file \"%s\"\n", filePath)
+
+	parser := rdb.NewParser(rdb.ParserOpts{
+		SanitizePayload: true,
+	})
+	counter := newKeyCounter()
+
+	if err := parser.ParseReader(fd, counter); err != nil {
+		var corruptionErr *rdb.CorruptionError
+
+		if errors.As(err, &corruptionErr) {
+			fmt.Fprintf(w, "Error: %v\n", err)
+		} else {
+			fmt.Fprintf(w, "Error at offset %d: %v\n", parser.Offset(), err)
+		}
+
+		if key := parser.Key(); key != "" {
+			fmt.Fprintf(w, "Last key read: \"%s\"\n", key)
+		}
+
+		counter.report(w)
+
+		return fmt.Errorf("RDB file \"%s\" is corrupt", filePath)
+	}
+
+	counter.report(w)
+	fmt.Fprintln(w, "RDB looks OK")
+
+	return nil
+}
+
+// CheckAof validates an AOF and writes a report to "w". "filePath" is either
+// a manifest, in which case every file it lists is checked, or a single AOF
+// file. When "fix" is set, a last file ending in the middle of a command is
+// truncated to its last valid command.
+func CheckAof(filePath string, fix bool, w io.Writer) error {
+	files := []string{filePath}
+
+	if strings.HasSuffix(filePath, ".manifest") {
+		data, err := os.ReadFile(filePath)
+
+		if err != nil {
+			return fmt.Errorf("failed to read AOF manifest: %w", err)
+		}
+
+		manifest, err := parseAofManifest(data)
+
+		if err != nil {
+			return err
+		}
+
+		files = nil
+
+		for _, file := range manifest.files() {
+			files = append(files, path.Join(path.Dir(filePath), file.name))
+		}
+
+		fmt.Fprintf(w, "Checking AOF manifest \"%s\" listing %d files\n", filePath, len(files))
+	}
+
+	for index, file := range files {
+		if err := checkAofFile(file, fix && index == len(files)-1, w); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintln(w, "AOF looks OK")
+
+	return nil
+}
+
+// checkAofFile validates a file of an AOF, truncating it to its last valid
+// command when "fix" is set and the file was cut short.
+func checkAofFile(filePath string, fix bool, w io.Writer) error {
+	// Checking alone must work on read-only files and never modify them.
+	flag := os.O_RDONLY
+
+	if fix {
+		flag = os.O_RDWR
+	}
+
+	fd, err := os.OpenFile(filePath, flag, 0)
+
+	if err != nil {
+		return fmt.Errorf("failed to open \"%s\" file: %w", filePath, err)
+	}
+
+	defer fd.Close()
+
+	fmt.Fprintf(w, "Checking AOF file \"%s\"\n", filePath)
+
+	counter := newKeyCounter()
+	commands := 0
+
+	offset, size, err := readAofFile(fd, counter, true, func(command any) {
+		commands += 1
+	})
+
+	if counter.keys > 0 {
+		fmt.Fprint(w, "RDB preamble: ")
+		counter.report(w)
+	}
+
+	fmt.Fprintf(w, "Commands: %d\n", commands)
+	fmt.Fprintf(w, "Size: %d, valid up to: %d, diff: %d\n", size, offset, size-offset)
+
+	if err == nil {
+		return nil
+	}
+
+	fmt.Fprintf(w, "Error: %v\n", err)
+
+	var preambleErr *aofPreambleError
+
+	if errors.As(err, &preambleErr) && preambleErr.key != "" {
+		fmt.Fprintf(w, "Last key read: \"%s\"\n", preambleErr.key)
+	}
+
+	if !errors.Is(err, errAofTruncated) {
+		return fmt.Errorf("AOF file \"%s\" is corrupt and cannot be fixed automatically", filePath)
+	}
+
+	if !fix {
+		return fmt.Errorf("AOF file \"%s\" is truncated, run with --fix to truncate it to its last valid command", filePath)
+	}
+
+	if err := fd.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate \"%s\" file: %w", filePath, err)
+	}
+
+	fmt.Fprintf(w, "Successfully truncated AOF file \"%s\" to %d bytes\n", filePath, offset)
+
+	return nil
+}
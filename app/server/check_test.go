@@ -0,0 +1,41 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAofFileTruncated(t *testing.T) {
+	valid := "*1\r\n$4\r\nPING\r\n"
+	filePath := filepath.Join(t.TempDir(), "appendonly.aof")
+
+	if err := os.WriteFile(filePath, []byte(valid+"*1\r\n$4\r\nPI"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without --fix the file is only read, so it may be read-only.
+	err := checkAofFile(filePath, false, io.Discard)
+
+	if err == nil || !strings.Contains(err.Error(), "is truncated") {
+		t.Fatalf("got error %v, want a truncated file", err)
+	}
+
+	if info, _ := os.Stat(filePath); info.Size() != int64(len(valid))+10 {
+		t.Errorf("got size %d after checking, want the file left as is", info.Size())
+	}
+
+	if err := os.Chmod(filePath, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkAofFile(filePath, true, io.Discard); err != nil {
+		t.Fatalf("got error %v fixing the file", err)
+	}
+
+	if data, _ := os.ReadFile(filePath); string(data) != valid {
+		t.Errorf("got %q after fixing, want %q", data, valid)
+	}
+}
@@ -2,31 +2,91 @@ package server
 
 import (
 	"bytes"
-	"encoding/hex"
 	"fmt"
-	"net"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/memory"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
 	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
 )
 
 var (
-	CONFIG   = "CONFIG"
-	ECHO     = "ECHO"
-	GET      = "GET"
-	INFO     = "INFO"
-	KEYS     = "KEYS"
-	PING     = "PING"
-	PSYNC    = "PSYNC"
-	REPLCONF = "REPLCONF"
-	SET      = "SET"
+	BGREWRITEAOF = "BGREWRITEAOF"
+	CLIENT       = "CLIENT"
+	CONFIG       = "CONFIG"
+	DEL          = "DEL"
+	DISCARD      = "DISCARD"
+	ECHO         = "ECHO"
+	EXEC         = "EXEC"
+	GET          = "GET"
+	INFO         = "INFO"
+	KEYS         = "KEYS"
+	MEMORY       = "MEMORY"
+	MSET         = "MSET"
+	MULTI        = "MULTI"
+	OBJECT       = "OBJECT"
+	PING         = "PING"
+	PSUBSCRIBE   = "PSUBSCRIBE"
+	PSYNC        = "PSYNC"
+	PUBLISH      = "PUBLISH"
+	PUNSUBSCRIBE = "PUNSUBSCRIBE"
+	RENAME       = "RENAME"
+	REPLCONF     = "REPLCONF"
+	REPLICAOF    = "REPLICAOF"
+	SELECT       = "SELECT"
+	SET          = "SET"
+	SLAVEOF      = "SLAVEOF"
+	SUBSCRIBE    = "SUBSCRIBE"
+	TYPE         = "TYPE"
+	UNSUBSCRIBE  = "UNSUBSCRIBE"
+	WAIT         = "WAIT"
 )
 
+type CommandFlag int
+
 const (
-	RDB_DUMP = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+	// The command may modify the dataset, so it is propagated to replicas.
+	WRITE_COMMAND CommandFlag = 1 << iota
+	// The command is allowed while a replica has no up to date data, even
+	// when "replica-serve-stale-data" is disabled.
+	STALE_COMMAND
+	// The command may use more memory, so it is refused when the dataset
+	// exceeds "maxmemory" and no key can be evicted.
+	DENYOOM_COMMAND
+	// The command reads the key given as first argument, which clients
+	// tracking keys may then cache.
+	READ_COMMAND
+	// The command is allowed while the dataset is being loaded.
+	LOADING_COMMAND
 )
 
+var commandFlags = map[string]CommandFlag{
+	CLIENT:       STALE_COMMAND | LOADING_COMMAND,
+	CONFIG:       STALE_COMMAND | LOADING_COMMAND,
+	DEL:          WRITE_COMMAND,
+	DISCARD:      STALE_COMMAND | LOADING_COMMAND,
+	GET:          READ_COMMAND,
+	INFO:         STALE_COMMAND | LOADING_COMMAND,
+	MSET:         WRITE_COMMAND | DENYOOM_COMMAND,
+	MULTI:        STALE_COMMAND | LOADING_COMMAND,
+	PSUBSCRIBE:   STALE_COMMAND | LOADING_COMMAND,
+	PUBLISH:      STALE_COMMAND | LOADING_COMMAND,
+	PUNSUBSCRIBE: STALE_COMMAND | LOADING_COMMAND,
+	RENAME:       WRITE_COMMAND,
+	REPLCONF:     STALE_COMMAND | LOADING_COMMAND,
+	REPLICAOF:    STALE_COMMAND | LOADING_COMMAND,
+	SET:          WRITE_COMMAND | DENYOOM_COMMAND,
+	SLAVEOF:      STALE_COMMAND | LOADING_COMMAND,
+	SUBSCRIBE:    STALE_COMMAND | LOADING_COMMAND,
+	TYPE:         READ_COMMAND,
+	UNSUBSCRIBE:  STALE_COMMAND | LOADING_COMMAND,
+}
+
 func handleConfigGetCommand(config *Config, args []any) []byte {
 	if len(args) == 0 {
 		return resp.EncodeError("\"CONFIG GET\" command requires at least one argument")
@@ -56,7 +116,194 @@ func handleConfigGetCommand(config *Config, args []any) []byte {
 	return resp.EncodeArray(entries)
 }
 
-func (s *Server) handleConfigCommand(conn net.Conn, args []any) {
+func (s *Server) handleBgRewriteAofCommand(conn *connection) {
+	if err := s.rewriteAof(false); err != nil {
+		conn.Write(resp.EncodeError(err.Error()))
+		return
+	}
+
+	conn.Write(resp.EncodeSimpleString("Background append only file rewriting started"))
+}
+
+var clientHelp = []string{
+	"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"CACHING (YES|NO)",
+	"    Enable/disable tracking of the keys for next command in OPTIN/OPTOUT modes.",
+	"GETREDIR",
+	"    Return the client ID we are redirecting to when tracking is enabled.",
+	"ID",
+	"    Return the ID of the current connection.",
+	"TRACKING (ON|OFF) [REDIRECT <id>] [BCAST] [PREFIX <prefix> [...]]",
+	"         [OPTIN] [OPTOUT] [NOLOOP]",
+	"    Control server assisted client side caching.",
+	"TRACKINGINFO",
+	"    Report tracking status for the current connection.",
+	"HELP",
+	"    Print this help.",
+}
+
+func (s *Server) handleClientCommand(conn *connection, args []any) {
+	err := resp.EncodeError("\"CLIENT\" command must be followed by one of the following subcommands \"CACHING\", \"GETREDIR\", \"HELP\", \"ID\", \"TRACKING\" or \"TRACKINGINFO\"")
+
+	if len(args) == 0 {
+		conn.Write(err)
+		return
+	}
+
+	subcommand, ok := args[0].([]byte)
+
+	if !ok {
+		conn.Write(err)
+		return
+	}
+
+	switch strings.ToUpper(string(subcommand)) {
+	case "CACHING":
+		s.handleClientCachingCommand(conn, args[1:])
+
+	case "GETREDIR":
+		tracking := s.tracking.info(conn)
+		redirect := int64(-1)
+
+		if tracking.enabled {
+			redirect = tracking.redirect
+		}
+
+		conn.Write(resp.EncodeInteger(int(redirect)))
+
+	case "HELP":
+		conn.Write(encodeHelp(clientHelp))
+
+	case "ID":
+		conn.Write(resp.EncodeInteger(int(conn.id)))
+
+	case "TRACKING":
+		s.handleClientTrackingCommand(conn, args[1:])
+
+	case "TRACKINGINFO":
+		s.handleClientTrackingInfoCommand(conn)
+
+	default:
+		conn.Write(err)
+	}
+}
+
+func (s *Server) handleClientCachingCommand(conn *connection, args []any) {
+	if len(args) != 1 {
+		conn.Write(resp.EncodeError("\"CLIENT CACHING\" command requires 1 argument"))
+		return
+	}
+
+	arg, _ := args[0].([]byte)
+	tracking := s.tracking.info(conn)
+
+	if !tracking.enabled || (!tracking.optIn && !tracking.optOut) {
+		conn.Write(resp.EncodeError("\"CLIENT CACHING\" command can only be called when tracking is enabled in \"OPTIN\" or \"OPTOUT\" mode"))
+		return
+	}
+
+	switch strings.ToUpper(string(arg)) {
+	case "YES":
+		if !tracking.optIn {
+			conn.Write(resp.EncodeError("\"CLIENT CACHING YES\" is only valid when tracking is enabled in \"OPTIN\" mode"))
+			return
+		}
+
+	case "NO":
+		if !tracking.optOut {
+			conn.Write(resp.EncodeError("\"CLIENT CACHING NO\" is only valid when tracking is enabled in \"OPTOUT\" mode"))
+			return
+		}
+
+	default:
+		conn.Write(resp.EncodeError("\"CLIENT CACHING\" command argument must be \"YES\" or \"NO\""))
+		return
+	}
+
+	conn.trackingCaching = true
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleClientTrackingCommand(conn *connection, args []any) {
+	if len(args) == 0 {
+		conn.Write(resp.EncodeError("\"CLIENT TRACKING\" command requires at least 1 argument"))
+		return
+	}
+
+	mode, _ := args[0].([]byte)
+	tracking, err := parseClientTracking(args[1:])
+
+	if err != nil {
+		conn.Write(resp.EncodeError(err.Error()))
+		return
+	}
+
+	switch strings.ToUpper(string(mode)) {
+	case "ON":
+		if err := s.tracking.enable(conn, tracking); err != nil {
+			conn.Write(resp.EncodeError(err.Error()))
+			return
+		}
+
+	case "OFF":
+		s.tracking.disable(conn)
+
+	default:
+		conn.Write(resp.EncodeError("\"CLIENT TRACKING\" command must be followed by \"ON\" or \"OFF\""))
+		return
+	}
+
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+// handleClientTrackingInfoCommand replies with the tracking flags, the
+// redirect client ID and the BCAST prefixes of the connection.
+func (s *Server) handleClientTrackingInfoCommand(conn *connection) {
+	tracking := s.tracking.info(conn)
+	flags := []string{"off"}
+	redirect := int64(-1)
+
+	if tracking.enabled {
+		flags = []string{"on"}
+		redirect = tracking.redirect
+
+		for _, flag := range []struct {
+			name string
+			set  bool
+		}{
+			{"bcast", tracking.bcast},
+			{"optin", tracking.optIn},
+			{"optout", tracking.optOut},
+			{"noloop", tracking.noLoop},
+			{"broken_redirect", s.tracking.redirectBroken(tracking)},
+		} {
+			if flag.set {
+				flags = append(flags, flag.name)
+			}
+		}
+	}
+
+	encodeStrings := func(values []string) []byte {
+		entries := make([][]byte, len(values))
+
+		for index, value := range values {
+			entries[index] = resp.EncodeBulkString(value)
+		}
+
+		return resp.EncodeArray(entries)
+	}
+
+	conn.Write(resp.EncodeArray([][]byte{
+		resp.EncodeBulkString("flags"),
+		encodeStrings(flags),
+		resp.EncodeBulkString("redirect"),
+		resp.EncodeInteger(int(redirect)),
+		resp.EncodeBulkString("prefixes"),
+		encodeStrings(tracking.prefixes),
+	}))
+}
+
+func (s *Server) handleConfigCommand(conn *connection, args []any) {
 	err := resp.EncodeError("\"CONFIG\" command must be followed by one of the following subcommands \"GET\", \"HELP\", \"RESETSTAT\", \"REWRITE\" or \"SET\"")
 
 	if len(args) == 0 {
@@ -84,7 +331,44 @@ func (s *Server) handleConfigCommand(conn net.Conn, args []any) {
 	}
 }
 
-func (s *Server) handleEchoCommand(conn net.Conn, args []any) {
+func (s *Server) handleDelCommand(conn *connection, args []any) {
+	if len(args) < 1 {
+		conn.Write(resp.EncodeError("\"DEL\" command requires at least 1 argument"))
+		return
+	}
+
+	deleted := 0
+
+	for _, arg := range args {
+		key, ok := arg.([]byte)
+
+		if !ok {
+			conn.Write(resp.EncodeError("\"DEL\" command arguments must be strings"))
+			return
+		}
+
+		if s.keyspace(conn).RemoveItem(string(key)) {
+			deleted += 1
+			s.propagate(conn, [][]byte{[]byte(DEL), key})
+			s.signalModifiedKey(conn, string(key))
+			s.notifyKeyspaceEvent(NOTIFY_GENERIC, "del", string(key))
+		}
+	}
+
+	conn.Write(resp.EncodeInteger(deleted))
+}
+
+func (s *Server) handleDiscardCommand(conn *connection) {
+	if conn.multi == nil {
+		conn.Write(resp.EncodeError("DISCARD without MULTI"))
+		return
+	}
+
+	conn.multi = nil
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleEchoCommand(conn *connection, args []any) {
 	if len(args) < 1 {
 		conn.Write(resp.EncodeError("\"ECHO\" command requires at least 1 argument"))
 		return
@@ -101,7 +385,80 @@ func (s *Server) handleEchoCommand(conn net.Conn, args []any) {
 	conn.Write(response)
 }
 
-func (s *Server) handleGetCommand(conn net.Conn, args []any) {
+// handleExecCommand runs the commands queued since MULTI with the shards of
+// all their keys locked, so other connections see either none or all of
+// their changes. Writes are propagated wrapped in MULTI and EXEC, so replicas
+// and the AOF apply them together too.
+func (s *Server) handleExecCommand(conn *connection) {
+	multi := conn.multi
+
+	if multi == nil {
+		conn.Write(resp.EncodeError("EXEC without MULTI"))
+		return
+	}
+
+	defer func() {
+		conn.multi = nil
+	}()
+
+	if multi.failed {
+		conn.Write(resp.EncodeErrorCode("EXECABORT", "Transaction discarded because of previous errors."))
+		return
+	}
+
+	keys := []string{}
+	var flags CommandFlag
+
+	for _, queued := range multi.commands {
+		commandKeys, _ := transactionKeys[queued.name](queued.args)
+		keys = append(keys, commandKeys...)
+		flags |= commandFlags[queued.name] & (WRITE_COMMAND | DENYOOM_COMMAND)
+	}
+
+	// The replication links and the memory may have changed since the
+	// commands were queued.
+	if flags&WRITE_COMMAND != 0 && !conn.isMaster {
+		if err := s.checkReplicaAccess(flags | STALE_COMMAND); err != nil {
+			conn.Write(err)
+			return
+		}
+
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		if err := s.checkWrite(conn, flags); err != nil {
+			conn.Write(err)
+			return
+		}
+	}
+
+	replies := &bytes.Buffer{}
+	conn.replies = replies
+
+	s.cache.Update(keys, func(tx *cache.Tx) {
+		multi.tx = tx
+
+		for _, queued := range multi.commands {
+			s.callCommand(conn, queued.command, queued.name, queued.args, false)
+		}
+
+		multi.tx = nil
+	})
+
+	conn.replies = nil
+
+	if len(multi.writes) > 0 {
+		commands := append([][][]byte{{[]byte(MULTI)}}, multi.writes...)
+		s.propagateCommands(conn, append(commands, [][]byte{[]byte(EXEC)}))
+	}
+
+	// The errors among the replies were counted as they were collected.
+	if !conn.isMaster {
+		conn.send(append(fmt.Appendf(nil, "*%d\r\n", len(multi.commands)), replies.Bytes()...))
+	}
+}
+
+func (s *Server) handleGetCommand(conn *connection, args []any) {
 	if len(args) < 1 {
 		conn.Write(resp.EncodeError("\"GET\" command requires at least 1 argument"))
 		return
@@ -114,7 +471,7 @@ func (s *Server) handleGetCommand(conn net.Conn, args []any) {
 		return
 	}
 
-	item := s.cache.GetItem(string(key))
+	item := s.keyspace(conn).GetItem(string(key))
 	var response []byte
 
 	switch v := item.(type) {
@@ -134,32 +491,24 @@ func (s *Server) handleGetCommand(conn net.Conn, args []any) {
 	conn.Write(response)
 }
 
-func (s *Server) handleInfoCommand(conn net.Conn, args []any) {
-	if len(args) < 1 {
-		// todo: handle "INFO" command without 'section' argument
-		conn.Write(resp.EncodeNull())
-		return
-	}
+func (s *Server) handleInfoCommand(conn *connection, args []any) {
+	sections := make([]string, len(args))
 
-	section, ok := args[0].([]byte)
+	for index, arg := range args {
+		section, ok := arg.([]byte)
 
-	if !ok {
-		conn.Write(resp.EncodeError("\"INFO\" command argument must be a string"))
-		return
-	}
+		if !ok {
+			conn.Write(resp.EncodeError("\"INFO\" command argument must be a string"))
+			return
+		}
 
-	if !bytes.Equal(bytes.ToLower(section), []byte("replication")) {
-		// todo: handle "INFO" section arguments beyond "replication"
-		conn.Write(resp.EncodeNull())
-		return
+		sections[index] = strings.ToLower(string(section))
 	}
 
-	response := resp.EncodeBulkString(fmt.Sprintf("role:%s\nmaster_replid:%s\nnmaster_repl_offset:%d", s.role, s.replicationId, s.replicationOffset))
-
-	conn.Write(response)
+	conn.Write(resp.EncodeBulkString(s.generateInfo(sections)))
 }
 
-func (s *Server) handleKeysCommand(conn net.Conn, args []any) {
+func (s *Server) handleKeysCommand(conn *connection, args []any) {
 	if len(args) < 1 {
 		conn.Write(resp.EncodeError("\"KEYS\" command requires at least 1 argument"))
 		return
@@ -177,161 +526,1130 @@ func (s *Server) handleKeysCommand(conn net.Conn, args []any) {
 		return
 	}
 
-	entries := make([][]byte, s.cache.Size())
-	index := 0
+	keys := s.cache.Keys()
+	entries := make([][]byte, len(keys))
 
-	for key := range s.cache.GetItems() {
+	for index, key := range keys {
 		entries[index] = resp.EncodeBulkString(key)
-		index += 1
 	}
 
 	conn.Write(resp.EncodeArray(entries))
 }
 
-func (s *Server) handlePingCommand(conn net.Conn) {
-	response := resp.EncodeSimpleString("PONG")
-
-	conn.Write(response)
-}
-
-func (s *Server) handlePsyncCommand(conn net.Conn) {
-	decodedBytes, err := hex.DecodeString(RDB_DUMP)
+// encodeHelp encodes the reply to a HELP subcommand, an array of lines.
+func encodeHelp(lines []string) []byte {
+	entries := make([][]byte, len(lines))
 
-	if err != nil {
-		conn.Write(resp.EncodeError("internal server error"))
-		return
+	for index, line := range lines {
+		entries[index] = resp.EncodeSimpleString(line)
 	}
 
-	conn.Write(resp.EncodeSimpleString(fmt.Sprintf("FULLRESYNC %s 0", s.replicationId)))
-	conn.Write(fmt.Appendf(nil, "$%d\r\n%s", len(decodedBytes), string(decodedBytes)))
+	return resp.EncodeArray(entries)
 }
 
-func (s *Server) handleReplConfCommand(conn net.Conn) {
-	response := resp.EncodeSimpleString("OK")
-
-	conn.Write(response)
+var memoryHelp = []string{
+	"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"DOCTOR",
+	"    Return memory problems reports.",
+	"MALLOC-STATS",
+	"    Return internal statistics report from the memory allocator.",
+	"STATS",
+	"    Return information about the memory usage of the server.",
+	"USAGE <key> [SAMPLES <count>]",
+	"    Return memory in bytes used by <key> and its value. Nested values are",
+	"    sampled up to <count> times (default: 5, 0 means sample all).",
+	"HELP",
+	"    Print this help.",
 }
 
-func (s *Server) handleSetCommand(conn net.Conn, args []any) {
-	argsLen := len(args)
+func (s *Server) handleMemoryCommand(conn *connection, args []any) {
+	err := resp.EncodeError("\"MEMORY\" command must be followed by one of the following subcommands \"DOCTOR\", \"HELP\", \"MALLOC-STATS\", \"STATS\" or \"USAGE\"")
 
-	if argsLen < 2 {
-		conn.Write(resp.EncodeError("\"SET\" command requires at least 2 arguments"))
+	if len(args) == 0 {
+		conn.Write(err)
 		return
 	}
 
-	rawKey, ok := args[0].([]byte)
+	subcommand, ok := args[0].([]byte)
 
 	if !ok {
-		conn.Write(resp.EncodeError("\"SET\" command argument must be a string"))
+		conn.Write(err)
 		return
 	}
 
-	value := args[1]
-	key := string(rawKey)
+	switch strings.ToUpper(string(subcommand)) {
+	case "DOCTOR":
+		conn.Write(resp.EncodeBulkString(s.memoryDoctor()))
 
-	expiry := time.Time{}
+	case "HELP":
+		conn.Write(encodeHelp(memoryHelp))
 
-	if argsLen < 3 {
-		s.cache.SetItem(key, value, expiry)
-		conn.Write(resp.EncodeSimpleString("OK"))
+	case "MALLOC-STATS":
+		conn.Write(resp.EncodeBulkString(s.mallocStats()))
+
+	case "STATS":
+		conn.Write(s.memoryStats())
+
+	case "USAGE":
+		s.handleMemoryUsageCommand(conn, args[1:])
+
+	default:
+		conn.Write(err)
+	}
+}
+
+func (s *Server) handleMemoryUsageCommand(conn *connection, args []any) {
+	if len(args) != 1 && len(args) != 3 {
+		conn.Write(resp.EncodeError("\"MEMORY USAGE\" command requires a key, optionally followed by \"SAMPLES\" and a count"))
 		return
 	}
 
-	switch v := args[2].(type) {
-	case []byte:
-		if !bytes.Equal(bytes.ToUpper(v), []byte("PX")) {
-			s.cache.SetItem(key, value, expiry)
-			conn.Write(resp.EncodeSimpleString("OK"))
+	key, ok := args[0].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError("\"MEMORY USAGE\" command key must be a string"))
+		return
+	}
+
+	// Like Redis, only 5 elements of aggregate values are measured by default.
+	samples := 5
+
+	if len(args) == 3 {
+		option, isString := args[1].([]byte)
+		count, isCount := args[2].([]byte)
+
+		if !isString || !isCount || !strings.EqualFold(string(option), "SAMPLES") {
+			conn.Write(resp.EncodeError("syntax error"))
 			return
 		}
 
-		if argsLen < 4 {
-			conn.Write(resp.EncodeError("\"SET\" command with \"PX\" option requires an expiry value"))
+		n, err := strconv.Atoi(string(count))
+
+		if err != nil || n < 0 {
+			conn.Write(resp.EncodeError("value is not an integer or out of range"))
 			return
 		}
 
-		var duration time.Duration
+		samples = n
+	}
+
+	info, ok := s.keyspace(conn).Inspect(string(key))
 
-		switch px := args[3].(type) {
-		case int:
-			duration = time.Duration(px) * time.Millisecond
+	if !ok {
+		conn.Write(resp.EncodeNull())
+		return
+	}
 
-		case []byte:
-			d, err := strconv.Atoi(string(px))
+	conn.Write(resp.EncodeInteger(int(memory.SampledUsage(string(key), info.Value, !info.Expiry.IsZero(), samples))))
+}
 
-			if err != nil {
-				conn.Write(resp.EncodeError("\"SET\" command \"PX\" options requires an integer expiry value"))
-				return
-			}
+func (s *Server) handleMultiCommand(conn *connection) {
+	if conn.multi != nil {
+		s.rejectCommand(conn, MULTI, resp.EncodeError("MULTI calls can not be nested"))
+		return
+	}
+
+	conn.multi = &transaction{}
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// handleMSetCommand sets every key at once: other connections see either
+// none or all of the new values.
+func (s *Server) handleMSetCommand(conn *connection, args []any) {
+	if len(args) == 0 || len(args)%2 != 0 {
+		conn.Write(resp.EncodeError("\"MSET\" command requires pairs of keys and values"))
+		return
+	}
 
-			duration = time.Duration(d) * time.Millisecond
+	argv := [][]byte{[]byte(MSET)}
+	keys := make([]string, 0, len(args)/2)
 
-		default:
-			conn.Write(resp.EncodeError("\"SET\" command \"PX\" options requires an integer expiry value"))
+	for _, arg := range args {
+		value, ok := arg.([]byte)
+
+		if !ok {
+			conn.Write(resp.EncodeError("\"MSET\" command arguments must be strings"))
 			return
 		}
 
-		expiry = time.Now().Add(duration)
+		if len(argv)%2 != 0 {
+			keys = append(keys, string(value))
+		}
+
+		argv = append(argv, value)
 	}
 
-	s.cache.SetItem(key, value, expiry)
-	response := resp.EncodeSimpleString("OK")
-	conn.Write(response)
+	s.updateKeys(conn, keys, func(tx *cache.Tx) {
+		for index := 1; index < len(argv); index += 2 {
+			tx.SetItem(string(argv[index]), argv[index+1], time.Time{})
+		}
+	})
+
+	s.propagate(conn, argv)
+
+	for _, key := range keys {
+		s.signalModifiedKey(conn, key)
+		s.notifyKeyspaceEvent(NOTIFY_STRING, "set", key)
+	}
+
+	conn.Write(resp.EncodeSimpleString("OK"))
 }
 
-func (s *Server) executeCommand(conn net.Conn, command []byte, args []any) {
-	switch string(bytes.ToUpper(command)) {
-	case CONFIG:
-		s.handleConfigCommand(conn, args)
-		return
+func (s *Server) handleObjectCommand(conn *connection, args []any) {
+	err := resp.EncodeError("\"OBJECT\" command must be followed by one of the following subcommands \"ENCODING\", \"FREQ\", \"HELP\", \"IDLETIME\" or \"REFCOUNT\"")
 
-	case ECHO:
-		s.handleEchoCommand(conn, args)
+	if len(args) == 0 {
+		conn.Write(err)
 		return
+	}
 
-	case GET:
-		s.handleGetCommand(conn, args)
-		return
+	subcommand, ok := args[0].([]byte)
 
-	case INFO:
-		s.handleInfoCommand(conn, args)
+	if !ok {
+		conn.Write(err)
 		return
+	}
 
-	case KEYS:
-		s.handleKeysCommand(conn, args)
-		return
+	name := strings.ToUpper(string(subcommand))
 
-	case PING:
-		s.handlePingCommand(conn)
+	switch name {
+	case "HELP":
+		conn.Write(encodeHelp(objectHelp))
 		return
 
-	case PSYNC:
-		s.handlePsyncCommand(conn)
-		return
+	case "ENCODING", "FREQ", "IDLETIME", "REFCOUNT":
 
-	case REPLCONF:
-		s.handleReplConfCommand(conn)
+	default:
+		conn.Write(err)
 		return
+	}
 
-	case SET:
-		s.handleSetCommand(conn, args)
+	if len(args) != 2 {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"OBJECT %s\" command requires 1 argument", name)))
 		return
+	}
 
-	default:
-		conn.Write(resp.EncodeError(fmt.Sprintf("unsupported command \"%s\"", command)))
+	key, ok := args[1].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"OBJECT %s\" command argument must be a string", name)))
 		return
 	}
-}
 
-func (s *Server) handleCommands(conn net.Conn, input any) {
-	argv, ok := input.([]any)
+	info, ok := s.keyspace(conn).Inspect(string(key))
+
+	if !ok {
+		conn.Write(resp.EncodeNull())
+		return
+	}
+
+	// The access time and frequency are both tracked, but like Redis we only
+	// expose the one the eviction policy relies on.
+	lfu := strings.HasSuffix(s.config.Get("maxmemory-policy"), "-lfu")
+
+	switch name {
+	case "ENCODING":
+		conn.Write(resp.EncodeBulkString(memory.Encoding(info.Value)))
+
+	case "FREQ":
+		if !lfu {
+			conn.Write(resp.EncodeError("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."))
+			return
+		}
+
+		conn.Write(resp.EncodeInteger(info.Freq))
+
+	case "IDLETIME":
+		if lfu {
+			conn.Write(resp.EncodeError("An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."))
+			return
+		}
+
+		conn.Write(resp.EncodeInteger(int(info.Idle.Seconds())))
+
+	case "REFCOUNT":
+		// Values are never shared between keys.
+		conn.Write(resp.EncodeInteger(1))
+	}
+}
+
+func (s *Server) handlePingCommand(conn *connection) {
+	response := resp.EncodeSimpleString("PONG")
+
+	// Subscribed connections can only tell replies from messages by their
+	// type, so the reply is an array as well.
+	if s.pubsub.count(conn) > 0 {
+		response = resp.EncodeArray([][]byte{resp.EncodeBulkString("pong"), resp.EncodeBulkString("")})
+	}
+
+	conn.Write(response)
+}
+
+func (s *Server) handlePublishCommand(conn *connection, args []any) {
+	if len(args) != 2 {
+		conn.Write(resp.EncodeError("\"PUBLISH\" command requires 2 arguments"))
+		return
+	}
+
+	channel, ok := args[0].([]byte)
+	message, messageOk := args[1].([]byte)
+
+	if !ok || !messageOk {
+		conn.Write(resp.EncodeError("\"PUBLISH\" command arguments must be strings"))
+		return
+	}
+
+	receivers := s.pubsub.publish(string(channel), string(message))
+	conn.Write(resp.EncodeInteger(receivers))
+}
+
+func (s *Server) handlePsyncCommand(conn *connection, args []any) {
+	if len(args) < 2 {
+		conn.Write(resp.EncodeError("\"PSYNC\" command requires at least 2 arguments"))
+		return
+	}
+
+	replicationId, ok := args[0].([]byte)
+	rawOffset, isString := args[1].([]byte)
+
+	if !ok || !isString {
+		conn.Write(resp.EncodeError("\"PSYNC\" command arguments must be strings"))
+		return
+	}
+
+	// The offset of the first byte the replica is missing, or -1 when it has no history.
+	psyncOffset, err := strconv.Atoi(string(rawOffset))
+
+	if err != nil {
+		conn.Write(resp.EncodeError("value is not an integer or out of range"))
+		return
+	}
+
+	// Holding the write lock ensures no write lands between the offset we
+	// report and the start of the stream buffered for the replica.
+	s.writeMu.Lock()
+	s.mu.Lock()
+
+	// A replica serves synchronizations from its own dataset and backlog,
+	// which are only consistent with its master's history once the
+	// replication stream flows.
+	if s.role != "master" && !s.masterLinkUp() {
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+
+		conn.Write(resp.EncodeErrorCode("NOMASTERLINK", "Can't SYNC while not connected with my master"))
+		return
+	}
+
+	if s.canContinue(string(replicationId), psyncOffset) {
+		s.stats.update(func(st *stats) {
+			st.syncPartialOk += 1
+		})
+
+		missing := s.backlog.tail(s.replicationOffset - psyncOffset + 1)
+		replica := s.addReplica(conn)
+		replica.online = true
+		currentId := s.replicationId
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+
+		if conn.hasReplicaCapability("psync2") {
+			conn.Write(resp.EncodeSimpleString(fmt.Sprintf("CONTINUE %s", currentId)))
+		} else {
+			conn.Write(resp.EncodeSimpleString("CONTINUE"))
+		}
+
+		// The stream follows the reply.
+		conn.flush()
+		conn.writeReplicationData(missing)
+
+		go replica.writeStream()
+		return
+	}
+
+	s.stats.update(func(st *stats) {
+		st.syncFull += 1
+
+		// The replica asked to continue from where it left off.
+		if string(replicationId) != "?" {
+			st.syncPartialErr += 1
+		}
+	})
+
+	// A backlog created from scratch starts a new history.
+	if s.backlog == nil {
+		s.replicationId = generateReplicationId()
+		s.clearReplicationId2()
+		s.createBacklog()
+	}
+
+	// Only replicas able to parse the EOF-marker format can receive a
+	// payload of unknown size.
+	diskless := s.config.Get("repl-diskless-sync") == "yes" && conn.hasReplicaCapability("eof")
+	// Writes propagated until the snapshot is transferred are buffered by the replica.
+	snap, target := s.joinSnapshot(s.addReplica(conn), diskless)
+	currentId := s.replicationId
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+
+	conn.Write(resp.EncodeSimpleString(fmt.Sprintf("FULLRESYNC %s %d", currentId, snap.offset)))
+	conn.flush()
+	close(target.readyC)
+}
+
+// handleRenameCommand moves the value of a key and its expiry to another
+// key, which no connection can see missing or holding both values.
+func (s *Server) handleRenameCommand(conn *connection, args []any) {
+	if len(args) != 2 {
+		conn.Write(resp.EncodeError("\"RENAME\" command requires 2 arguments"))
+		return
+	}
+
+	key, ok := args[0].([]byte)
+	newKey, newOk := args[1].([]byte)
+
+	if !ok || !newOk {
+		conn.Write(resp.EncodeError("\"RENAME\" command arguments must be strings"))
+		return
+	}
+
+	found := false
+
+	s.updateKeys(conn, []string{string(key), string(newKey)}, func(tx *cache.Tx) {
+		value, expiry, ok := tx.Get(string(key))
+
+		if !ok {
+			return
+		}
+
+		found = true
+		tx.RemoveItem(string(key))
+		tx.SetItem(string(newKey), value, expiry)
+	})
+
+	if !found {
+		conn.Write(resp.EncodeError("no such key"))
+		return
+	}
+
+	s.propagate(conn, [][]byte{[]byte(RENAME), key, newKey})
+	s.signalModifiedKey(conn, string(key))
+	s.signalModifiedKey(conn, string(newKey))
+	s.notifyKeyspaceEvent(NOTIFY_GENERIC, "rename_from", string(key))
+	s.notifyKeyspaceEvent(NOTIFY_GENERIC, "rename_to", string(newKey))
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleReplConfCommand(conn *connection, args []any) {
+	if len(args) < 2 {
+		conn.Write(resp.EncodeError("\"REPLCONF\" command requires at least 2 arguments"))
+		return
+	}
+
+	option, ok := args[0].([]byte)
+	value, isString := args[1].([]byte)
+
+	if !ok || !isString {
+		conn.Write(resp.EncodeError("\"REPLCONF\" command arguments must be strings"))
+		return
+	}
+
+	switch strings.ToLower(string(option)) {
+	case "ack":
+		// Replicas acknowledge the processed offset without expecting a reply.
+		offset, err := strconv.Atoi(string(value))
+
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.acknowledge(conn, offset)
+		s.mu.Unlock()
+
+		return
+
+	case "getack":
+		// Only our master may ask for an acknowledgement, which is sent
+		// instead of a reply.
+		if conn.isMaster {
+			s.sendAck()
+		}
+
+		return
+
+	case "listening-port":
+		port, err := strconv.Atoi(string(value))
+
+		if err != nil {
+			conn.Write(resp.EncodeError("value is not an integer or out of range"))
+			return
+		}
+
+		conn.replicaListeningPort = port
+
+	case "capa":
+		// Capabilities are announced in "capa <name>" pairs.
+		for index := 0; index+1 < len(args); index += 2 {
+			if capability, ok := args[index+1].([]byte); ok {
+				conn.replicaCapabilities = append(conn.replicaCapabilities, strings.ToLower(string(capability)))
+			}
+		}
+	}
+
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleReplicaOfCommand(conn *connection, command string, args []any) {
+	if len(args) < 2 {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"%s\" command requires 2 arguments", command)))
+		return
+	}
+
+	host, ok := args[0].([]byte)
+	port, isString := args[1].([]byte)
+
+	if !ok || !isString {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"%s\" command arguments must be strings", command)))
+		return
+	}
+
+	if strings.EqualFold(string(host), "no") && strings.EqualFold(string(port), "one") {
+		// Holding the write lock keeps writes from reaching the replication
+		// stream while the role changes.
+		s.writeMu.Lock()
+		s.mu.Lock()
+
+		if s.role != "master" {
+			s.unsetMaster()
+		}
+
+		s.mu.Unlock()
+		s.writeMu.Unlock()
+
+		conn.Write(resp.EncodeSimpleString("OK"))
+		return
+	}
+
+	portNumber, err := strconv.Atoi(string(port))
+
+	if err != nil || portNumber < 0 || portNumber > 65535 {
+		conn.Write(resp.EncodeError("Invalid master port"))
+		return
+	}
+
+	if portNumber == s.port && utils.IsLocalAddress(string(host)) {
+		conn.Write(resp.EncodeError("the server cannot be a replica of itself"))
+		return
+	}
+
+	masterPort := strconv.Itoa(portNumber)
+
+	s.writeMu.Lock()
+	s.mu.Lock()
+	connected := s.role != "master" && s.masterHost == string(host) && s.masterPort == masterPort
+
+	if !connected {
+		s.setMaster(string(host), masterPort)
+	}
+
+	s.mu.Unlock()
+	s.writeMu.Unlock()
+
+	if connected {
+		conn.Write(resp.EncodeSimpleString("OK Already connected to specified master"))
+		return
+	}
+
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleSelectCommand(conn *connection, args []any) {
+	if len(args) < 1 {
+		conn.Write(resp.EncodeError("\"SELECT\" command requires at least 1 argument"))
+		return
+	}
+
+	index, ok := args[0].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError("\"SELECT\" command argument must be a string"))
+		return
+	}
+
+	db, err := strconv.Atoi(string(index))
+
+	if err != nil {
+		conn.Write(resp.EncodeError("value is not an integer or out of range"))
+		return
+	}
+
+	// todo: support multiple logical databases
+	if db != 0 {
+		conn.Write(resp.EncodeError("DB index is out of range"))
+		return
+	}
+
+	conn.db = db
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+func (s *Server) handleSetCommand(conn *connection, args []any) {
+	argsLen := len(args)
+
+	if argsLen < 2 {
+		conn.Write(resp.EncodeError("\"SET\" command requires at least 2 arguments"))
+		return
+	}
+
+	key, ok := args[0].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError("\"SET\" command argument must be a string"))
+		return
+	}
+
+	value, ok := args[1].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError("\"SET\" command argument must be a string"))
+		return
+	}
+
+	expiry := time.Time{}
+
+	if argsLen < 3 {
+		s.setItem(conn, key, value, expiry)
+		return
+	}
+
+	option, ok := args[2].([]byte)
+	option = bytes.ToUpper(option)
+
+	if !ok || !slices.Contains([]string{"EX", "PX", "EXAT", "PXAT"}, string(option)) {
+		s.setItem(conn, key, value, expiry)
+		return
+	}
+
+	if argsLen < 4 {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"SET\" command with \"%s\" option requires an expiry value", option)))
+		return
+	}
+
+	var amount int
+
+	switch v := args[3].(type) {
+	case int:
+		amount = v
+
+	case []byte:
+		d, err := strconv.Atoi(string(v))
+
+		if err != nil {
+			conn.Write(resp.EncodeError(fmt.Sprintf("\"SET\" command \"%s\" options requires an integer expiry value", option)))
+			return
+		}
+
+		amount = d
+
+	default:
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"SET\" command \"%s\" options requires an integer expiry value", option)))
+		return
+	}
+
+	switch string(option) {
+	case "EX":
+		expiry = time.Now().Add(time.Duration(amount) * time.Second)
+
+	case "PX":
+		expiry = time.Now().Add(time.Duration(amount) * time.Millisecond)
+
+	case "EXAT":
+		expiry = time.Unix(int64(amount), 0)
+
+	case "PXAT":
+		expiry = time.UnixMilli(int64(amount))
+	}
+
+	s.setItem(conn, key, value, expiry)
+}
+
+// setItem stores a string value, propagates the write and replies to "conn".
+// Relative expiry times are propagated as an absolute Unix time in
+// milliseconds so replicas expire the key at the same moment as we do.
+func (s *Server) setItem(conn *connection, key []byte, value []byte, expiry time.Time) {
+	s.keyspace(conn).SetItem(string(key), value, expiry)
+
+	argv := [][]byte{[]byte(SET), key, value}
+
+	if !expiry.IsZero() {
+		argv = append(argv, []byte("PXAT"), strconv.AppendInt(nil, expiry.UnixMilli(), 10))
+	}
+
+	s.propagate(conn, argv)
+	s.signalModifiedKey(conn, string(key))
+	s.notifyKeyspaceEvent(NOTIFY_STRING, "set", string(key))
+
+	if !expiry.IsZero() {
+		s.notifyKeyspaceEvent(NOTIFY_GENERIC, "expire", string(key))
+	}
+
+	conn.Write(resp.EncodeSimpleString("OK"))
+}
+
+// handleSubscribeCommand handles SUBSCRIBE and PSUBSCRIBE, confirming every
+// subscription with the number of subscriptions of the connection.
+func (s *Server) handleSubscribeCommand(conn *connection, command string, args []any) {
+	defer s.updateOutputBufferLimit(conn)
+
+	if len(args) == 0 {
+		conn.Write(resp.EncodeError(fmt.Sprintf("\"%s\" command requires at least 1 argument", command)))
+		return
+	}
+
+	for _, arg := range args {
+		name, ok := arg.([]byte)
+
+		if !ok {
+			conn.Write(resp.EncodeError(fmt.Sprintf("\"%s\" command arguments must be strings", command)))
+			return
+		}
+
+		count := s.pubsub.subscribe(conn, string(name), command == PSUBSCRIBE)
+
+		conn.Write(resp.EncodeArray([][]byte{
+			resp.EncodeBulkString(strings.ToLower(command)),
+			resp.EncodeBulkString(string(name)),
+			resp.EncodeInteger(count),
+		}))
+	}
+}
+
+// handleUnsubscribeCommand handles UNSUBSCRIBE and PUNSUBSCRIBE, which
+// remove every subscription of their kind when given no argument.
+func (s *Server) handleUnsubscribeCommand(conn *connection, command string, args []any) {
+	defer s.updateOutputBufferLimit(conn)
+
+	pattern := command == PUNSUBSCRIBE
+	names := s.pubsub.subscriptions(conn, pattern)
+
+	if len(args) > 0 {
+		names = names[:0]
+
+		for _, arg := range args {
+			name, ok := arg.([]byte)
+
+			if !ok {
+				conn.Write(resp.EncodeError(fmt.Sprintf("\"%s\" command arguments must be strings", command)))
+				return
+			}
+
+			names = append(names, string(name))
+		}
+	}
+
+	if len(names) == 0 {
+		conn.Write(resp.EncodeArray([][]byte{
+			resp.EncodeBulkString(strings.ToLower(command)),
+			resp.EncodeNull(),
+			resp.EncodeInteger(s.pubsub.count(conn)),
+		}))
+
+		return
+	}
+
+	for _, name := range names {
+		count := s.pubsub.unsubscribe(conn, name, pattern)
+
+		conn.Write(resp.EncodeArray([][]byte{
+			resp.EncodeBulkString(strings.ToLower(command)),
+			resp.EncodeBulkString(name),
+			resp.EncodeInteger(count),
+		}))
+	}
+}
+
+func (s *Server) handleTypeCommand(conn *connection, args []any) {
+	if len(args) != 1 {
+		conn.Write(resp.EncodeError("\"TYPE\" command requires 1 argument"))
+		return
+	}
+
+	key, ok := args[0].([]byte)
+
+	if !ok {
+		conn.Write(resp.EncodeError("\"TYPE\" command argument must be a string"))
+		return
+	}
+
+	info, _ := s.keyspace(conn).Inspect(string(key))
+
+	conn.Write(resp.EncodeSimpleString(rdb.TypeName(info.Value)))
+}
+
+func (s *Server) handleWaitCommand(conn *connection, args []any) {
+	if len(args) < 2 {
+		conn.Write(resp.EncodeError("\"WAIT\" command requires 2 arguments"))
+		return
+	}
+
+	rawNumReplicas, ok := args[0].([]byte)
+	rawTimeout, isString := args[1].([]byte)
+
+	if !ok || !isString {
+		conn.Write(resp.EncodeError("\"WAIT\" command arguments must be strings"))
+		return
+	}
+
+	numReplicas, err := strconv.Atoi(string(rawNumReplicas))
+
+	if err != nil {
+		conn.Write(resp.EncodeError("value is not an integer or out of range"))
+		return
+	}
+
+	// The timeout is in milliseconds, zero meaning to wait forever.
+	timeout, err := strconv.Atoi(string(rawTimeout))
+
+	if err != nil {
+		conn.Write(resp.EncodeError("timeout is not an integer or out of range"))
+		return
+	}
+
+	if timeout < 0 {
+		conn.Write(resp.EncodeError("timeout is negative"))
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.role != "master" {
+		s.mu.Unlock()
+		conn.Write(resp.EncodeError("WAIT cannot be used with replica instances"))
+		return
+	}
+
+	acked := s.countAckedReplicas(conn.writeOffset)
+
+	if acked >= numReplicas {
+		s.mu.Unlock()
+		conn.Write(resp.EncodeInteger(acked))
+		return
+	}
+
+	waiter := s.addAckWaiter(conn.writeOffset, numReplicas)
+	s.mu.Unlock()
+
+	var timeoutC <-chan time.Time
+
+	if timeout > 0 {
+		timer := time.NewTimer(time.Duration(timeout) * time.Millisecond)
+		defer timer.Stop()
+
+		timeoutC = timer.C
+	}
+
+	select {
+	case <-waiter.doneC:
+	case <-timeoutC:
+	case <-s.stoppedC:
+	}
+
+	s.mu.Lock()
+	s.removeAckWaiter(waiter)
+	acked = s.countAckedReplicas(conn.writeOffset)
+	s.mu.Unlock()
+
+	conn.Write(resp.EncodeInteger(acked))
+}
+
+// checkReplicaAccess returns the error sent to clients running a command
+// with "flags" that the server refuses given the state of its replication
+// links, or nil when the command may run.
+func (s *Server) checkReplicaAccess(flags CommandFlag) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.role == "master" {
+		// Refuse writes that would be lost if the master is partitioned away
+		// from its replicas and one of them gets promoted.
+		minReplicas := s.config.GetInt("min-replicas-to-write")
+
+		if flags&WRITE_COMMAND != 0 && minReplicas > 0 && s.countGoodReplicas() < minReplicas {
+			return resp.EncodeErrorCode("NOREPLICAS", "Not enough good replicas to write.")
+		}
+
+		return nil
+	}
+
+	// Only the master link may modify the dataset of a read-only replica.
+	if flags&WRITE_COMMAND != 0 && s.config.Get("replica-read-only") == "yes" {
+		return resp.EncodeErrorCode("READONLY", "You can't write against a read only replica.")
+	}
+
+	if flags&STALE_COMMAND == 0 && !s.masterLinkUp() && s.config.Get("replica-serve-stale-data") == "no" {
+		return resp.EncodeErrorCode("MASTERDOWN", "Link with MASTER is down and replica-serve-stale-data is set to 'no'.")
+	}
+
+	return nil
+}
+
+// rejectCommand replies with the reason a command was refused, which also
+// fails the transaction of the connection.
+func (s *Server) rejectCommand(conn *connection, name string, reply []byte) {
+	if conn.multi != nil {
+		conn.multi.failed = true
+	}
+
+	// A refused EXEC still ends the transaction.
+	if name == EXEC {
+		conn.multi = nil
+	}
+
+	s.stats.rejectCall(name)
+	conn.Write(reply)
+}
+
+func (s *Server) executeCommand(conn *connection, command []byte, args []any) {
+	name := string(bytes.ToUpper(command))
+	flags := commandFlags[name]
+
+	// CLIENT CACHING only applies to the command following it.
+	caching := conn.trackingCaching
+	conn.trackingCaching = false
+
+	if !slices.Contains(subscribedModeCommands, name) && s.pubsub.count(conn) > 0 {
+		s.rejectCommand(conn, name, resp.EncodeError(fmt.Sprintf("\"%s\" command is not allowed in subscribed mode, only \"PING\", \"PSUBSCRIBE\", \"PUNSUBSCRIBE\", \"SUBSCRIBE\" and \"UNSUBSCRIBE\" are", name)))
+		return
+	}
+
+	// Clients would otherwise see a partially loaded dataset.
+	if flags&LOADING_COMMAND == 0 && !conn.isMaster && s.loading.Load() {
+		s.rejectCommand(conn, name, resp.EncodeErrorCode("LOADING", "Redis is loading the dataset in memory"))
+		return
+	}
+
+	if !conn.isMaster {
+		if err := s.checkReplicaAccess(flags); err != nil {
+			s.rejectCommand(conn, name, err)
+			return
+		}
+	}
+
+	// Commands of a transaction are run by EXEC, once it locked their keys.
+	if conn.multi != nil && name != MULTI && name != EXEC && name != DISCARD {
+		s.queueCommand(conn, command, name, args)
+		return
+	}
+
+	// Write commands are executed one at a time, so they reach the replication
+	// stream in the order they were applied to the dataset. Commands received
+	// from our master already run under the lock, see handleMasterLink.
+	if flags&WRITE_COMMAND != 0 && !conn.isMaster {
+		s.writeMu.Lock()
+		defer s.writeMu.Unlock()
+
+		if err := s.checkWrite(conn, flags); err != nil {
+			s.rejectCommand(conn, name, err)
+			return
+		}
+	}
+
+	s.callCommand(conn, command, name, args, caching)
+}
+
+// checkWrite returns the error sent to clients running a write command with
+// "flags" that cannot be applied, or nil when it may run. It evicts keys
+// when the dataset exceeds "maxmemory", and must be called with s.writeMu
+// held.
+func (s *Server) checkWrite(conn *connection, flags CommandFlag) []byte {
+	// Refuse writes that could not be persisted until the AOF can be
+	// written to again.
+	if s.aof != nil {
+		if err := s.aof.writeError(); err != nil {
+			return resp.EncodeErrorCode("MISCONF", fmt.Sprintf("Errors writing to the AOF file: %v", err))
+		}
+	}
+
+	if !s.performEvictions(conn) && flags&DENYOOM_COMMAND != 0 {
+		return resp.EncodeErrorCode("OOM", "command not allowed when used memory > 'maxmemory'.")
+	}
+
+	return nil
+}
+
+// callCommand runs a command that passed the checks of executeCommand, and
+// records it.
+func (s *Server) callCommand(conn *connection, command []byte, name string, args []any, caching bool) {
+	flags := commandFlags[name]
+	start := time.Now()
+	errorReplies := conn.errorReplies
+
+	if !s.dispatchCommand(conn, command, name, args) {
+		return
+	}
+
+	failed := conn.errorReplies > errorReplies
+	s.stats.recordCall(name, time.Since(start), failed)
+
+	if flags&READ_COMMAND != 0 && !failed {
+		if key, ok := args[0].([]byte); ok {
+			s.trackReadKeys(conn, []string{string(key)}, caching)
+		}
+	}
+
+	if flags&WRITE_COMMAND != 0 && !failed {
+		s.stats.update(func(st *stats) {
+			st.dirty += 1
+		})
+	}
+}
+
+// dispatchCommand runs the handler of a command, reporting whether the
+// command exists.
+func (s *Server) dispatchCommand(conn *connection, command []byte, name string, args []any) bool {
+	switch name {
+	case BGREWRITEAOF:
+		s.handleBgRewriteAofCommand(conn)
+		return true
+
+	case CLIENT:
+		s.handleClientCommand(conn, args)
+		return true
+
+	case CONFIG:
+		s.handleConfigCommand(conn, args)
+		return true
+
+	case DEL:
+		s.handleDelCommand(conn, args)
+		return true
+
+	case DISCARD:
+		s.handleDiscardCommand(conn)
+		return true
+
+	case ECHO:
+		s.handleEchoCommand(conn, args)
+		return true
+
+	case EXEC:
+		s.handleExecCommand(conn)
+		return true
+
+	case GET:
+		s.handleGetCommand(conn, args)
+		return true
+
+	case INFO:
+		s.handleInfoCommand(conn, args)
+		return true
+
+	case KEYS:
+		s.handleKeysCommand(conn, args)
+		return true
+
+	case MEMORY:
+		s.handleMemoryCommand(conn, args)
+		return true
+
+	case MSET:
+		s.handleMSetCommand(conn, args)
+		return true
+
+	case MULTI:
+		s.handleMultiCommand(conn)
+		return true
+
+	case OBJECT:
+		s.handleObjectCommand(conn, args)
+		return true
+
+	case PING:
+		s.handlePingCommand(conn)
+		return true
+
+	case PSUBSCRIBE, SUBSCRIBE:
+		s.handleSubscribeCommand(conn, name, args)
+		return true
+
+	case PSYNC:
+		s.handlePsyncCommand(conn, args)
+		return true
+
+	case PUBLISH:
+		s.handlePublishCommand(conn, args)
+		return true
+
+	case PUNSUBSCRIBE, UNSUBSCRIBE:
+		s.handleUnsubscribeCommand(conn, name, args)
+		return true
+
+	case RENAME:
+		s.handleRenameCommand(conn, args)
+		return true
+
+	case REPLCONF:
+		s.handleReplConfCommand(conn, args)
+		return true
+
+	case REPLICAOF, SLAVEOF:
+		s.handleReplicaOfCommand(conn, name, args)
+		return true
+
+	case SELECT:
+		s.handleSelectCommand(conn, args)
+		return true
+
+	case SET:
+		s.handleSetCommand(conn, args)
+		return true
+
+	case TYPE:
+		s.handleTypeCommand(conn, args)
+		return true
+
+	case WAIT:
+		s.handleWaitCommand(conn, args)
+		return true
+
+	default:
+		conn.Write(resp.EncodeError(fmt.Sprintf("unsupported command \"%s\"", command)))
+		return false
+	}
+}
+
+func (s *Server) handleCommands(conn *connection, input any) {
+	argv, ok := input.([]any)
 
 	if !ok {
 		conn.Write(resp.EncodeError("commands must be encoded as a list of bulk strings"))
 		return
 	}
 
+	// Like Redis, ignore empty commands.
+	if len(argv) == 0 {
+		return
+	}
+
 	command, ok := argv[0].([]byte)
 
 	if !ok {
@@ -1,10 +1,49 @@
 package server
 
+import (
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
 type Config struct {
 	entries map[string]string
 }
 
+// Values used for options that were not provided on startup.
+var defaultConfig = map[string]string{
+	"aof-load-truncated":          "yes",
+	"aof-use-rdb-preamble":        "yes",
+	"auto-aof-rewrite-min-size":   "64mb",
+	"auto-aof-rewrite-percentage": "100",
+	"appenddirname":               "appendonlydir",
+	"appendfilename":              "appendonly.aof",
+	"appendfsync":                 "everysec",
+	"appendonly":                  "no",
+	"client-output-buffer-limit":  "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+	"maxmemory":                   "0",
+	"maxmemory-policy":            "noeviction",
+	"maxmemory-samples":           "5",
+	"min-replicas-max-lag":        "10",
+	"min-replicas-to-write":       "0",
+	"proto-max-bulk-len":          "512mb",
+	"repl-backlog-size":           "1mb",
+	"repl-backlog-ttl":            "3600",
+	"repl-diskless-sync":          "no",
+	"repl-diskless-sync-delay":    "5",
+	"replica-read-only":           "yes",
+	"replica-serve-stale-data":    "yes",
+	"sanitize-dump-payload":       "no",
+	"tracking-table-max-keys":     "1000000",
+}
+
 func NewConfig(entries map[string]string) *Config {
+	for key, value := range defaultConfig {
+		if entries[key] == "" {
+			entries[key] = value
+		}
+	}
+
 	return &Config{
 		entries: entries,
 	}
@@ -20,6 +59,30 @@ func (c *Config) Get(key string) string {
 	return ""
 }
 
+// GetInt returns the value of a numeric option, falling back to its default
+// value when the option is not a valid integer.
+func (c *Config) GetInt(key string) int {
+	if value, err := strconv.Atoi(c.Get(key)); err == nil {
+		return value
+	}
+
+	value, _ := strconv.Atoi(defaultConfig[key])
+
+	return value
+}
+
+// GetBytes returns the value of a memory size option such as "1mb" in bytes,
+// falling back to its default value when the option is not a valid size.
+func (c *Config) GetBytes(key string) int64 {
+	if value, err := utils.ParseMemorySize(c.Get(key)); err == nil {
+		return value
+	}
+
+	value, _ := utils.ParseMemorySize(defaultConfig[key])
+
+	return value
+}
+
 func (c *Config) Set(key, value string) {
 	c.entries[key] = value
 }
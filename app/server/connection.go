@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+)
+
+// connection wraps a network connection along with the state the server
+// keeps about it.
+type connection struct {
+	net.Conn
+	// The channels and patterns the connection is subscribed to, guarded
+	// by the mutex of the server's pubSub.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	// id identifies the client, e.g. in CLIENT TRACKING REDIRECT.
+	id int64
+	// tracking holds the CLIENT TRACKING options, guarded by the mutex of
+	// the server's trackingTable.
+	tracking clientTracking
+	// trackingCaching is set by CLIENT CACHING for the next command.
+	trackingCaching bool
+	// db is the index of the logical database selected by the connection.
+	db int
+	// errorReplies is the number of error replies sent on the connection.
+	errorReplies int
+	// isMaster is set on the link a replica receives the replication stream
+	// on, and on the pseudo connection the AOF is replayed from. Commands
+	// received on it are applied without sending replies back.
+	isMaster bool
+	// The port and capabilities announced by replicas through REPLCONF.
+	replicaListeningPort int
+	replicaCapabilities  []string
+	stats                *stats
+	// writeOffset is the replication offset right after the last write
+	// command the connection propagated, which is what WAIT waits for.
+	writeOffset int
+	// multi holds the transaction started by MULTI, if any.
+	multi *transaction
+	// replies collects the replies of the commands run by EXEC, which are
+	// sent once the keys of the transaction are unlocked.
+	replies *bytes.Buffer
+	// output holds the data waiting to be written to the client by
+	// writeOutput, so neither replies nor messages published by other
+	// connections wait on the network.
+	output *outputBuffer
+}
+
+func newConnection(conn net.Conn, stats *stats, limit outputBufferLimit) *connection {
+	return &connection{
+		Conn:     conn,
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+		stats:    stats,
+		output:   newOutputBuffer(limit),
+	}
+}
+
+func (c *connection) hasReplicaCapability(capability string) bool {
+	return slices.Contains(c.replicaCapabilities, capability)
+}
+
+func (c *connection) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.stats.netInputBytes.Add(int64(n))
+
+	return n, err
+}
+
+// Write sends a reply to the client, keeping track of the error replies.
+func (c *connection) Write(b []byte) (int, error) {
+	if c.isMaster {
+		return len(b), nil
+	}
+
+	if len(b) > 0 && b[0] == '-' {
+		code, _, _ := bytes.Cut(b[1:], []byte(" "))
+
+		c.errorReplies += 1
+		c.stats.recordError(string(bytes.TrimRight(code, "\r\n")))
+	}
+
+	if c.replies != nil {
+		return c.replies.Write(b)
+	}
+
+	c.send(b)
+
+	return len(b), nil
+}
+
+// send queues data for the client, dropping the connection when it takes
+// the output buffer over its limits. Unlike Write, it may be called by other
+// connections, e.g. to publish a message, even with locks held.
+func (c *connection) send(b []byte) {
+	if !c.output.push(b) {
+		fmt.Printf("Client %s closed for overcoming of output buffer limits\n", c.RemoteAddr())
+		c.Conn.Close()
+	}
+}
+
+// writeOutput writes the queued data to the client until the output buffer
+// is closed, or finished and flushed, and then closes the connection.
+func (c *connection) writeOutput() {
+	defer c.Conn.Close()
+
+	for {
+		pending, ok := c.output.pop()
+
+		if !ok {
+			return
+		}
+
+		for _, data := range pending {
+			n, err := c.Conn.Write(data)
+			c.stats.netOutputBytes.Add(int64(n))
+
+			if err != nil {
+				c.output.close()
+				return
+			}
+
+			c.output.release(len(data))
+		}
+	}
+}
+
+// flush waits until the queued data is written, before writing to the
+// connection directly.
+func (c *connection) flush() {
+	c.output.waitFlushed()
+}
+
+// writeReplicationData sends part of the replication stream or of a
+// snapshot to a replica, which is accounted for separately from replies.
+func (c *connection) writeReplicationData(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.stats.netReplicationOutBytes.Add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+)
+
+// The values of the "maxmemory-policy" option.
+var evictionPolicies = []string{
+	cache.POLICY_NOEVICTION,
+	cache.POLICY_ALLKEYS_LRU,
+	cache.POLICY_VOLATILE_LRU,
+	cache.POLICY_ALLKEYS_LFU,
+	cache.POLICY_VOLATILE_LFU,
+	cache.POLICY_ALLKEYS_RANDOM,
+	cache.POLICY_VOLATILE_RANDOM,
+	cache.POLICY_VOLATILE_TTL,
+}
+
+func checkEvictionPolicy(config *Config) error {
+	if policy := config.Get("maxmemory-policy"); !slices.Contains(evictionPolicies, policy) {
+		return fmt.Errorf("invalid maxmemory-policy \"%s\"", policy)
+	}
+
+	return nil
+}
+
+// performEvictions evicts keys until the dataset fits in "maxmemory",
+// propagating their deletion on behalf of "conn" like any other write. It
+// reports false when the dataset still does not fit, either because the
+// policy is "noeviction" or because no key is left to evict. It must be
+// called with s.writeMu held.
+func (s *Server) performEvictions(conn *connection) bool {
+	maxMemory := s.config.GetBytes("maxmemory")
+
+	if maxMemory == 0 {
+		return true
+	}
+
+	// Replicas leave evictions to their master, which propagates them.
+	s.mu.Lock()
+	isReplica := s.role != "master"
+	s.mu.Unlock()
+
+	if isReplica {
+		return true
+	}
+
+	policy := s.config.Get("maxmemory-policy")
+	samples := s.config.GetInt("maxmemory-samples")
+
+	for s.cache.UsedMemory() > maxMemory {
+		if policy == cache.POLICY_NOEVICTION {
+			return false
+		}
+
+		key, ok := s.cache.Evict(policy, samples)
+
+		if !ok {
+			return false
+		}
+
+		s.propagate(conn, [][]byte{[]byte(DEL), []byte(key)})
+	}
+
+	return true
+}
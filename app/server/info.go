@@ -0,0 +1,417 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// infoSection is a section of the INFO output.
+type infoSection struct {
+	name  string
+	title string
+	// isDefault is set on the sections returned when INFO is called without
+	// arguments or with "default".
+	isDefault bool
+	generate  func(s *Server) []string
+}
+
+// The sections of the INFO output, in the order they are returned.
+var infoSections = []infoSection{
+	{"server", "Server", true, (*Server).infoServer},
+	{"clients", "Clients", true, (*Server).infoClients},
+	{"memory", "Memory", true, (*Server).infoMemory},
+	{"persistence", "Persistence", true, (*Server).infoPersistence},
+	{"stats", "Stats", true, (*Server).infoStats},
+	{"replication", "Replication", true, (*Server).infoReplication},
+	{"cpu", "CPU", true, (*Server).infoCPU},
+	{"commandstats", "Commandstats", false, (*Server).infoCommandStats},
+	{"errorstats", "Errorstats", true, (*Server).infoErrorStats},
+	{"latencystats", "Latencystats", false, (*Server).infoLatencyStats},
+	{"keyspace", "Keyspace", true, (*Server).infoKeyspace},
+}
+
+// generateInfo returns the INFO output made of the requested sections.
+// Besides section names, "default", "all" and "everything" select groups of
+// sections, and unknown names are ignored.
+func (s *Server) generateInfo(requested []string) string {
+	if len(requested) == 0 {
+		requested = []string{"default"}
+	}
+
+	selected := map[string]bool{}
+
+	for _, name := range requested {
+		for _, section := range infoSections {
+			switch name {
+			// Without modules, "everything" returns the same sections as "all".
+			case "all", "everything":
+				selected[section.name] = true
+
+			case "default":
+				selected[section.name] = selected[section.name] || section.isDefault
+
+			case section.name:
+				selected[section.name] = true
+			}
+		}
+	}
+
+	var builder strings.Builder
+
+	for _, section := range infoSections {
+		if !selected[section.name] {
+			continue
+		}
+
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+
+		fmt.Fprintf(&builder, "# %s\r\n", section.title)
+
+		for _, line := range section.generate(s) {
+			builder.WriteString(line)
+			builder.WriteString("\r\n")
+		}
+	}
+
+	return builder.String()
+}
+
+func (s *Server) infoServer() []string {
+	uptime := time.Since(s.startTime)
+	executable, _ := os.Executable()
+
+	return []string{
+		fmt.Sprintf("redis_version:%s", REDIS_VERSION),
+		"redis_mode:standalone",
+		fmt.Sprintf("os:%s %s", runtime.GOOS, runtime.GOARCH),
+		fmt.Sprintf("arch_bits:%d", strconv.IntSize),
+		fmt.Sprintf("go_version:%s", runtime.Version()),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("run_id:%s", s.runId),
+		fmt.Sprintf("tcp_port:%d", s.port),
+		fmt.Sprintf("server_time_usec:%d", time.Now().UnixMicro()),
+		fmt.Sprintf("uptime_in_seconds:%d", int(uptime.Seconds())),
+		fmt.Sprintf("uptime_in_days:%d", int(uptime.Hours()/24)),
+		fmt.Sprintf("hz:%d", time.Second/STATS_SAMPLE_INTERVAL),
+		fmt.Sprintf("executable:%s", executable),
+		"config_file:",
+	}
+}
+
+func (s *Server) infoClients() []string {
+	s.mu.Lock()
+	replicas := len(s.replicas)
+	blocked := len(s.ackWaiters)
+	s.mu.Unlock()
+
+	s.stats.mu.Lock()
+	connected := s.stats.connectedClients
+	s.stats.mu.Unlock()
+
+	trackingClients, _, _, _ := s.tracking.counts()
+
+	return []string{
+		// Like Redis, connections from replicas are not counted as clients.
+		fmt.Sprintf("connected_clients:%d", connected-replicas),
+		fmt.Sprintf("blocked_clients:%d", blocked),
+		fmt.Sprintf("tracking_clients:%d", trackingClients),
+	}
+}
+
+func (s *Server) infoMemory() []string {
+	memStats, peak := s.readMemStats()
+	maxMemory := s.config.GetBytes("maxmemory")
+
+	return []string{
+		fmt.Sprintf("used_memory:%d", memStats.HeapAlloc),
+		fmt.Sprintf("used_memory_human:%s", utils.FormatMemorySize(int64(memStats.HeapAlloc))),
+		fmt.Sprintf("used_memory_rss:%d", memStats.Sys),
+		fmt.Sprintf("used_memory_rss_human:%s", utils.FormatMemorySize(int64(memStats.Sys))),
+		fmt.Sprintf("used_memory_peak:%d", peak),
+		fmt.Sprintf("used_memory_peak_human:%s", utils.FormatMemorySize(int64(peak))),
+		fmt.Sprintf("used_memory_dataset:%d", s.cache.UsedMemory()),
+		fmt.Sprintf("maxmemory:%d", maxMemory),
+		fmt.Sprintf("maxmemory_human:%s", utils.FormatMemorySize(maxMemory)),
+		fmt.Sprintf("maxmemory_policy:%s", s.config.Get("maxmemory-policy")),
+		"mem_allocator:go",
+	}
+}
+
+func (s *Server) infoPersistence() []string {
+	s.mu.Lock()
+	inProgress := s.snapshotsInProgress
+	lastSave := s.lastSave
+	status := "ok"
+
+	if s.lastSnapshotErr != nil {
+		status = "err"
+	}
+
+	s.mu.Unlock()
+
+	s.stats.mu.Lock()
+	dirty := s.stats.dirty
+	s.stats.mu.Unlock()
+
+	aofEnabled, aofRewriting, aofRewriteStatus, aofWriteStatus := 0, 0, "ok", "ok"
+	var aofSizes []string
+
+	if s.aof != nil {
+		s.aof.mu.Lock()
+		aofEnabled = 1
+
+		if s.aof.rewriting {
+			aofRewriting = 1
+		}
+
+		if s.aof.lastRewriteErr != nil {
+			aofRewriteStatus = "err"
+		}
+
+		if s.aof.lastWriteErr != nil {
+			aofWriteStatus = "err"
+		}
+
+		aofSizes = []string{
+			fmt.Sprintf("aof_current_size:%d", s.aof.currentSize),
+			fmt.Sprintf("aof_base_size:%d", s.aof.baseSize),
+		}
+
+		s.aof.mu.Unlock()
+	}
+
+	loading := 0
+
+	if s.loading.Load() {
+		loading = 1
+	}
+
+	lines := []string{
+		fmt.Sprintf("loading:%d", loading),
+		fmt.Sprintf("rdb_changes_since_last_save:%d", dirty),
+		fmt.Sprintf("rdb_bgsave_in_progress:%d", min(inProgress, 1)),
+		fmt.Sprintf("rdb_last_save_time:%d", lastSave.Unix()),
+		fmt.Sprintf("rdb_last_bgsave_status:%s", status),
+		fmt.Sprintf("aof_enabled:%d", aofEnabled),
+		fmt.Sprintf("aof_rewrite_in_progress:%d", aofRewriting),
+		fmt.Sprintf("aof_last_bgrewrite_status:%s", aofRewriteStatus),
+		fmt.Sprintf("aof_last_write_status:%s", aofWriteStatus),
+	}
+
+	return append(lines, aofSizes...)
+}
+
+func (s *Server) infoStats() []string {
+	cacheStats := s.cache.Stats()
+	channels, patterns := s.pubsub.counts()
+	_, trackingKeys, trackingItems, trackingPrefixes := s.tracking.counts()
+	st := s.stats
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	return []string{
+		fmt.Sprintf("total_connections_received:%d", st.connectionsReceived),
+		fmt.Sprintf("total_commands_processed:%d", st.commandsProcessed),
+		fmt.Sprintf("instantaneous_ops_per_sec:%d", int(st.commandsMetric.rate())),
+		fmt.Sprintf("total_net_input_bytes:%d", st.netInputBytes.Load()),
+		fmt.Sprintf("total_net_output_bytes:%d", st.netOutputBytes.Load()),
+		fmt.Sprintf("total_net_repl_input_bytes:%d", st.netReplicationInBytes.Load()),
+		fmt.Sprintf("total_net_repl_output_bytes:%d", st.netReplicationOutBytes.Load()),
+		fmt.Sprintf("instantaneous_input_kbps:%.2f", st.inputMetric.rate()/1024),
+		fmt.Sprintf("instantaneous_output_kbps:%.2f", st.outputMetric.rate()/1024),
+		"rejected_connections:0",
+		fmt.Sprintf("sync_full:%d", st.syncFull),
+		fmt.Sprintf("sync_partial_ok:%d", st.syncPartialOk),
+		fmt.Sprintf("sync_partial_err:%d", st.syncPartialErr),
+		fmt.Sprintf("expired_keys:%d", cacheStats.ExpiredItems),
+		fmt.Sprintf("evicted_keys:%d", cacheStats.EvictedItems),
+		fmt.Sprintf("keyspace_hits:%d", cacheStats.Hits),
+		fmt.Sprintf("keyspace_misses:%d", cacheStats.Misses),
+		fmt.Sprintf("pubsub_channels:%d", channels),
+		fmt.Sprintf("pubsub_patterns:%d", patterns),
+		fmt.Sprintf("tracking_total_keys:%d", trackingKeys),
+		fmt.Sprintf("tracking_total_items:%d", trackingItems),
+		fmt.Sprintf("tracking_total_prefixes:%d", trackingPrefixes),
+		fmt.Sprintf("total_error_replies:%d", st.errorReplies),
+	}
+}
+
+func (s *Server) infoReplication() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := []string{fmt.Sprintf("role:%s", s.role)}
+
+	if s.role != "master" {
+		linkStatus, lastIO, syncing, readOnly := "down", -1, 0, 0
+
+		if s.masterLinkUp() {
+			linkStatus = "up"
+			lastIO = int(time.Since(s.masterLink.lastIO()).Seconds())
+		}
+
+		if s.masterLink != nil && s.masterLink.syncing {
+			syncing = 1
+		}
+
+		if s.config.Get("replica-read-only") == "yes" {
+			readOnly = 1
+		}
+
+		lines = append(lines,
+			fmt.Sprintf("master_host:%s", s.masterHost),
+			fmt.Sprintf("master_port:%s", s.masterPort),
+			fmt.Sprintf("master_link_status:%s", linkStatus),
+			fmt.Sprintf("master_last_io_seconds_ago:%d", lastIO),
+			fmt.Sprintf("master_sync_in_progress:%d", syncing),
+			fmt.Sprintf("slave_repl_offset:%d", s.replicationOffset),
+			fmt.Sprintf("slave_read_only:%d", readOnly),
+		)
+	}
+
+	lines = append(lines, fmt.Sprintf("connected_slaves:%d", len(s.replicas)))
+
+	if s.config.GetInt("min-replicas-to-write") > 0 {
+		lines = append(lines, fmt.Sprintf("min_slaves_good_slaves:%d", s.countGoodReplicas()))
+	}
+
+	replicas := make([]*replica, 0, len(s.replicas))
+
+	for _, r := range s.replicas {
+		replicas = append(replicas, r)
+	}
+
+	// List the replicas in a stable order.
+	slices.SortFunc(replicas, func(a, b *replica) int {
+		return strings.Compare(a.conn.RemoteAddr().String(), b.conn.RemoteAddr().String())
+	})
+
+	for index, r := range replicas {
+		ip, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+		state, lag := "wait_bgsave", 0
+
+		if r.online {
+			state = "online"
+		}
+
+		if !r.ackTime.IsZero() {
+			lag = int(time.Since(r.ackTime).Seconds())
+		}
+
+		lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d", index, ip, r.conn.replicaListeningPort, state, r.ackOffset, lag))
+	}
+
+	backlogActive, backlogSize, backlogFirstByte, backlogLength := 0, 0, 0, 0
+
+	if s.backlog != nil {
+		backlogActive = 1
+		backlogSize = len(s.backlog.buf)
+		backlogLength = s.backlog.length
+		backlogFirstByte = s.replicationOffset - backlogLength + 1
+	}
+
+	return append(lines,
+		fmt.Sprintf("master_replid:%s", s.replicationId),
+		fmt.Sprintf("master_replid2:%s", s.replicationId2),
+		fmt.Sprintf("master_repl_offset:%d", s.replicationOffset),
+		fmt.Sprintf("second_repl_offset:%d", s.secondReplicationOffset),
+		fmt.Sprintf("repl_backlog_active:%d", backlogActive),
+		fmt.Sprintf("repl_backlog_size:%d", backlogSize),
+		fmt.Sprintf("repl_backlog_first_byte_offset:%d", backlogFirstByte),
+		fmt.Sprintf("repl_backlog_histlen:%d", backlogLength),
+	)
+}
+
+func (s *Server) infoCPU() []string {
+	var usage syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &usage)
+
+	return []string{
+		fmt.Sprintf("used_cpu_sys:%.6f", float64(usage.Stime.Nano())/1e9),
+		fmt.Sprintf("used_cpu_user:%.6f", float64(usage.Utime.Nano())/1e9),
+	}
+}
+
+func (s *Server) infoCommandStats() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+
+	for _, name := range slices.Sorted(maps.Keys(s.stats.commands)) {
+		cs := s.stats.commands[name]
+		usecPerCall := 0.0
+
+		if cs.calls > 0 {
+			usecPerCall = float64(cs.duration.Microseconds()) / float64(cs.calls)
+		}
+
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d", strings.ToLower(name), cs.calls, cs.duration.Microseconds(), usecPerCall, cs.rejectedCalls, cs.failedCalls))
+	}
+
+	return lines
+}
+
+func (s *Server) infoErrorStats() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+
+	for _, code := range slices.Sorted(maps.Keys(s.stats.errors)) {
+		lines = append(lines, fmt.Sprintf("errorstat_%s:count=%d", code, s.stats.errors[code]))
+	}
+
+	return lines
+}
+
+// infoLatencyStats reports latency percentiles computed over the most
+// recent calls of every command.
+func (s *Server) infoLatencyStats() []string {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	lines := []string{}
+
+	for _, name := range slices.Sorted(maps.Keys(s.stats.commands)) {
+		cs := s.stats.commands[name]
+
+		if len(cs.latencies) == 0 {
+			continue
+		}
+
+		usec := func(p float64) float64 {
+			return float64(cs.percentile(p).Nanoseconds()) / 1e3
+		}
+
+		lines = append(lines, fmt.Sprintf("latency_percentiles_usec_%s:p50=%.3f,p99=%.3f,p99.9=%.3f", strings.ToLower(name), usec(50), usec(99), usec(99.9)))
+	}
+
+	return lines
+}
+
+func (s *Server) infoKeyspace() []string {
+	cacheStats := s.cache.Stats()
+
+	if cacheStats.Items == 0 {
+		return nil
+	}
+
+	// todo: support multiple logical databases
+	return []string{
+		fmt.Sprintf("db0:keys=%d,expires=%d,avg_ttl=%d", cacheStats.Items, cacheStats.ExpiringItems, cacheStats.AvgTTL.Milliseconds()),
+	}
+}
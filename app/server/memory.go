@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+const (
+	// Below this much allocated memory, MEMORY DOCTOR has nothing to say.
+	DOCTOR_MIN_MEMORY = 5 * 1024 * 1024
+)
+
+// readMemStats reads the statistics of the Go runtime, which back the memory
+// figures we report, and returns them with the peak allocated memory.
+func (s *Server) readMemStats() (runtime.MemStats, uint64) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	s.stats.memoryPeak = max(s.stats.memoryPeak, memStats.HeapAlloc)
+
+	return memStats, s.stats.memoryPeak
+}
+
+// memoryStats returns the reply to MEMORY STATS: pairs of metric names and
+// values.
+func (s *Server) memoryStats() []byte {
+	memStats, peak := s.readMemStats()
+	cacheStats := s.cache.Stats()
+
+	s.mu.Lock()
+	backlogSize := 0
+
+	if s.backlog != nil {
+		backlogSize = len(s.backlog.buf)
+	}
+
+	s.mu.Unlock()
+
+	bytesPerKey := int64(0)
+
+	if cacheStats.Items > 0 {
+		bytesPerKey = cacheStats.UsedMemory / int64(cacheStats.Items)
+	}
+
+	percentage := func(part, total float64) []byte {
+		if total == 0 {
+			return resp.EncodeBulkString("0")
+		}
+
+		return resp.EncodeBulkString(strconv.FormatFloat(part/total*100, 'f', -1, 64))
+	}
+
+	stats := []struct {
+		name  string
+		value []byte
+	}{
+		{"peak.allocated", resp.EncodeInteger(int(peak))},
+		{"total.allocated", resp.EncodeInteger(int(memStats.HeapAlloc))},
+		{"replication.backlog", resp.EncodeInteger(backlogSize)},
+		{"keys.count", resp.EncodeInteger(cacheStats.Items)},
+		{"keys.bytes-per-key", resp.EncodeInteger(int(bytesPerKey))},
+		{"dataset.bytes", resp.EncodeInteger(int(cacheStats.UsedMemory))},
+		{"dataset.percentage", percentage(float64(cacheStats.UsedMemory), float64(memStats.HeapAlloc))},
+		{"peak.percentage", percentage(float64(memStats.HeapAlloc), float64(peak))},
+		{"allocator.allocated", resp.EncodeInteger(int(memStats.HeapAlloc))},
+		{"allocator.active", resp.EncodeInteger(int(memStats.HeapInuse))},
+		{"allocator.resident", resp.EncodeInteger(int(memStats.HeapSys))},
+		{"fragmentation", resp.EncodeBulkString(strconv.FormatFloat(float64(memStats.Sys)/float64(max(memStats.HeapAlloc, 1)), 'f', 2, 64))},
+		{"fragmentation.bytes", resp.EncodeInteger(int(memStats.Sys) - int(memStats.HeapAlloc))},
+	}
+
+	entries := make([][]byte, 0, 2*len(stats))
+
+	for _, stat := range stats {
+		entries = append(entries, resp.EncodeBulkString(stat.name), stat.value)
+	}
+
+	return resp.EncodeArray(entries)
+}
+
+// memoryDoctor returns the reply to MEMORY DOCTOR: a report of the memory
+// issues found, in the words of Redis.
+func (s *Server) memoryDoctor() string {
+	memStats, peak := s.readMemStats()
+
+	if memStats.HeapAlloc < DOCTOR_MIN_MEMORY {
+		return "Hi Sam, this instance is empty or is using very little memory, my issues detector can't be used in these conditions. Please, leave for your mission on Earth and fill it with some data. The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+
+	if float64(peak) > float64(memStats.HeapAlloc)*1.5 {
+		issues = append(issues, "Peak memory: In the past this instance used more than 150% the memory that is currently using. The Go runtime returns memory to the operating system gradually, so the process may keep a large resident size for a while after a peak.")
+	}
+
+	if float64(memStats.Sys) > float64(memStats.HeapAlloc)*1.4 {
+		issues = append(issues, fmt.Sprintf("High total RSS: This instance has a memory fragmentation and RSS overhead greater than 1.4 (the Go runtime reserved %s for %s allocated). This is usually due to a peak or to garbage not collected yet.", utils.FormatMemorySize(int64(memStats.Sys)), utils.FormatMemorySize(int64(memStats.HeapAlloc))))
+	}
+
+	if maxMemory := s.config.GetBytes("maxmemory"); maxMemory > 0 && float64(s.cache.UsedMemory()) > float64(maxMemory)*0.9 {
+		issues = append(issues, fmt.Sprintf("Close to maxmemory: The dataset uses more than 90%% of the configured maxmemory of %s, so keys are being evicted or, with the \"%s\" policy, write commands may soon be refused.", utils.FormatMemorySize(maxMemory), s.config.Get("maxmemory-policy")))
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. I can only account for what occurs on this base."
+	}
+
+	var report strings.Builder
+	report.WriteString("Sam, I detected a few issues in this Redis instance memory implants:\n\n")
+
+	for _, issue := range issues {
+		fmt.Fprintf(&report, " * %s\n\n", issue)
+	}
+
+	report.WriteString("I'm here to keep you safe, Sam. I want to help you.\n")
+
+	return report.String()
+}
+
+// mallocStats returns the reply to MEMORY MALLOC-STATS, the statistics of
+// the Go runtime allocator.
+func (s *Server) mallocStats() string {
+	memStats, _ := s.readMemStats()
+
+	lines := []string{
+		"Go runtime memory statistics:",
+		fmt.Sprintf("alloc:%d", memStats.Alloc),
+		fmt.Sprintf("total_alloc:%d", memStats.TotalAlloc),
+		fmt.Sprintf("sys:%d", memStats.Sys),
+		fmt.Sprintf("mallocs:%d", memStats.Mallocs),
+		fmt.Sprintf("frees:%d", memStats.Frees),
+		fmt.Sprintf("heap_alloc:%d", memStats.HeapAlloc),
+		fmt.Sprintf("heap_sys:%d", memStats.HeapSys),
+		fmt.Sprintf("heap_idle:%d", memStats.HeapIdle),
+		fmt.Sprintf("heap_inuse:%d", memStats.HeapInuse),
+		fmt.Sprintf("heap_released:%d", memStats.HeapReleased),
+		fmt.Sprintf("heap_objects:%d", memStats.HeapObjects),
+		fmt.Sprintf("stack_inuse:%d", memStats.StackInuse),
+		fmt.Sprintf("stack_sys:%d", memStats.StackSys),
+		fmt.Sprintf("gc_sys:%d", memStats.GCSys),
+		fmt.Sprintf("next_gc:%d", memStats.NextGC),
+		fmt.Sprintf("num_gc:%d", memStats.NumGC),
+		fmt.Sprintf("gc_cpu_fraction:%f", memStats.GCCPUFraction),
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// transaction holds the commands a connection queued since MULTI, which
+// EXEC runs with the shards of all their keys locked.
+type transaction struct {
+	commands []queuedCommand
+	// failed is set when a command could not be queued, which makes EXEC
+	// discard the transaction.
+	failed bool
+	// tx gives the commands access to their keys while EXEC runs them.
+	tx *cache.Tx
+	// writes holds the commands propagated while the keys are locked, which
+	// EXEC propagates once it released them.
+	writes [][][]byte
+}
+
+type queuedCommand struct {
+	command []byte
+	name    string
+	args    []any
+}
+
+// transactionKeys returns the keys a command accesses, which EXEC locks
+// before running it. Commands that are missing, or whose function reports
+// false, may not be queued, as they access the whole keyspace.
+var transactionKeys = map[string]func(args []any) ([]string, bool){
+	DEL:  allKeys,
+	ECHO: noKeys,
+	GET:  firstKey,
+	MEMORY: func(args []any) ([]string, bool) {
+		if len(args) == 0 || !isSubcommand(args[0], "USAGE") {
+			return nil, false
+		}
+
+		return keysAt(args, 1), true
+	},
+	MSET: func(args []any) ([]string, bool) {
+		keys := []string{}
+
+		for index := 0; index < len(args); index += 2 {
+			keys = append(keys, keysAt(args, index)...)
+		}
+
+		return keys, true
+	},
+	OBJECT: func(args []any) ([]string, bool) {
+		return keysAt(args, 1), true
+	},
+	PING:    noKeys,
+	PUBLISH: noKeys,
+	RENAME:  allKeys,
+	SELECT:  noKeys,
+	SET:     firstKey,
+	TYPE:    firstKey,
+}
+
+func noKeys(args []any) ([]string, bool) {
+	return nil, true
+}
+
+func firstKey(args []any) ([]string, bool) {
+	return keysAt(args, 0), true
+}
+
+func allKeys(args []any) ([]string, bool) {
+	keys := []string{}
+
+	for index := range args {
+		keys = append(keys, keysAt(args, index)...)
+	}
+
+	return keys, true
+}
+
+// keysAt returns the argument at "index" as a key, if there is one. Other
+// arguments are rejected by the command handlers.
+func keysAt(args []any, index int) []string {
+	if index >= len(args) {
+		return nil
+	}
+
+	if key, ok := args[index].([]byte); ok {
+		return []string{string(key)}
+	}
+
+	return nil
+}
+
+func isSubcommand(arg any, name string) bool {
+	subcommand, ok := arg.([]byte)
+	return ok && strings.EqualFold(string(subcommand), name)
+}
+
+// queueCommand adds a command to the transaction of "conn", failing the
+// transaction when the command cannot run in one.
+func (s *Server) queueCommand(conn *connection, command []byte, name string, args []any) {
+	keysFn, ok := transactionKeys[name]
+
+	if ok {
+		_, ok = keysFn(args)
+	}
+
+	if !ok {
+		s.rejectCommand(conn, name, resp.EncodeError(fmt.Sprintf("\"%s\" command is not allowed in a transaction", name)))
+		return
+	}
+
+	conn.multi.commands = append(conn.multi.commands, queuedCommand{command: command, name: name, args: args})
+	conn.Write(resp.EncodeSimpleString("QUEUED"))
+}
+
+// keyspace is the part of the cache command handlers use.
+type keyspace interface {
+	GetItem(key string) any
+	Inspect(key string) (cache.ItemInfo, bool)
+	SetItem(key string, value any, expiry time.Time)
+	RemoveItem(key string) bool
+}
+
+// keyspace returns the keys "conn" may access: those locked by its
+// transaction while EXEC runs it, or the whole cache.
+func (s *Server) keyspace(conn *connection) keyspace {
+	if conn.multi != nil && conn.multi.tx != nil {
+		return conn.multi.tx
+	}
+
+	return s.cache
+}
+
+// updateKeys runs "fn" with "keys" locked, like Cache.Update. Within a
+// transaction the keys are already locked by EXEC.
+func (s *Server) updateKeys(conn *connection, keys []string, fn func(tx *cache.Tx)) {
+	if conn.multi != nil && conn.multi.tx != nil {
+		fn(conn.multi.tx)
+		return
+	}
+
+	s.cache.Update(keys, fn)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransactionKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		args    []any
+		want    []string
+		allowed bool
+	}{
+		{"GET", GET, []any{[]byte("a")}, []string{"a"}, true},
+		{"SET with options", SET, []any{[]byte("a"), []byte("1"), []byte("PX"), []byte("10")}, []string{"a"}, true},
+		{"DEL", DEL, []any{[]byte("a"), []byte("b")}, []string{"a", "b"}, true},
+		{"MSET", MSET, []any{[]byte("a"), []byte("1"), []byte("b"), []byte("2")}, []string{"a", "b"}, true},
+		{"RENAME", RENAME, []any{[]byte("a"), []byte("b")}, []string{"a", "b"}, true},
+		{"OBJECT", OBJECT, []any{[]byte("ENCODING"), []byte("a")}, []string{"a"}, true},
+		{"MEMORY USAGE", MEMORY, []any{[]byte("usage"), []byte("a"), []byte("SAMPLES"), []byte("5")}, []string{"a"}, true},
+		{"MEMORY STATS", MEMORY, []any{[]byte("STATS")}, nil, false},
+		{"GET without key", GET, []any{}, nil, true},
+		{"GET with an integer", GET, []any{1}, nil, true},
+		{"PING", PING, []any{}, nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, allowed := transactionKeys[test.command](test.args)
+
+			if allowed != test.allowed {
+				t.Fatalf("got allowed %t, want %t", allowed, test.allowed)
+			}
+
+			if allowed && len(got)+len(test.want) > 0 && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got keys %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+)
+
+// Classes of keyspace events, enabled by the characters of the
+// "notify-keyspace-events" option.
+const (
+	// Publish events on "__keyspace@<db>__:<key>" channels, with the event
+	// name as message.
+	NOTIFY_KEYSPACE = 1 << iota
+	// Publish events on "__keyevent@<db>__:<event>" channels, with the key
+	// as message.
+	NOTIFY_KEYEVENT
+	// Commands that are not type specific, like DEL, EXPIRE and RENAME.
+	NOTIFY_GENERIC
+	NOTIFY_STRING
+	NOTIFY_LIST
+	NOTIFY_SET
+	NOTIFY_HASH
+	NOTIFY_ZSET
+	NOTIFY_EXPIRED
+	NOTIFY_EVICTED
+	NOTIFY_STREAM
+	// Reads of keys that do not exist.
+	NOTIFY_KEY_MISS
+	// Keys added to the dataset.
+	NOTIFY_NEW
+	// The classes "A" stands for, which leave out key misses and new keys.
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH |
+		NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM
+)
+
+var notifyFlagChars = map[rune]int{
+	'K': NOTIFY_KEYSPACE,
+	'E': NOTIFY_KEYEVENT,
+	'g': NOTIFY_GENERIC,
+	'$': NOTIFY_STRING,
+	'l': NOTIFY_LIST,
+	's': NOTIFY_SET,
+	'h': NOTIFY_HASH,
+	'z': NOTIFY_ZSET,
+	'x': NOTIFY_EXPIRED,
+	'e': NOTIFY_EVICTED,
+	't': NOTIFY_STREAM,
+	'm': NOTIFY_KEY_MISS,
+	'n': NOTIFY_NEW,
+	'A': NOTIFY_ALL,
+}
+
+// The classes of the events reported by the cache.
+var cacheEventClasses = map[string]int{
+	cache.EVENT_NEW:      NOTIFY_NEW,
+	cache.EVENT_KEY_MISS: NOTIFY_KEY_MISS,
+	cache.EVENT_EXPIRED:  NOTIFY_EXPIRED,
+	cache.EVENT_EVICTED:  NOTIFY_EVICTED,
+}
+
+// parseNotifyFlags parses the value of the "notify-keyspace-events" option.
+func parseNotifyFlags(value string) (int, error) {
+	flags := 0
+
+	for _, char := range value {
+		flag, ok := notifyFlagChars[char]
+
+		if !ok {
+			return 0, fmt.Errorf("invalid notify-keyspace-events \"%s\"", value)
+		}
+
+		flags |= flag
+	}
+
+	return flags, nil
+}
+
+// notifyKeyspaceEvent publishes "event" on "key" to the subscribers of its
+// keyspace and keyevent channels, if events of "class" are enabled.
+func (s *Server) notifyKeyspaceEvent(class int, event string, key string) {
+	flags := s.notifyFlags
+
+	if flags&class == 0 {
+		return
+	}
+
+	// todo: support multiple logical databases
+	if flags&NOTIFY_KEYSPACE != 0 {
+		s.pubsub.publish("__keyspace@0__:"+key, event)
+	}
+
+	if flags&NOTIFY_KEYEVENT != 0 {
+		s.pubsub.publish("__keyevent@0__:"+event, key)
+	}
+}
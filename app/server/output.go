@@ -0,0 +1,227 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// The classes of clients of the "client-output-buffer-limit" option.
+const (
+	OUTPUT_BUFFER_CLASS_NORMAL  = "normal"
+	OUTPUT_BUFFER_CLASS_REPLICA = "replica"
+	OUTPUT_BUFFER_CLASS_PUBSUB  = "pubsub"
+)
+
+// outputBufferLimit bounds the data queued for a client. Clients are
+// disconnected once their queue reaches the hard limit, or stays above the
+// soft limit for "softSeconds". Limits of zero are disabled.
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds int
+}
+
+// The limits of classes the "client-output-buffer-limit" option leaves out.
+var defaultOutputBufferLimits = map[string]outputBufferLimit{
+	OUTPUT_BUFFER_CLASS_NORMAL:  {},
+	OUTPUT_BUFFER_CLASS_REPLICA: {hard: 256 * 1024 * 1024, soft: 64 * 1024 * 1024, softSeconds: 60},
+	OUTPUT_BUFFER_CLASS_PUBSUB:  {hard: 32 * 1024 * 1024, soft: 8 * 1024 * 1024, softSeconds: 60},
+}
+
+// parseOutputBufferLimits parses the value of the "client-output-buffer-limit"
+// option, made of "<class> <hard> <soft> <soft seconds>" groups. Classes
+// that are not listed keep their default limits.
+func parseOutputBufferLimits(value string) (map[string]outputBufferLimit, error) {
+	limits := maps.Clone(defaultOutputBufferLimits)
+	fields := strings.Fields(value)
+
+	if len(fields)%4 != 0 {
+		return nil, fmt.Errorf("invalid client-output-buffer-limit \"%s\"", value)
+	}
+
+	for index := 0; index < len(fields); index += 4 {
+		class := fields[index]
+
+		// "slave" is the name older configurations use.
+		if class == "slave" {
+			class = OUTPUT_BUFFER_CLASS_REPLICA
+		}
+
+		if _, ok := defaultOutputBufferLimits[class]; !ok {
+			return nil, fmt.Errorf("invalid client-output-buffer-limit class \"%s\"", fields[index])
+		}
+
+		hard, hardErr := utils.ParseMemorySize(fields[index+1])
+		soft, softErr := utils.ParseMemorySize(fields[index+2])
+		softSeconds, secondsErr := strconv.Atoi(fields[index+3])
+
+		if hardErr != nil || softErr != nil || secondsErr != nil || hard < 0 || soft < 0 || softSeconds < 0 {
+			return nil, fmt.Errorf("invalid client-output-buffer-limit \"%s\"", value)
+		}
+
+		limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: softSeconds}
+	}
+
+	return limits, nil
+}
+
+// outputBuffer queues data written to a connection by a separate goroutine,
+// so that whoever produces the data never waits on the network. Its size is
+// the number of bytes queued or being written.
+type outputBuffer struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	limit   outputBufferLimit
+	pending [][]byte
+	size    int64
+	// softLimitSince is when the size went over the soft limit.
+	softLimitSince time.Time
+	closed         bool
+	// finished is set once no more data is expected, after which the writer
+	// stops when the queued data is written.
+	finished bool
+}
+
+func newOutputBuffer(limit outputBufferLimit) *outputBuffer {
+	b := &outputBuffer{limit: limit}
+	b.cond = sync.NewCond(&b.mu)
+
+	return b
+}
+
+// push queues "data", which is discarded once the buffer is closed. It
+// returns false when the data takes the buffer over its limits, which
+// closes it, in which case the connection should be dropped.
+func (b *outputBuffer) push(data []byte) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return true
+	}
+
+	b.size += int64(len(data))
+
+	if b.overLimit(time.Now()) {
+		b.closeLocked()
+		return false
+	}
+
+	b.pending = append(b.pending, data)
+	b.cond.Broadcast()
+
+	return true
+}
+
+// overLimit reports whether the buffer is over its hard limit, or has been
+// over its soft limit for too long. It must be called with b.mu held.
+func (b *outputBuffer) overLimit(now time.Time) bool {
+	if b.limit.hard > 0 && b.size >= b.limit.hard {
+		return true
+	}
+
+	if b.limit.soft == 0 || b.size < b.limit.soft {
+		b.softLimitSince = time.Time{}
+		return false
+	}
+
+	if b.softLimitSince.IsZero() {
+		b.softLimitSince = now
+	}
+
+	return now.Sub(b.softLimitSince) > time.Duration(b.limit.softSeconds)*time.Second
+}
+
+// setLimit changes the limits of the buffer, e.g. when a client subscribes
+// to a channel. They apply from the next push.
+func (b *outputBuffer) setLimit(limit outputBufferLimit) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = limit
+}
+
+// pop waits for data to be queued and returns all of it. It returns false
+// once the buffer is closed, or finished and empty. The caller must release
+// the data it wrote.
+func (b *outputBuffer) pop() ([][]byte, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.pending) == 0 && !b.closed && !b.finished {
+		b.cond.Wait()
+	}
+
+	if b.closed || len(b.pending) == 0 {
+		return nil, false
+	}
+
+	pending := b.pending
+	b.pending = nil
+
+	return pending, true
+}
+
+// release removes "n" bytes that were written from the size of the buffer.
+func (b *outputBuffer) release(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.size -= int64(n)
+
+	if b.size == 0 {
+		b.cond.Broadcast()
+	}
+}
+
+// waitFlushed waits until the queued data is written, or the buffer is
+// closed.
+func (b *outputBuffer) waitFlushed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for b.size > 0 && !b.closed {
+		b.cond.Wait()
+	}
+}
+
+// finish lets the writer stop once the queued data is written.
+func (b *outputBuffer) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.finished = true
+	b.cond.Broadcast()
+}
+
+// close discards the queued data and wakes up the writer.
+func (b *outputBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closeLocked()
+}
+
+func (b *outputBuffer) closeLocked() {
+	b.closed = true
+	b.pending = nil
+	b.cond.Broadcast()
+}
+
+// updateOutputBufferLimit applies the limits of the class of "conn", which
+// is "pubsub" while it is subscribed to a channel or a pattern.
+func (s *Server) updateOutputBufferLimit(conn *connection) {
+	class := OUTPUT_BUFFER_CLASS_NORMAL
+
+	if s.pubsub.count(conn) > 0 {
+		class = OUTPUT_BUFFER_CLASS_PUBSUB
+	}
+
+	conn.output.setLimit(s.outputBufferLimits[class])
+}
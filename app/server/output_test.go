@@ -0,0 +1,131 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOutputBufferLimits(t *testing.T) {
+	limits, err := parseOutputBufferLimits("slave 1mb 512kb 10")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := (outputBufferLimit{hard: 1024 * 1024, soft: 512 * 1024, softSeconds: 10}); limits[OUTPUT_BUFFER_CLASS_REPLICA] != want {
+		t.Errorf("got replica limit %+v, want %+v", limits[OUTPUT_BUFFER_CLASS_REPLICA], want)
+	}
+
+	if limits[OUTPUT_BUFFER_CLASS_PUBSUB] != defaultOutputBufferLimits[OUTPUT_BUFFER_CLASS_PUBSUB] {
+		t.Errorf("got pubsub limit %+v, want the default", limits[OUTPUT_BUFFER_CLASS_PUBSUB])
+	}
+
+	for _, value := range []string{"replica 1mb 1mb", "master 0 0 0", "pubsub 1mb -1 0", "normal 0 0 x"} {
+		if _, err := parseOutputBufferLimits(value); err == nil {
+			t.Errorf("got no error for \"%s\"", value)
+		}
+	}
+}
+
+func TestOutputBufferHardLimit(t *testing.T) {
+	b := newOutputBuffer(outputBufferLimit{hard: 10})
+
+	if !b.push(make([]byte, 6)) {
+		t.Fatal("got push over the limit below the hard limit")
+	}
+
+	pending, ok := b.pop()
+
+	if !ok || len(pending) != 1 {
+		t.Fatalf("got %d pending writes, want 1", len(pending))
+	}
+
+	// Data popped but not released yet still counts against the limit.
+	if b.push(make([]byte, 4)) {
+		t.Error("got push below the limit, want the hard limit reached")
+	}
+
+	// Only the push that reaches the limit reports it.
+	if !b.push([]byte("x")) {
+		t.Error("got the limit reported again by a closed buffer")
+	}
+
+	if _, ok := b.pop(); ok {
+		t.Error("got data from a closed buffer")
+	}
+}
+
+func TestOutputBufferSoftLimit(t *testing.T) {
+	b := newOutputBuffer(outputBufferLimit{soft: 10, softSeconds: 60})
+	now := time.Now()
+	b.size = 10
+
+	if b.overLimit(now) {
+		t.Fatal("got over the limit as soon as the soft limit is reached")
+	}
+
+	if b.overLimit(now.Add(60 * time.Second)) {
+		t.Error("got over the limit before the soft limit duration")
+	}
+
+	// Going back below the soft limit resets the duration.
+	b.size = 9
+	b.overLimit(now.Add(61 * time.Second))
+	b.size = 10
+
+	if b.overLimit(now.Add(62 * time.Second)) {
+		t.Error("got over the limit right after going back over the soft limit")
+	}
+
+	if !b.overLimit(now.Add(123 * time.Second)) {
+		t.Error("got under the limit after staying over the soft limit")
+	}
+}
+
+func TestOutputBufferFinish(t *testing.T) {
+	b := newOutputBuffer(outputBufferLimit{})
+	b.push([]byte("a"))
+	b.push([]byte("b"))
+	b.finish()
+
+	// The data queued before finishing is still written.
+	pending, ok := b.pop()
+
+	if !ok || len(pending) != 2 {
+		t.Fatalf("got %d pending writes, want 2", len(pending))
+	}
+
+	flushedC := make(chan struct{})
+
+	go func() {
+		b.waitFlushed()
+		close(flushedC)
+	}()
+
+	select {
+	case <-flushedC:
+		t.Fatal("got flushed before the data was released")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	b.release(2)
+	<-flushedC
+
+	if _, ok := b.pop(); ok {
+		t.Error("got data from a finished and flushed buffer")
+	}
+}
+
+func TestOutputBufferSetLimit(t *testing.T) {
+	b := newOutputBuffer(outputBufferLimit{})
+
+	if !b.push(make([]byte, 100)) {
+		t.Fatal("got push over the limit without limits")
+	}
+
+	b.setLimit(outputBufferLimit{hard: 101})
+
+	if b.push([]byte("x")) {
+		t.Error("got push below the limit, want the new hard limit reached")
+	}
+}
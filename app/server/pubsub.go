@@ -0,0 +1,165 @@
+package server
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// The commands a connection may send while it is subscribed to a channel or
+// a pattern.
+var subscribedModeCommands = []string{PING, PSUBSCRIBE, PUNSUBSCRIBE, SUBSCRIBE, UNSUBSCRIBE}
+
+// pubSub tracks the channels and patterns connections are subscribed to.
+type pubSub struct {
+	mu sync.Mutex
+	// channels and patterns map to the connections subscribed to them.
+	channels map[string]map[*connection]struct{}
+	patterns map[string]map[*connection]struct{}
+}
+
+func newPubSub() *pubSub {
+	return &pubSub{
+		channels: map[string]map[*connection]struct{}{},
+		patterns: map[string]map[*connection]struct{}{},
+	}
+}
+
+// registry returns the subscribers and the subscriptions of "conn" for
+// channels or for patterns. It must be called with ps.mu held.
+func (ps *pubSub) registry(conn *connection, pattern bool) (map[string]map[*connection]struct{}, map[string]struct{}) {
+	if pattern {
+		return ps.patterns, conn.patterns
+	}
+
+	return ps.channels, conn.channels
+}
+
+// subscribe subscribes "conn" to a channel or a pattern, and returns the
+// number of subscriptions of "conn".
+func (ps *pubSub) subscribe(conn *connection, name string, pattern bool) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscribers, subscriptions := ps.registry(conn, pattern)
+
+	if _, ok := subscribers[name]; !ok {
+		subscribers[name] = map[*connection]struct{}{}
+	}
+
+	subscribers[name][conn] = struct{}{}
+	subscriptions[name] = struct{}{}
+
+	return len(conn.channels) + len(conn.patterns)
+}
+
+// unsubscribe unsubscribes "conn" from a channel or a pattern, and returns
+// the number of subscriptions of "conn" left.
+func (ps *pubSub) unsubscribe(conn *connection, name string, pattern bool) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	subscribers, subscriptions := ps.registry(conn, pattern)
+
+	delete(subscriptions, name)
+	delete(subscribers[name], conn)
+
+	if len(subscribers[name]) == 0 {
+		delete(subscribers, name)
+	}
+
+	return len(conn.channels) + len(conn.patterns)
+}
+
+// subscriptions returns the channels or the patterns "conn" is subscribed
+// to.
+func (ps *pubSub) subscriptions(conn *connection, pattern bool) []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	_, subscriptions := ps.registry(conn, pattern)
+
+	return slices.Sorted(maps.Keys(subscriptions))
+}
+
+// count returns the number of subscriptions of "conn".
+func (ps *pubSub) count(conn *connection) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return len(conn.channels) + len(conn.patterns)
+}
+
+// counts returns the number of channels and of patterns with subscribers.
+func (ps *pubSub) counts() (int, int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	return len(ps.channels), len(ps.patterns)
+}
+
+// unsubscribeAll removes every subscription of a connection that closed.
+func (ps *pubSub) unsubscribeAll(conn *connection) {
+	for _, channel := range ps.subscriptions(conn, false) {
+		ps.unsubscribe(conn, channel, false)
+	}
+
+	for _, pattern := range ps.subscriptions(conn, true) {
+		ps.unsubscribe(conn, pattern, true)
+	}
+}
+
+// publish sends "message" to the subscribers of "channel" and of the
+// patterns matching it, and returns the number of messages sent.
+func (ps *pubSub) publish(channel string, message string) int {
+	type delivery struct {
+		conn    *connection
+		payload []byte
+	}
+
+	var deliveries []delivery
+
+	ps.mu.Lock()
+
+	if subscribers, ok := ps.channels[channel]; ok {
+		payload := resp.EncodeArray([][]byte{
+			resp.EncodeBulkString("message"),
+			resp.EncodeBulkString(channel),
+			resp.EncodeBulkString(message),
+		})
+
+		for conn := range subscribers {
+			deliveries = append(deliveries, delivery{conn, payload})
+		}
+	}
+
+	for pattern, subscribers := range ps.patterns {
+		if !utils.MatchPattern(pattern, channel) {
+			continue
+		}
+
+		payload := resp.EncodeArray([][]byte{
+			resp.EncodeBulkString("pmessage"),
+			resp.EncodeBulkString(pattern),
+			resp.EncodeBulkString(channel),
+			resp.EncodeBulkString(message),
+		})
+
+		for conn := range subscribers {
+			deliveries = append(deliveries, delivery{conn, payload})
+		}
+	}
+
+	ps.mu.Unlock()
+
+	// Messages are only queued, as events may be published with the keys
+	// they are about locked, and slow subscribers must not hold them.
+	for _, delivery := range deliveries {
+		delivery.conn.send(delivery.payload)
+	}
+
+	return len(deliveries)
+}
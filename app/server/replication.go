@@ -0,0 +1,886 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+var errReplicationStopped = errors.New("replication stopped")
+
+const (
+	// The secondary replication ID used when there is no previous history.
+	EMPTY_REPLICATION_ID = "0000000000000000000000000000000000000000"
+	// How long a replica waits before reconnecting to its master.
+	MASTER_RECONNECT_INTERVAL = time.Second
+)
+
+// streamReader counts the bytes read from the master link and, once
+// recording starts, keeps a copy of them until they are consumed. This lets
+// a replica feed the exact bytes of every command it processes to its own
+// backlog and replicas.
+type streamReader struct {
+	r         io.Reader
+	n         int64
+	recorded  []byte
+	recording bool
+	// lastIO is the Unix time in nanoseconds of the last read that returned
+	// data. It is read by clients, hence the atomic access.
+	lastIO atomic.Int64
+}
+
+func (sr *streamReader) Read(p []byte) (int, error) {
+	n, err := sr.r.Read(p)
+	sr.n += int64(n)
+
+	if n > 0 {
+		sr.lastIO.Store(time.Now().UnixNano())
+	}
+
+	if sr.recording {
+		sr.recorded = append(sr.recorded, p[:n]...)
+	}
+
+	return n, err
+}
+
+// eofMarkReader reads a diskless RDB payload up to the mark that ends it,
+// without consuming any of the replication stream that follows.
+type eofMarkReader struct {
+	r    *bufio.Reader
+	mark []byte
+	done bool
+}
+
+func (er *eofMarkReader) Read(p []byte) (int, error) {
+	if er.done {
+		return 0, io.EOF
+	}
+
+	// Wait for enough data to tell whether it holds the mark, but never for
+	// more, since the master may have nothing to send after it.
+	if _, err := er.r.Peek(len(er.mark)); err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	buf, _ := er.r.Peek(er.r.Buffered())
+
+	if index := bytes.Index(buf, er.mark); index >= 0 && index <= len(p) {
+		n := copy(p, buf[:index])
+		er.r.Discard(index + len(er.mark))
+		er.done = true
+
+		return n, nil
+	}
+
+	// The bytes that cannot be the beginning of the mark are payload.
+	n := copy(p, buf[:len(buf)-len(er.mark)+1])
+	er.r.Discard(n)
+
+	return n, nil
+}
+
+// replica is a connection to a replica of this server.
+type replica struct {
+	conn *connection
+	// output holds replication stream data waiting to be written to the
+	// replica, bounded by the "replica" class of "client-output-buffer-limit".
+	output *outputBuffer
+	// The replication offset last acknowledged by the replica through
+	// "REPLCONF ACK" and when it was received.
+	ackOffset int
+	ackTime   time.Time
+	// online is set once the replica receives the replication stream, as
+	// opposed to waiting for a snapshot.
+	online bool
+}
+
+// ackWaiter is a client blocked by WAIT until "numReplicas" replicas have
+// acknowledged the replication stream up to "offset".
+type ackWaiter struct {
+	offset      int
+	numReplicas int
+	doneC       chan struct{}
+}
+
+// send queues replication stream data for the replica.
+func (r *replica) send(data []byte) {
+	if !r.output.push(data) {
+		// Dropping the connection makes the replica resynchronize from scratch.
+		fmt.Printf("Replica %s is disconnected for overcoming its output buffer limit\n", r.conn.RemoteAddr())
+		r.conn.Close()
+	}
+}
+
+// writeStream writes the queued replication stream to the replica until it
+// is removed from the server or its connection fails.
+func (r *replica) writeStream() {
+	for {
+		pending, ok := r.output.pop()
+
+		if !ok {
+			return
+		}
+
+		for _, data := range pending {
+			if _, err := r.conn.writeReplicationData(data); err != nil {
+				r.conn.Close()
+				return
+			}
+
+			r.output.release(len(data))
+		}
+	}
+}
+
+// masterLink is the connection a replica receives the replication stream on.
+type masterLink struct {
+	conn   *connection
+	stream *streamReader
+	reader *bufio.Reader
+	// syncing is set while the snapshot sent by the master is loaded, and
+	// streaming once the link carries the replication stream. Both are
+	// guarded by s.mu.
+	syncing   bool
+	streaming bool
+	// writeMu serializes the acknowledgements sent by the goroutine handling
+	// the link and the replication cron.
+	writeMu sync.Mutex
+}
+
+func newMasterLink(conn net.Conn, stats *stats) *masterLink {
+	stream := &streamReader{r: conn}
+	stream.lastIO.Store(time.Now().UnixNano())
+
+	return &masterLink{
+		conn:   &connection{Conn: conn, isMaster: true, stats: stats},
+		stream: stream,
+		reader: bufio.NewReader(stream),
+	}
+}
+
+// lastIO returns when data was last received from the master.
+func (l *masterLink) lastIO() time.Time {
+	return time.Unix(0, l.stream.lastIO.Load())
+}
+
+// processed returns the number of bytes consumed from the link so far, which
+// excludes data that was read from the socket but is still buffered.
+func (l *masterLink) processed() int64 {
+	return l.stream.n - int64(l.reader.Buffered())
+}
+
+// startRecording records the bytes received from now on, including those
+// already buffered but not yet consumed.
+func (l *masterLink) startRecording() {
+	buffered, _ := l.reader.Peek(l.reader.Buffered())
+
+	l.stream.recorded = append([]byte{}, buffered...)
+	l.stream.recording = true
+}
+
+// consume returns the next "n" recorded bytes, which must have been
+// processed already.
+func (l *masterLink) consume(n int) []byte {
+	data := l.stream.recorded[:n:n]
+	l.stream.recorded = l.stream.recorded[n:]
+
+	return data
+}
+
+// write sends a command to the master without waiting for a reply.
+func (l *masterLink) write(args ...string) error {
+	argv := make([][]byte, len(args))
+
+	for index, arg := range args {
+		argv[index] = []byte(arg)
+	}
+
+	l.writeMu.Lock()
+	defer l.writeMu.Unlock()
+
+	if _, err := l.conn.Conn.Write(encodeCommand(argv)); err != nil {
+		return fmt.Errorf("failed to send \"%s\" command: %w", strings.Join(args, " "), err)
+	}
+
+	return nil
+}
+
+// sendAck tells the master the replication offset processed so far.
+func (l *masterLink) sendAck(offset int) error {
+	return l.write("REPLCONF", "ACK", strconv.Itoa(offset))
+}
+
+// sendCommand sends a command to the master and returns its decoded reply.
+func (l *masterLink) sendCommand(args ...string) (any, error) {
+	if err := l.write(args...); err != nil {
+		return nil, err
+	}
+
+	reply, err := resp.Decode(l.reader)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive \"%s\" response from master server: %w", strings.Join(args, " "), err)
+	}
+
+	return reply, nil
+}
+
+// expectReply sends a command to the master and checks that it replies with
+// the simple string "expected".
+func (l *masterLink) expectReply(expected string, args ...string) error {
+	reply, err := l.sendCommand(args...)
+
+	if err != nil {
+		return err
+	}
+
+	if str, ok := reply.([]byte); !ok || !bytes.EqualFold(str, []byte(expected)) {
+		return fmt.Errorf("unexpected \"%s\" response from master server: %v", strings.Join(args, " "), reply)
+	}
+
+	return nil
+}
+
+// encodeCommand encodes a command as an array of bulk strings, the way
+// clients send them.
+func encodeCommand(argv [][]byte) []byte {
+	entries := make([][]byte, len(argv))
+
+	for index, arg := range argv {
+		entries[index] = resp.EncodeBulkString(string(arg))
+	}
+
+	return resp.EncodeArray(entries)
+}
+
+// addReplica registers "conn" as a replica, which from then on receives
+// every write propagated by the server. It must be called with s.mu held.
+func (s *Server) addReplica(conn *connection) *replica {
+	r := &replica{
+		conn:   conn,
+		output: newOutputBuffer(s.outputBufferLimits[OUTPUT_BUFFER_CLASS_REPLICA]),
+	}
+
+	s.replicas[conn] = r
+
+	return r
+}
+
+func (s *Server) removeReplica(conn *connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if r, ok := s.replicas[conn]; ok {
+		delete(s.replicas, conn)
+		r.output.close()
+
+		if len(s.replicas) == 0 {
+			s.backlogIdleSince = time.Now()
+		}
+	}
+}
+
+// disconnectReplicas drops every replica, forcing them to resynchronize. It
+// must be called with s.mu held.
+func (s *Server) disconnectReplicas() {
+	for conn := range s.replicas {
+		conn.Close()
+	}
+}
+
+// createBacklog starts keeping the replication stream history. It must be
+// called with s.mu held.
+func (s *Server) createBacklog() {
+	s.backlog = newBacklog(int(s.config.GetBytes("repl-backlog-size")))
+	s.backlogIdleSince = time.Now()
+}
+
+// clearReplicationId2 forgets the history we shared with a previous master.
+// It must be called with s.mu held.
+func (s *Server) clearReplicationId2() {
+	s.replicationId2 = EMPTY_REPLICATION_ID
+	s.secondReplicationOffset = -1
+}
+
+// shiftReplicationId switches to a new replication ID while remembering the
+// current one as the secondary ID, so replicas that followed the current
+// history up to this point can still partially resynchronize with us. It
+// must be called with s.mu held.
+func (s *Server) shiftReplicationId(replicationId string) {
+	s.replicationId2 = s.replicationId
+	s.secondReplicationOffset = s.replicationOffset + 1
+	s.replicationId = replicationId
+}
+
+// canContinue reports whether a replica whose last processed byte precedes
+// "psyncOffset" in the history identified by "replicationId" can resume the
+// stream from our backlog. It must be called with s.mu held.
+func (s *Server) canContinue(replicationId string, psyncOffset int) bool {
+	if replicationId != s.replicationId && (replicationId != s.replicationId2 || psyncOffset > s.secondReplicationOffset) {
+		return false
+	}
+
+	if s.backlog == nil {
+		return false
+	}
+
+	firstByteOffset := s.replicationOffset - s.backlog.length + 1
+
+	return psyncOffset >= firstByteOffset && psyncOffset <= s.replicationOffset+1
+}
+
+// propagate appends a write command executed on "conn" to the AOF and the
+// replication stream. "argv" is the command in the form replicas should apply it, which
+// may differ from the one the client sent. It must be called with s.writeMu
+// held, so commands are propagated in the order they were executed.
+func (s *Server) propagate(conn *connection, argv [][]byte) {
+	// EXEC propagates the writes of a transaction once its keys are unlocked.
+	if conn.multi != nil && conn.multi.tx != nil {
+		conn.multi.writes = append(conn.multi.writes, argv)
+		return
+	}
+
+	s.propagateCommands(conn, [][][]byte{argv})
+}
+
+// propagateCommands propagates write commands like propagate, feeding them
+// to the replication stream at once so nothing gets in between them.
+func (s *Server) propagateCommands(conn *connection, commands [][][]byte) {
+	for _, argv := range commands {
+		s.appendToAof(conn, argv)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Replicas relay the stream of their own master rather than their writes.
+	if s.role != "master" || (s.backlog == nil && len(s.replicas) == 0) {
+		return
+	}
+
+	if conn.db != s.replicationDb {
+		s.feedReplicas(encodeCommand([][]byte{[]byte(SELECT), []byte(strconv.Itoa(conn.db))}))
+		s.replicationDb = conn.db
+	}
+
+	for _, argv := range commands {
+		s.feedReplicas(encodeCommand(argv))
+	}
+
+	conn.writeOffset = s.replicationOffset
+}
+
+// feedReplicas appends data to the replication stream: it advances the
+// replication offset, adds the data to the backlog and sends it to every
+// replica. It must be called with s.mu held.
+func (s *Server) feedReplicas(data []byte) {
+	s.replicationOffset += len(data)
+
+	if s.backlog != nil {
+		s.backlog.write(data)
+	}
+
+	for _, r := range s.replicas {
+		r.send(data)
+	}
+}
+
+// countAckedReplicas returns the number of replicas that acknowledged the
+// replication stream up to "offset". It must be called with s.mu held.
+func (s *Server) countAckedReplicas(offset int) int {
+	count := 0
+
+	for _, r := range s.replicas {
+		if r.ackOffset >= offset {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+// countGoodReplicas returns the number of online replicas that sent an
+// acknowledgement within the last "min-replicas-max-lag" seconds. It must be
+// called with s.mu held.
+func (s *Server) countGoodReplicas() int {
+	maxLag := time.Duration(s.config.GetInt("min-replicas-max-lag")) * time.Second
+	count := 0
+
+	for _, r := range s.replicas {
+		if r.online && !r.ackTime.IsZero() && time.Since(r.ackTime) <= maxLag {
+			count += 1
+		}
+	}
+
+	return count
+}
+
+// addAckWaiter registers a client blocked by WAIT and asks every replica to
+// acknowledge the stream received so far. It must be called with s.mu held.
+func (s *Server) addAckWaiter(offset, numReplicas int) *ackWaiter {
+	waiter := &ackWaiter{
+		offset:      offset,
+		numReplicas: numReplicas,
+		doneC:       make(chan struct{}),
+	}
+
+	s.ackWaiters = append(s.ackWaiters, waiter)
+
+	if len(s.replicas) > 0 {
+		s.feedReplicas(encodeCommand([][]byte{[]byte(REPLCONF), []byte("GETACK"), []byte("*")}))
+	}
+
+	return waiter
+}
+
+// removeAckWaiter unregisters a client blocked by WAIT. It must be called
+// with s.mu held.
+func (s *Server) removeAckWaiter(waiter *ackWaiter) {
+	s.ackWaiters = slices.DeleteFunc(s.ackWaiters, func(w *ackWaiter) bool {
+		return w == waiter
+	})
+}
+
+// acknowledge records the offset a replica acknowledged and unblocks the
+// WAIT calls that have enough acknowledgements. It must be called with s.mu
+// held.
+func (s *Server) acknowledge(conn *connection, offset int) {
+	r, ok := s.replicas[conn]
+
+	if !ok {
+		return
+	}
+
+	r.ackOffset = max(r.ackOffset, offset)
+	r.ackTime = time.Now()
+
+	s.ackWaiters = slices.DeleteFunc(s.ackWaiters, func(w *ackWaiter) bool {
+		if s.countAckedReplicas(w.offset) < w.numReplicas {
+			return false
+		}
+
+		close(w.doneC)
+		return true
+	})
+}
+
+// sendAck tells our master the replication offset processed so far. It is
+// a no-op until the master link carries the replication stream.
+func (s *Server) sendAck() {
+	s.mu.Lock()
+	link, offset := s.masterLink, s.replicationOffset
+	streaming := link != nil && link.streaming
+	s.mu.Unlock()
+
+	if !streaming {
+		return
+	}
+
+	// A failed write is noticed by the goroutine reading from the link.
+	link.sendAck(offset)
+}
+
+// replicationCron performs the periodic replication chores until the server
+// stops.
+func (s *Server) replicationCron() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stoppedC:
+			return
+
+		case <-ticker.C:
+			s.mu.Lock()
+
+			// Replicas keep their backlog, since they may be promoted and have
+			// to serve partial resynchronizations to the other replicas.
+			ttl := time.Duration(s.config.GetInt("repl-backlog-ttl")) * time.Second
+
+			if s.role == "master" && s.backlog != nil && len(s.replicas) == 0 && ttl > 0 && time.Since(s.backlogIdleSince) > ttl {
+				s.backlog = nil
+			}
+
+			s.mu.Unlock()
+
+			// Let the master know how far we got, e.g. for its WAIT calls.
+			s.sendAck()
+		}
+	}
+}
+
+// parseReplicaOf splits the "replicaof" option into a host and port.
+func parseReplicaOf(replicaOf string) (string, string, error) {
+	address := strings.Split(replicaOf, " ")
+
+	if len(address) != 2 {
+		return "", "", fmt.Errorf("replicaof option must be formatted as \"<host> <port>\"")
+	}
+
+	return address[0], address[1], nil
+}
+
+// setMaster makes the server a replica of "host:port", replacing its
+// current master if any. It must be called with s.mu held.
+func (s *Server) setMaster(host, port string) {
+	s.stopReplication()
+
+	s.role = "slave"
+	s.masterHost, s.masterPort = host, port
+	s.masterStopC = make(chan struct{})
+	// Our replicas must follow the history of the new master as well.
+	s.disconnectReplicas()
+
+	go s.replicate(host, port, s.masterStopC)
+}
+
+// unsetMaster promotes a replica to a master. Since the dataset diverges
+// from the former master's from now on, the server switches to a new
+// replication ID, keeping the current one so that the other replicas of the
+// former master can partially resynchronize with us. It must be called with
+// s.mu held.
+func (s *Server) unsetMaster() {
+	s.stopReplication()
+
+	s.role = "master"
+	s.masterHost, s.masterPort = "", ""
+	s.shiftReplicationId(generateReplicationId())
+	// Our replicas have to learn about the new replication ID, which they
+	// do by partially resynchronizing with us.
+	s.disconnectReplicas()
+	// Make sure the stream we propagate starts by selecting a database.
+	s.replicationDb = -1
+
+	if s.backlog == nil {
+		s.createBacklog()
+	}
+}
+
+// stopReplication stops replicating from the current master and drops the
+// master link. It must be called with s.mu held.
+func (s *Server) stopReplication() {
+	if s.masterStopC != nil {
+		close(s.masterStopC)
+		s.masterStopC = nil
+	}
+
+	if s.masterLink != nil {
+		s.masterLink.conn.Close()
+		s.masterLink = nil
+	}
+}
+
+// masterLinkUp reports whether the server is receiving the replication
+// stream from its master. It must be called with s.mu held.
+func (s *Server) masterLinkUp() bool {
+	return s.masterLink != nil && s.masterLink.streaming
+}
+
+// dropMasterLink closes a master link and forgets about it, unless it was
+// replaced already.
+func (s *Server) dropMasterLink(link *masterLink) {
+	link.conn.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.masterLink == link {
+		s.masterLink = nil
+	}
+}
+
+// replicate keeps the server connected to its master, reconnecting whenever
+// the link is lost, until the server stops or "stopC" is closed.
+func (s *Server) replicate(host, port string, stopC <-chan struct{}) {
+	for {
+		link, fullSync, err := s.connectToMaster(host, port, stopC)
+
+		if errors.Is(err, errReplicationStopped) {
+			return
+		}
+
+		if err != nil {
+			fmt.Printf("Failed to connect to master: %v\n", err)
+		} else {
+			s.handleMasterLink(link, fullSync, stopC)
+		}
+
+		select {
+		case <-s.stoppedC:
+			return
+
+		case <-stopC:
+			return
+
+		case <-time.After(MASTER_RECONNECT_INTERVAL):
+		}
+	}
+}
+
+// connectToMaster connects and introduces the replica to its master. It
+// reports whether the master is about to send a full snapshot, or whether
+// the stream continues from where the replica left off.
+func (s *Server) connectToMaster(host, port string, stopC <-chan struct{}) (*masterLink, bool, error) {
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to connect to master server: %w", err)
+	}
+
+	link := newMasterLink(conn, s.stats)
+
+	s.mu.Lock()
+
+	// The master changed while we were connecting.
+	select {
+	case <-stopC:
+		s.mu.Unlock()
+		conn.Close()
+
+		return nil, false, errReplicationStopped
+
+	default:
+		s.masterLink = link
+	}
+
+	s.mu.Unlock()
+
+	fullSync, err := s.performHandshake(link)
+
+	if err != nil {
+		s.dropMasterLink(link)
+		return nil, false, err
+	}
+
+	return link, fullSync, nil
+}
+
+// performHandshake introduces the replica to its master and asks to resume
+// the replication stream, reporting whether the master requires a full
+// synchronization instead.
+func (s *Server) performHandshake(link *masterLink) (bool, error) {
+	if err := link.expectReply("PONG", "PING"); err != nil {
+		return false, err
+	}
+
+	if err := link.expectReply("OK", "REPLCONF", "listening-port", strconv.Itoa(s.port)); err != nil {
+		return false, err
+	}
+
+	if err := link.expectReply("OK", "REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return false, err
+	}
+
+	// Without any history, ask for a full synchronization.
+	s.mu.Lock()
+	replicationId, psyncOffset := "?", "-1"
+
+	if s.backlog != nil {
+		replicationId, psyncOffset = s.replicationId, strconv.Itoa(s.replicationOffset+1)
+	}
+
+	s.mu.Unlock()
+
+	reply, err := link.sendCommand("PSYNC", replicationId, psyncOffset)
+
+	if err != nil {
+		return false, err
+	}
+
+	// The master replies with "FULLRESYNC <replication id> <offset>" or
+	// "CONTINUE [<replication id>]".
+	str, ok := reply.([]byte)
+	fields := strings.Fields(string(str))
+
+	if ok && len(fields) > 0 && strings.EqualFold(fields[0], "CONTINUE") {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		// The master changed its replication ID, e.g. after a failover.
+		if len(fields) > 1 && fields[1] != s.replicationId {
+			s.shiftReplicationId(fields[1])
+			// Our own replicas must follow the new history as well.
+			s.disconnectReplicas()
+		}
+
+		return false, nil
+	}
+
+	if !ok || len(fields) != 3 || !strings.EqualFold(fields[0], "FULLRESYNC") {
+		return false, fmt.Errorf("unexpected \"PSYNC\" response from master server: %v", reply)
+	}
+
+	offset, err := strconv.Atoi(fields[2])
+
+	if err != nil {
+		return false, fmt.Errorf("invalid replication offset \"%s\" received from master server", fields[2])
+	}
+
+	s.mu.Lock()
+	s.replicationId = fields[1]
+	s.replicationOffset = offset
+	// Until the snapshot is loaded, our dataset does not match the new
+	// replication ID and offset, so a failed transfer must not let the next
+	// handshake continue from them. See receiveSnapshot.
+	s.backlog = nil
+	// Our replicas follow the history we are about to drop, so they must
+	// not wait for the snapshot to load, which may fail.
+	s.disconnectReplicas()
+	s.mu.Unlock()
+
+	return true, nil
+}
+
+// receiveSnapshot reads the RDB payload the master sends after a FULLRESYNC
+// reply and replaces the contents of the cache with it. Unlike regular bulk
+// strings, the payload is not followed by a CRLF.
+func (s *Server) receiveSnapshot(link *masterLink) error {
+	var lengthLine []byte
+
+	// The master may send newlines to keep the link alive while it prepares the payload.
+	for len(lengthLine) == 0 {
+		line, err := link.reader.ReadBytes('\n')
+
+		if err != nil {
+			return fmt.Errorf("failed to read RDB payload length: %w", err)
+		}
+
+		lengthLine = bytes.TrimRight(line, "\r\n")
+	}
+
+	if lengthLine[0] != '$' {
+		return fmt.Errorf("%w: RDB payload must begin with a \"$\" prefix", resp.ErrSyntax)
+	}
+
+	var payload io.Reader
+
+	// Diskless payloads are delimited by a mark instead of being prefixed with their length.
+	if mark, ok := bytes.CutPrefix(lengthLine[1:], []byte("EOF:")); ok {
+		if len(mark) != RDB_EOF_MARK_SIZE {
+			return fmt.Errorf("%w: malformed RDB payload mark \"%s\"", resp.ErrSyntax, mark)
+		}
+
+		payload = &eofMarkReader{r: link.reader, mark: mark}
+	} else {
+		length, err := strconv.ParseInt(string(lengthLine[1:]), 10, 64)
+
+		if err != nil || length < 0 {
+			return fmt.Errorf("%w: malformed RDB payload length \"%s\"", resp.ErrSyntax, lengthLine[1:])
+		}
+
+		payload = io.LimitReader(link.reader, length)
+	}
+
+	// Clients are refused from the moment the old dataset is gone until
+	// the new one is complete.
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	s.cache.Clear()
+	s.sendInvalidations(s.tracking.invalidateAll())
+
+	if err := s.loadRdb(payload); err != nil {
+		return fmt.Errorf("failed to load RDB payload received from master server: %w", err)
+	}
+
+	// The AOF has to describe the new dataset from scratch.
+	if s.aof != nil {
+		if err := s.rewriteAof(true); err != nil {
+			return err
+		}
+	}
+
+	// Discard anything the parser did not consume so the command stream starts in the right place.
+	if _, err := io.Copy(io.Discard, payload); err != nil {
+		return fmt.Errorf("failed to read RDB payload: %w", err)
+	}
+
+	// The history we held is unrelated to the snapshot, so our replicas
+	// cannot resume from it and have to resynchronize with us.
+	s.mu.Lock()
+	s.clearReplicationId2()
+	s.disconnectReplicas()
+	s.createBacklog()
+	s.mu.Unlock()
+
+	return nil
+}
+
+// handleMasterLink loads the snapshot sent by the master when "fullSync" is
+// set and then applies every command it propagates, keeping track of the
+// replication offset, until the link fails or "stopC" is closed.
+func (s *Server) handleMasterLink(link *masterLink, fullSync bool, stopC <-chan struct{}) {
+	defer s.dropMasterLink(link)
+
+	if fullSync {
+		s.mu.Lock()
+		link.syncing = true
+		s.mu.Unlock()
+
+		if err := s.receiveSnapshot(link); err != nil {
+			fmt.Printf("Failed to synchronize with master: %v\n", err)
+			return
+		}
+	}
+
+	link.startRecording()
+
+	s.mu.Lock()
+	link.syncing = false
+	link.streaming = true
+	s.mu.Unlock()
+
+	s.sendAck()
+
+	for {
+		processed := link.processed()
+		data, err := resp.Decode(link.reader)
+
+		select {
+		case <-s.stoppedC:
+			return
+
+		case <-stopC:
+			return
+
+		default:
+			if err != nil {
+				fmt.Printf("Lost connection to master: %v\n", err)
+				return
+			}
+
+			// Relay the exact bytes received so offsets stay identical down
+			// the chain. Holding the write lock until then keeps snapshots
+			// taken for our own replicas consistent with the offset.
+			s.writeMu.Lock()
+			s.handleCommands(link.conn, data)
+
+			n := link.processed() - processed
+			s.stats.netReplicationInBytes.Add(n)
+
+			s.mu.Lock()
+			s.feedReplicas(link.consume(int(n)))
+			s.mu.Unlock()
+			s.writeMu.Unlock()
+		}
+	}
+}
+
+// generateReplicationId returns a new random replication ID.
+func generateReplicationId() string {
+	return utils.GenerateRandomString(40)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// fakeMaster accepts replicas, answers their handshake with FULLRESYNC and
+// sends them a payload that is not an RDB file. The arguments of every PSYNC
+// it receives are sent to "psyncC".
+func fakeMaster(t *testing.T, listener net.Listener, psyncC chan<- []string) {
+	for {
+		conn, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		reader := bufio.NewReader(conn)
+
+		for {
+			command, err := resp.Decode(reader)
+
+			if err != nil {
+				conn.Close()
+				break
+			}
+
+			args := []string{}
+
+			for _, arg := range command.([]any) {
+				args = append(args, string(arg.([]byte)))
+			}
+
+			switch args[0] {
+			case "PING":
+				conn.Write(resp.EncodeSimpleString("PONG"))
+
+			case "REPLCONF":
+				conn.Write(resp.EncodeSimpleString("OK"))
+
+			case "PSYNC":
+				psyncC <- args[1:]
+				conn.Write(resp.EncodeSimpleString(fmt.Sprintf("FULLRESYNC %s 100", generateReplicationId())))
+				conn.Write([]byte("$10\r\nnot an rdb"))
+
+			default:
+				t.Errorf("got unexpected command %q", args)
+			}
+		}
+	}
+}
+
+func TestFailedFullSyncIsNotContinued(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	psyncC := make(chan []string, 2)
+	go fakeMaster(t, listener, psyncC)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	s := NewServer(ServerOpts{Config: NewConfig(map[string]string{"replicaof": host + " " + port})})
+	stopC := make(chan struct{})
+
+	// A backlog is left behind by an earlier synchronization.
+	s.createBacklog()
+
+	link, fullSync, err := s.connectToMaster(host, port, stopC)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := <-psyncC; got[0] == "?" {
+		t.Fatalf("got PSYNC %q, want a partial resynchronization", got)
+	}
+
+	// Loading the payload fails.
+	s.handleMasterLink(link, fullSync, stopC)
+
+	link, _, err = s.connectToMaster(host, port, stopC)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.dropMasterLink(link)
+
+	if got := <-psyncC; got[0] != "?" || got[1] != "-1" {
+		t.Errorf("got PSYNC %q after a failed load, want a full resynchronization", got)
+	}
+}
@@ -9,8 +9,10 @@ import (
 	"os"
 	"os/signal"
 	"path"
-	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/cache"
 	"github.com/codecrafters-io/redis-starter-go/app/rdb"
@@ -18,16 +20,69 @@ import (
 	"github.com/codecrafters-io/redis-starter-go/app/utils"
 )
 
+const (
+	// The Redis version we report, e.g. in the RDB files we write.
+	REDIS_VERSION = "7.2.0"
+)
+
 type Server struct {
-	cache             *cache.Cache
-	config            *Config
-	errorC            chan error
-	listener          net.Listener
-	port              int
+	// ackWaiters are the clients blocked by WAIT.
+	ackWaiters []*ackWaiter
+	// aof is the append-only file writes are logged to, when enabled.
+	aof     *appendOnlyFile
+	backlog *backlog
+	// backlogIdleSince is when the last replica disconnected.
+	backlogIdleSince time.Time
+	cache            *cache.Cache
+	config           *Config
+	errorC           chan error
+	// lastSave is when the dataset was last loaded or saved.
+	lastSave time.Time
+	listener net.Listener
+	// loading is set while an RDB payload is loaded into the cache.
+	loading atomic.Bool
+	// The address of our master, when the server is a replica.
+	masterHost string
+	masterLink *masterLink
+	masterPort string
+	// masterStopC is closed to stop replicating from the current master.
+	masterStopC chan struct{}
+	// notifyFlags are the NOTIFY_* classes of keyspace events published,
+	// from the "notify-keyspace-events" option.
+	notifyFlags int
+	// outputBufferLimits are the limits of the "client-output-buffer-limit"
+	// option by class of client.
+	outputBufferLimits map[string]outputBufferLimit
+	// mu guards the replication state below, which is updated by the
+	// goroutine handling the master link while clients read it.
+	mu       sync.Mutex
+	port     int
+	pubsub   *pubSub
+	replicas map[*connection]*replica
+	// replicationDb is the database last selected in the replication stream.
+	replicationDb     int
 	role              string
 	replicationId     string
 	replicationOffset int
-	stoppedC          chan struct{}
+	// replicationId2 is the ID of the history we shared with our previous
+	// master, valid up to secondReplicationOffset.
+	replicationId2 string
+	// runId identifies this run of the server, unlike the replication ID
+	// which may be inherited from a master.
+	runId                   string
+	secondReplicationOffset int
+	// snapshot is the snapshot being generated that replicas starting a
+	// full synchronization can still share.
+	snapshot *snapshot
+	// The number of snapshots being generated and the outcome of the last one.
+	snapshotsInProgress int
+	lastSnapshotErr     error
+	startTime           time.Time
+	stats               *stats
+	stoppedC            chan struct{}
+	tracking            *trackingTable
+	// writeMu is held while executing write commands.
+	writeMu sync.Mutex
 }
 
 type ServerOpts struct {
@@ -42,118 +97,147 @@ func NewServer(opts ServerOpts) *Server {
 		role = "slave"
 	}
 
-	return &Server{
-		cache:             cache.NewCache(),
-		config:            opts.Config,
-		errorC:            make(chan error, 1),
-		port:              opts.Port,
-		replicationId:     utils.GenerateRandomString(40),
-		replicationOffset: 0,
-		role:              role,
-		stoppedC:          make(chan struct{}, 1),
+	now := time.Now()
+
+	s := &Server{
+		cache:                   cache.NewCache(),
+		config:                  opts.Config,
+		errorC:                  make(chan error, 1),
+		lastSave:                now,
+		port:                    opts.Port,
+		pubsub:                  newPubSub(),
+		replicas:                map[*connection]*replica{},
+		replicationDb:           -1,
+		replicationId:           generateReplicationId(),
+		replicationId2:          EMPTY_REPLICATION_ID,
+		replicationOffset:       0,
+		role:                    role,
+		runId:                   utils.GenerateRandomString(40),
+		secondReplicationOffset: -1,
+		startTime:               now,
+		stats:                   newStats(),
+		stoppedC:                make(chan struct{}, 1),
+		tracking:                newTrackingTable(),
 	}
+
+	s.cache.SetNotifier(func(event, key string) {
+		if event == cache.EVENT_EXPIRED || event == cache.EVENT_EVICTED {
+			s.signalModifiedKey(nil, key)
+		}
+
+		s.notifyKeyspaceEvent(cacheEventClasses[event], event, key)
+	})
+
+	return s
 }
 
 func (s *Server) Start() error {
-	// attempt to loadRdb file if present.
-	if err := s.loadRdbFile(); err != nil {
+	if err := checkEvictionPolicy(s.config); err != nil {
 		return err
 	}
 
-	// attempt to connect to the master server if the server is a replica
-	if err := s.connectToMaster(); err != nil {
+	notifyFlags, err := parseNotifyFlags(s.config.Get("notify-keyspace-events"))
+
+	if err != nil {
 		return err
 	}
 
-	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
-	listener, err := net.Listen("tcp", addr)
+	s.notifyFlags = notifyFlags
+	outputBufferLimits, err := parseOutputBufferLimits(s.config.Get("client-output-buffer-limit"))
 
 	if err != nil {
 		return err
 	}
 
-	sigC := make(chan os.Signal, 1)
-	signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
+	s.outputBufferLimits = outputBufferLimits
 
-	fmt.Printf("Listening on %s\n", addr)
-	s.listener = listener
+	var aof *appendOnlyFile
 
-	go s.startConnectionListener()
+	if s.config.Get("appendonly") == "yes" {
+		var err error
+		aof, err = openAof(s.aofDir(), s.config.Get("appendfilename"), s.config.Get("appendfsync"))
 
-	defer s.stop()
+		if err != nil {
+			return err
+		}
+	}
 
-	select {
-	case <-sigC:
-		return nil
-	case err := <-s.errorC:
+	if err := s.loadDataFromDisk(aof); err != nil {
 		return err
 	}
-}
 
-func (s *Server) connectToMaster() error {
-	if s.role == "master" {
-		return nil
+	if aof != nil {
+		if err := s.startAof(aof); err != nil {
+			return err
+		}
 	}
 
-	replicaOf := s.config.Get("replicaof")
+	// attempt to connect to the master server if the server is a replica
+	if s.role != "master" {
+		host, port, err := parseReplicaOf(s.config.Get("replicaof"))
+
+		if err != nil {
+			return err
+		}
 
-	if replicaOf == "" {
-		return fmt.Errorf("replicaof option is not provided")
+		s.mu.Lock()
+		s.setMaster(host, port)
+		s.mu.Unlock()
 	}
 
-	address := strings.Split(replicaOf, " ")
-	host := address[0]
-	port := address[1]
+	go s.replicationCron()
+	go s.serverCron()
 
-	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	addr := fmt.Sprintf("0.0.0.0:%d", s.port)
+	listener, err := net.Listen("tcp", addr)
 
 	if err != nil {
-		return fmt.Errorf("failed to connect to master server: %w", err)
+		return err
 	}
 
-	defer conn.Close()
+	sigC := make(chan os.Signal, 1)
+	signal.Notify(sigC, syscall.SIGTERM, syscall.SIGINT)
 
-	pingCmd := resp.EncodeArray([][]byte{resp.EncodeBulkString("PING")})
-	// PING response contains 7 bytes +PONG\r\n
-	pingResponseBuf := make([]byte, 7)
+	fmt.Printf("Listening on %s\n", addr)
+	s.listener = listener
 
-	if _, err := conn.Write(pingCmd); err != nil {
-		return fmt.Errorf("failed to PING master server: %w", err)
-	}
+	go s.startConnectionListener()
 
-	if _, err := io.ReadAtLeast(conn, pingResponseBuf, len(pingResponseBuf)); err != nil {
-		return fmt.Errorf("failed to received correct PING response from server: %w", err)
-	}
+	defer s.stop()
 
-	// generic success response contains 5 bytes +OK\r\n
-	okResponseBuf := make([]byte, 5)
+	select {
+	case <-sigC:
+		return nil
+	case err := <-s.errorC:
+		return err
+	}
+}
 
-	for _, cmd := range [][]byte{
-		resp.EncodeArray([][]byte{resp.EncodeBulkString("REPLCONF"), resp.EncodeBulkString("listening-port"), resp.EncodeBulkString(fmt.Sprintf("%d", s.port))}),
-		resp.EncodeArray([][]byte{resp.EncodeBulkString("REPLCONF"), resp.EncodeBulkString("capa"), resp.EncodeBulkString("psync2")})} {
+func (s *Server) handleIncomingConnection(netConn net.Conn) {
+	conn := newConnection(netConn, s.stats, s.outputBufferLimits[OUTPUT_BUFFER_CLASS_NORMAL])
+	go conn.writeOutput()
+	// The connection is closed once the last replies are written.
+	defer conn.output.finish()
+	defer s.removeReplica(conn)
+	defer s.pubsub.unsubscribeAll(conn)
 
-		if _, err := conn.Write(cmd); err != nil {
-			return fmt.Errorf("failed to send \"%s\" command: %w", cmd, err)
-		}
+	s.tracking.register(conn)
+	defer s.tracking.unregister(conn)
 
-		if _, err := io.ReadAtLeast(conn, okResponseBuf, len(okResponseBuf)); err != nil {
-			return fmt.Errorf("failed to received \"%s\" response from master server: %w", cmd, err)
-		}
-	}
+	s.stats.update(func(st *stats) {
+		st.connectedClients += 1
+		st.connectionsReceived += 1
+	})
 
-	if _, err := conn.Write(resp.EncodeArray([][]byte{resp.EncodeBulkString("PSYNC"), resp.EncodeBulkString("?"), resp.EncodeBulkString("-1")})); err != nil {
-		return fmt.Errorf("failed to send PSYNC command: %w", err)
-	}
+	defer s.stats.update(func(st *stats) {
+		st.connectedClients -= 1
+	})
 
-	return nil
-}
-
-func (s *Server) handleIncomingConnection(conn net.Conn) {
-	defer conn.Close()
 	reader := bufio.NewReader(conn)
+	maxBulkLength := s.config.GetBytes("proto-max-bulk-len")
 
 	for {
-		data, err := resp.Decode(reader)
+		data, err := resp.DecodeWithMaxBulkLength(reader, maxBulkLength)
 
 		select {
 		case <-s.stoppedC:
@@ -179,6 +263,60 @@ func (s *Server) handleIncomingConnection(conn net.Conn) {
 	}
 }
 
+// rdbLoader adds the entries of an RDB payload to the server's cache as
+// they are parsed.
+type rdbLoader struct {
+	rdb.NopVisitor
+	cache *cache.Cache
+}
+
+func (l *rdbLoader) OnEntry(entry rdb.DatabaseEntry) error {
+	// todo: support multiple logical databases
+	if entry.DatabaseIndex != 0 {
+		return nil
+	}
+
+	l.cache.RestoreItem(entry.Key, entry.Value, entry.Expiry, entry.Idle, entry.Freq)
+
+	return nil
+}
+
+// loadRdb parses the RDB payload read from "r" and adds its entries to the
+// server's cache.
+func (s *Server) loadRdb(r io.Reader) error {
+	parser := rdb.NewParser(rdb.ParserOpts{
+		SanitizePayload: s.config.Get("sanitize-dump-payload") == "yes",
+	})
+
+	s.loading.Store(true)
+	defer s.loading.Store(false)
+
+	if err := parser.ParseReader(r, &rdbLoader{cache: s.cache}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.lastSave = time.Now()
+	s.mu.Unlock()
+
+	s.stats.update(func(st *stats) {
+		st.dirty = 0
+	})
+
+	return nil
+}
+
+// loadDataFromDisk rebuilds the dataset from the AOF when it is enabled and
+// exists, and from the RDB file otherwise.
+func (s *Server) loadDataFromDisk(aof *appendOnlyFile) error {
+	if aof != nil && aof.manifest != nil {
+		return s.loadAof(aof)
+	}
+
+	// attempt to loadRdb file if present.
+	return s.loadRdbFile()
+}
+
 // When the "dir" and "dbfilename" options are provided
 // it parses the Redis Database file and adds the parsed database entries to the
 // server's cache.
@@ -189,21 +327,16 @@ func (s *Server) loadRdbFile() error {
 		return nil
 	}
 
-	parser := rdb.NewParser()
-
-	entries, err := parser.Parse(src)
+	fd, err := os.Open(src)
 
 	if err != nil {
-		return fmt.Errorf("failed to load \"%s\" file: %w", src, err)
+		return fmt.Errorf("failed to open \"%s\" file: %w", src, err)
 	}
 
-	for _, entry := range entries {
-		// todo: support multiple logical databases
-		if entry.DatabaseIndex != 0 {
-			continue
-		}
+	defer fd.Close()
 
-		s.cache.SetItem(entry.Key, entry.Value, entry.Expiry)
+	if err := s.loadRdb(fd); err != nil {
+		return fmt.Errorf("failed to load \"%s\" file: %w", src, err)
 	}
 
 	return nil
@@ -237,10 +370,51 @@ func (s *Server) startConnectionListener() {
 	}
 }
 
+// serverCron performs the periodic chores that are not related to
+// replication until the server stops.
+func (s *Server) serverCron() {
+	ticker := time.NewTicker(STATS_SAMPLE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stoppedC:
+			return
+
+		case <-ticker.C:
+			s.stats.sample()
+			s.cache.ExpireCycle()
+
+			if s.aof != nil {
+				if err := s.aof.fsyncIfDue(); err != nil {
+					fmt.Printf("%v\n", err)
+				}
+
+				percentage := s.config.GetInt("auto-aof-rewrite-percentage")
+
+				if s.aof.shouldRewrite(percentage, s.config.GetBytes("auto-aof-rewrite-min-size")) {
+					s.rewriteAof(false)
+				}
+			}
+		}
+	}
+}
+
 func (s *Server) stop() {
 	close(s.stoppedC)
 
 	if s.listener != nil {
 		s.listener.Close()
 	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.masterLink != nil {
+		s.masterLink.conn.Close()
+	}
+
+	if s.aof != nil {
+		s.aof.close()
+	}
 }
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cache"
+	"github.com/codecrafters-io/redis-starter-go/app/rdb"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+const (
+	// The length of the random delimiter that ends a diskless RDB payload.
+	RDB_EOF_MARK_SIZE = 40
+)
+
+// snapshot is a point-in-time RDB snapshot of the dataset generated for the
+// replicas performing a full synchronization. Replicas asking for one while
+// it is being generated share it.
+type snapshot struct {
+	// offset is the replication offset the snapshot corresponds to.
+	offset int
+	// diskless snapshots are streamed to the replicas as they are generated
+	// instead of being saved to a file first.
+	diskless bool
+	targets  []*snapshotTarget
+}
+
+// snapshotTarget is a replica waiting for a snapshot.
+type snapshotTarget struct {
+	replica *replica
+	// readyC is closed once the replica was sent the FULLRESYNC reply, after
+	// which the payload can follow.
+	readyC chan struct{}
+	err    error
+}
+
+func (t *snapshotTarget) Write(p []byte) (int, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+
+	n, err := t.replica.conn.writeReplicationData(p)
+	t.err = err
+
+	return n, err
+}
+
+// snapshotWriter writes a diskless payload to every target, leaving out the
+// ones whose connection fails.
+type snapshotWriter struct {
+	targets []*snapshotTarget
+}
+
+func (w *snapshotWriter) Write(p []byte) (int, error) {
+	var err error
+	written := false
+
+	for _, t := range w.targets {
+		if _, targetErr := t.Write(p); targetErr != nil {
+			err = targetErr
+		} else {
+			written = true
+		}
+	}
+
+	// Keep going as long as one of the targets is still reachable.
+	if written {
+		return len(p), nil
+	}
+
+	return 0, err
+}
+
+// writeRdb writes the contents of "data" to "w" in the RDB format. Like
+// Redis, it stores the idle time of keys under an LRU eviction "policy" and
+// their access frequency under an LFU one.
+func writeRdb(w io.Writer, data *cache.Cache, policy string) error {
+	writer := rdb.NewWriter(w)
+	now := time.Now()
+
+	if err := writer.WriteHeader(); err != nil {
+		return err
+	}
+
+	aux := [][2]string{
+		{"redis-ver", REDIS_VERSION},
+		{"redis-bits", strconv.Itoa(strconv.IntSize)},
+		{"ctime", strconv.FormatInt(now.Unix(), 10)},
+		{"aof-base", "0"},
+	}
+
+	for _, field := range aux {
+		if err := writer.WriteAux(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+
+	size, expiresSize := 0, 0
+
+	data.Range(func(key string, value any, expiry time.Time) bool {
+		if expiry.IsZero() {
+			size += 1
+		} else if expiry.After(now) {
+			size += 1
+			expiresSize += 1
+		}
+
+		return true
+	})
+
+	// todo: support multiple logical databases
+	if err := writer.WriteSelectDB(0); err != nil {
+		return err
+	}
+
+	if err := writer.WriteResizeDB(size, expiresSize); err != nil {
+		return err
+	}
+
+	var err error
+
+	opts := rdb.EntryOpts{Idle: -1, Freq: -1}
+
+	data.RangeInfo(func(key string, info cache.ItemInfo) bool {
+		if !info.Expiry.IsZero() && !info.Expiry.After(now) {
+			return true
+		}
+
+		if strings.HasSuffix(policy, "-lru") {
+			opts.Idle = info.Idle
+		} else if strings.HasSuffix(policy, "-lfu") {
+			opts.Freq = info.Freq
+		}
+
+		err = writer.WriteEntry(key, info.Value, info.Expiry, opts)
+
+		return err == nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// startSnapshot starts generating a snapshot of the current dataset for a
+// full synchronization. It must be called with s.writeMu and s.mu held, so
+// the snapshot matches the replication offset.
+func (s *Server) startSnapshot(diskless bool) *snapshot {
+	snap := &snapshot{
+		offset:   s.replicationOffset,
+		diskless: diskless,
+	}
+
+	// Make sure the stream following the snapshot starts by selecting a database.
+	s.replicationDb = -1
+	s.snapshot = snap
+	s.snapshotsInProgress += 1
+
+	go s.runSnapshot(snap, s.cache.Snapshot())
+
+	return snap
+}
+
+// joinSnapshot adds a replica to the targets of the snapshot being
+// generated, or starts a new one when it cannot be shared. The replica is
+// sent the writes made since the snapshot started out of the backlog. It
+// must be called with s.writeMu and s.mu held.
+func (s *Server) joinSnapshot(r *replica, diskless bool) (*snapshot, *snapshotTarget) {
+	snap := s.snapshot
+
+	if snap != nil && snap.diskless == diskless && s.replicationOffset-snap.offset <= s.backlog.length {
+		if s.replicationOffset > snap.offset {
+			r.send(s.backlog.tail(s.replicationOffset - snap.offset))
+		}
+	} else {
+		snap = s.startSnapshot(diskless)
+	}
+
+	target := &snapshotTarget{
+		replica: r,
+		readyC:  make(chan struct{}),
+	}
+
+	snap.targets = append(snap.targets, target)
+
+	return snap, target
+}
+
+// runSnapshot generates a snapshot and transfers it to its targets, which
+// then start receiving the replication stream.
+func (s *Server) runSnapshot(snap *snapshot, data *cache.Cache) {
+	var path string
+	var err error
+
+	policy := s.config.Get("maxmemory-policy")
+
+	if snap.diskless {
+		// Give other replicas a chance to share the transfer.
+		delay := time.Duration(s.config.GetInt("repl-diskless-sync-delay")) * time.Second
+
+		select {
+		case <-s.stoppedC:
+			return
+
+		case <-time.After(delay):
+		}
+	} else {
+		path, err = saveSnapshot(s.config.Get("dir"), data, policy)
+	}
+
+	// From now on, replicas asking for a full synchronization need a new snapshot.
+	s.mu.Lock()
+
+	if s.snapshot == snap {
+		s.snapshot = nil
+	}
+
+	targets := snap.targets
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		<-t.readyC
+	}
+
+	if err == nil && snap.diskless {
+		err = streamSnapshot(targets, data, policy)
+	} else if err == nil {
+		sendSnapshotFile(targets, path)
+		os.Remove(path)
+	}
+
+	if err != nil {
+		fmt.Printf("Failed to transfer RDB snapshot: %v\n", err)
+	}
+
+	s.mu.Lock()
+	s.snapshotsInProgress -= 1
+	s.lastSnapshotErr = err
+	s.mu.Unlock()
+
+	for _, t := range targets {
+		if err != nil || t.err != nil {
+			// The replica will retry the synchronization from scratch.
+			t.replica.conn.Close()
+			continue
+		}
+
+		s.mu.Lock()
+		t.replica.online = true
+		s.mu.Unlock()
+
+		go t.replica.writeStream()
+	}
+}
+
+// saveSnapshot writes a snapshot to a temporary file in "dir" and returns
+// its path.
+func saveSnapshot(dir string, data *cache.Cache, policy string) (string, error) {
+	fd, err := os.CreateTemp(dir, "temp-*.rdb")
+
+	if err != nil {
+		return "", fmt.Errorf("failed to create RDB file: %w", err)
+	}
+
+	defer fd.Close()
+
+	if err := writeRdb(fd, data, policy); err != nil {
+		os.Remove(fd.Name())
+		return "", fmt.Errorf("failed to write RDB file: %w", err)
+	}
+
+	return fd.Name(), nil
+}
+
+// sendSnapshotFile sends a snapshot saved to "path" to every target as a
+// bulk string without the trailing CRLF.
+func sendSnapshotFile(targets []*snapshotTarget, path string) {
+	var wg sync.WaitGroup
+
+	for _, t := range targets {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			fd, err := os.Open(path)
+
+			if err != nil {
+				t.err = err
+				return
+			}
+
+			defer fd.Close()
+
+			info, err := fd.Stat()
+
+			if err != nil {
+				t.err = err
+				return
+			}
+
+			fmt.Fprintf(t, "$%d\r\n", info.Size())
+
+			if _, err := io.Copy(t, fd); err != nil {
+				t.err = err
+			}
+		}()
+	}
+
+	wg.Wait()
+}
+
+// streamSnapshot generates a snapshot straight to the connection of every
+// target. Since its size is unknown upfront, the payload is announced as
+// "$EOF:<mark>" and followed by the same random mark.
+func streamSnapshot(targets []*snapshotTarget, data *cache.Cache, policy string) error {
+	mark := utils.GenerateRandomString(RDB_EOF_MARK_SIZE)
+	w := &snapshotWriter{targets: targets}
+
+	if _, err := fmt.Fprintf(w, "$EOF:%s\r\n", mark); err != nil {
+		return err
+	}
+
+	if err := writeRdb(w, data, policy); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, mark)
+
+	return err
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Instantaneous metrics are averaged over this many samples, taken every
+	// STATS_SAMPLE_INTERVAL.
+	STATS_METRIC_SAMPLES  = 16
+	STATS_SAMPLE_INTERVAL = 100 * time.Millisecond
+	// The number of recent calls of every command used to compute latency
+	// percentiles.
+	LATENCY_SAMPLES = 1024
+)
+
+// commandStats holds the counters of a command reported by INFO.
+type commandStats struct {
+	calls         int
+	failedCalls   int
+	rejectedCalls int
+	duration      time.Duration
+	// latencies holds the durations of the most recent calls, in a circular
+	// buffer starting at nextLatency.
+	latencies   []time.Duration
+	nextLatency int
+}
+
+// percentile returns the duration under which "p" percent of the recent
+// calls completed.
+func (cs *commandStats) percentile(p float64) time.Duration {
+	if len(cs.latencies) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(cs.latencies)
+	slices.Sort(sorted)
+
+	index := int(float64(len(sorted))*p/100+0.5) - 1
+
+	return sorted[min(max(index, 0), len(sorted)-1)]
+}
+
+// metric tracks the rate at which a counter grows.
+type metric struct {
+	lastValue int64
+	lastTime  time.Time
+	samples   [STATS_METRIC_SAMPLES]float64
+	next      int
+}
+
+func (m *metric) sample(value int64, now time.Time) {
+	if !m.lastTime.IsZero() {
+		m.samples[m.next] = float64(value-m.lastValue) / now.Sub(m.lastTime).Seconds()
+		m.next = (m.next + 1) % STATS_METRIC_SAMPLES
+	}
+
+	m.lastValue = value
+	m.lastTime = now
+}
+
+// rate returns the average rate per second over the recent samples.
+func (m *metric) rate() float64 {
+	total := 0.0
+
+	for _, sample := range m.samples {
+		total += sample
+	}
+
+	return total / STATS_METRIC_SAMPLES
+}
+
+// stats holds the counters maintained across the server and reported by
+// INFO. The network counters are updated on every read and write, hence
+// the atomic access; everything else is guarded by mu.
+type stats struct {
+	netInputBytes          atomic.Int64
+	netOutputBytes         atomic.Int64
+	netReplicationInBytes  atomic.Int64
+	netReplicationOutBytes atomic.Int64
+
+	mu                  sync.Mutex
+	commands            map[string]*commandStats
+	commandsProcessed   int64
+	connectedClients    int
+	connectionsReceived int
+	// dirty is the number of changes made to the dataset since it was last
+	// loaded or saved.
+	dirty        int
+	errorReplies int
+	errors       map[string]int
+	memoryPeak   uint64
+	syncFull     int
+	// Partial resynchronizations that were accepted or refused.
+	syncPartialOk  int
+	syncPartialErr int
+	// Samples of the counters reported as instantaneous metrics.
+	commandsMetric metric
+	inputMetric    metric
+	outputMetric   metric
+}
+
+func newStats() *stats {
+	return &stats{
+		commands: map[string]*commandStats{},
+		errors:   map[string]int{},
+	}
+}
+
+// command returns the counters of a command. It must be called with
+// st.mu held.
+func (st *stats) command(name string) *commandStats {
+	cs, ok := st.commands[name]
+
+	if !ok {
+		cs = &commandStats{}
+		st.commands[name] = cs
+	}
+
+	return cs
+}
+
+// recordCall accounts for an executed command and whether it replied with
+// an error.
+func (st *stats) recordCall(name string, duration time.Duration, failed bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	cs := st.command(name)
+	cs.calls += 1
+	cs.duration += duration
+
+	if failed {
+		cs.failedCalls += 1
+	}
+
+	if len(cs.latencies) < LATENCY_SAMPLES {
+		cs.latencies = append(cs.latencies, duration)
+	} else {
+		cs.latencies[cs.nextLatency] = duration
+		cs.nextLatency = (cs.nextLatency + 1) % LATENCY_SAMPLES
+	}
+
+	st.commandsProcessed += 1
+}
+
+// rejectCall accounts for a command refused before being executed.
+func (st *stats) rejectCall(name string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.command(name).rejectedCalls += 1
+}
+
+// recordError accounts for an error reply, "code" being its first word
+// such as "ERR" or "READONLY".
+func (st *stats) recordError(code string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.errorReplies += 1
+	st.errors[code] += 1
+}
+
+// update applies "f" to the counters guarded by st.mu.
+func (st *stats) update(f func(st *stats)) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	f(st)
+}
+
+// sample records the current value of the counters behind the
+// instantaneous metrics.
+func (st *stats) sample() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	now := time.Now()
+
+	st.commandsMetric.sample(st.commandsProcessed, now)
+	st.inputMetric.sample(st.netInputBytes.Load(), now)
+	st.outputMetric.sample(st.netOutputBytes.Load(), now)
+}
@@ -0,0 +1,446 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+const (
+	// The channel invalidation messages are published on for clients that
+	// redirect them to a RESP2 connection.
+	INVALIDATE_CHANNEL = "__redis__:invalidate"
+)
+
+// clientTracking holds the CLIENT TRACKING options of a connection.
+type clientTracking struct {
+	enabled bool
+	// bcast clients are notified of the keys matching their prefixes,
+	// instead of the keys they read.
+	bcast bool
+	// optIn clients only track the keys read right after CLIENT CACHING
+	// YES, optOut clients track every key except those read right after
+	// CLIENT CACHING NO.
+	optIn  bool
+	optOut bool
+	// noLoop clients are not notified of the keys they modified.
+	noLoop bool
+	// redirect is the ID of the client invalidations are sent to.
+	redirect int64
+	prefixes []string
+}
+
+// invalidation is an invalidation message and the connection it goes to.
+type invalidation struct {
+	conn    *connection
+	payload []byte
+}
+
+// trackingTable remembers which clients may have cached which keys, so they
+// can be told when these keys are modified.
+type trackingTable struct {
+	mu sync.Mutex
+	// clients maps the IDs of the connected clients to their connection.
+	clients map[int64]*connection
+	lastId  int64
+	// keys maps the keys read by clients in default mode to their IDs.
+	keys map[string]map[int64]struct{}
+	// items is the number of (key, client) pairs in keys.
+	items int
+	// prefixes maps the prefixes registered by clients in BCAST mode to
+	// their IDs.
+	prefixes map[string]map[int64]struct{}
+}
+
+func newTrackingTable() *trackingTable {
+	return &trackingTable{
+		clients:  map[int64]*connection{},
+		keys:     map[string]map[int64]struct{}{},
+		prefixes: map[string]map[int64]struct{}{},
+	}
+}
+
+// register assigns an ID to a new client.
+func (t *trackingTable) register(conn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lastId += 1
+	conn.id = t.lastId
+	t.clients[conn.id] = conn
+}
+
+// unregister forgets a client that closed.
+func (t *trackingTable) unregister(conn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.disableLocked(conn)
+	delete(t.clients, conn.id)
+}
+
+// enable turns on tracking for "conn" with the options of "tracking". It
+// must be called with the options validated by parseClientTracking.
+func (t *trackingTable) enable(conn *connection, tracking clientTracking) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Without RESP3 push messages, invalidations can only be sent to another
+	// connection.
+	if tracking.redirect == 0 {
+		return errors.New("\"CLIENT TRACKING ON\" command requires the \"REDIRECT\" option, as RESP3 is not supported")
+	}
+
+	if _, ok := t.clients[tracking.redirect]; !ok {
+		return errors.New("the client ID you want redirect to does not exist")
+	}
+
+	current := conn.tracking
+
+	if current.enabled {
+		if current.bcast != tracking.bcast {
+			return errors.New("you can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode")
+		}
+
+		if current.optIn != tracking.optIn || current.optOut != tracking.optOut {
+			return errors.New("you can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode")
+		}
+	}
+
+	// Prefixes are added to those of a client already tracking, and must
+	// not overlap so a key never matches two prefixes of the same client.
+	prefixes := current.prefixes
+
+	if !current.enabled {
+		prefixes = nil
+	}
+
+	for _, prefix := range tracking.prefixes {
+		for _, other := range prefixes {
+			if prefix != other && (strings.HasPrefix(prefix, other) || strings.HasPrefix(other, prefix)) {
+				return fmt.Errorf("prefix \"%s\" overlaps with prefix \"%s\"", prefix, other)
+			}
+		}
+
+		if !slices.Contains(prefixes, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	// BCAST without any prefix tracks every key.
+	if tracking.bcast && len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	for _, prefix := range prefixes {
+		if _, ok := t.prefixes[prefix]; !ok {
+			t.prefixes[prefix] = map[int64]struct{}{}
+		}
+
+		t.prefixes[prefix][conn.id] = struct{}{}
+	}
+
+	tracking.enabled = true
+	tracking.prefixes = prefixes
+	conn.tracking = tracking
+
+	return nil
+}
+
+func (t *trackingTable) disable(conn *connection) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.disableLocked(conn)
+}
+
+// disableLocked turns off tracking for "conn". The keys it read are left in
+// the table, and skipped when they are invalidated. It must be called with
+// t.mu held.
+func (t *trackingTable) disableLocked(conn *connection) {
+	for _, prefix := range conn.tracking.prefixes {
+		delete(t.prefixes[prefix], conn.id)
+
+		if len(t.prefixes[prefix]) == 0 {
+			delete(t.prefixes, prefix)
+		}
+	}
+
+	conn.tracking = clientTracking{}
+}
+
+// info returns the tracking options of "conn".
+func (t *trackingTable) info(conn *connection) clientTracking {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracking := conn.tracking
+	tracking.prefixes = slices.Clone(tracking.prefixes)
+
+	return tracking
+}
+
+// redirectBroken reports whether the client "tracking" redirects to closed.
+func (t *trackingTable) redirectBroken(tracking clientTracking) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, ok := t.clients[tracking.redirect]
+
+	return tracking.redirect != 0 && !ok
+}
+
+// remember records that "conn" read "keys", if it tracks them. "caching"
+// is set when the read follows CLIENT CACHING. When the table holds more
+// than "maxKeys" keys, random keys are invalidated to make room, and the
+// resulting invalidations are returned.
+func (t *trackingTable) remember(conn *connection, keys []string, caching bool, maxKeys int) []invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tracking := conn.tracking
+
+	if !tracking.enabled || tracking.bcast || (tracking.optIn && !caching) || (tracking.optOut && caching) {
+		return nil
+	}
+
+	for _, key := range keys {
+		if _, ok := t.keys[key]; !ok {
+			t.keys[key] = map[int64]struct{}{}
+		}
+
+		if _, ok := t.keys[key][conn.id]; !ok {
+			t.keys[key][conn.id] = struct{}{}
+			t.items += 1
+		}
+	}
+
+	var invalidations []invalidation
+
+	for maxKeys > 0 && len(t.keys) > maxKeys {
+		for key := range t.keys {
+			invalidations = append(invalidations, t.invalidateLocked(key, nil)...)
+			break
+		}
+	}
+
+	return invalidations
+}
+
+// invalidate returns the invalidations to send because "key" was modified
+// by "source", nil when it was modified by the server itself.
+func (t *trackingTable) invalidate(key string, source *connection) []invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.invalidateLocked(key, source)
+}
+
+// invalidateLocked forgets the clients that read "key" and returns the
+// invalidations to send them and to the BCAST clients whose prefixes match
+// it. It must be called with t.mu held.
+func (t *trackingTable) invalidateLocked(key string, source *connection) []invalidation {
+	var invalidations []invalidation
+	payload := encodeInvalidation([][]byte{resp.EncodeBulkString(key)})
+
+	notify := func(id int64, bcast bool) {
+		client, ok := t.clients[id]
+
+		// Clients may have disabled tracking or switched to BCAST mode since
+		// they read the key.
+		if !ok || !client.tracking.enabled || client.tracking.bcast != bcast {
+			return
+		}
+
+		if client.tracking.noLoop && client == source {
+			return
+		}
+
+		if target := t.target(client); target != nil {
+			invalidations = append(invalidations, invalidation{target, payload})
+		}
+	}
+
+	for id := range t.keys[key] {
+		notify(id, false)
+	}
+
+	t.items -= len(t.keys[key])
+	delete(t.keys, key)
+
+	for prefix, ids := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		for id := range ids {
+			notify(id, true)
+		}
+	}
+
+	return invalidations
+}
+
+// invalidateAll forgets every tracked key and returns the invalidations
+// telling every tracking client to drop its whole cache, because the
+// dataset was flushed.
+func (t *trackingTable) invalidateAll() []invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var invalidations []invalidation
+	payload := encodeInvalidation(nil)
+
+	for _, client := range t.clients {
+		if !client.tracking.enabled {
+			continue
+		}
+
+		if target := t.target(client); target != nil {
+			invalidations = append(invalidations, invalidation{target, payload})
+		}
+	}
+
+	t.keys = map[string]map[int64]struct{}{}
+	t.items = 0
+
+	return invalidations
+}
+
+// target returns the connection the invalidations of "client" are
+// redirected to, nil when it was closed. It must be called with t.mu held.
+func (t *trackingTable) target(client *connection) *connection {
+	return t.clients[client.tracking.redirect]
+}
+
+// counts returns the number of tracking clients, of tracked keys, of
+// (key, client) pairs and of BCAST prefixes.
+func (t *trackingTable) counts() (int, int, int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	clients := 0
+
+	for _, client := range t.clients {
+		if client.tracking.enabled {
+			clients += 1
+		}
+	}
+
+	return clients, len(t.keys), t.items, len(t.prefixes)
+}
+
+// encodeInvalidation encodes an invalidation message for the keys encoded
+// in "keys", or for every key when it is nil.
+func encodeInvalidation(keys [][]byte) []byte {
+	payload := resp.EncodeNullArray()
+
+	if keys != nil {
+		payload = resp.EncodeArray(keys)
+	}
+
+	return resp.EncodeArray([][]byte{
+		resp.EncodeBulkString("message"),
+		resp.EncodeBulkString(INVALIDATE_CHANNEL),
+		payload,
+	})
+}
+
+// parseClientTracking parses the arguments of CLIENT TRACKING following
+// ON or OFF.
+func parseClientTracking(args []any) (clientTracking, error) {
+	tracking := clientTracking{}
+
+	for index := 0; index < len(args); index++ {
+		arg, ok := args[index].([]byte)
+
+		if !ok {
+			return tracking, errors.New("\"CLIENT TRACKING\" command arguments must be strings")
+		}
+
+		switch option := strings.ToUpper(string(arg)); option {
+		case "BCAST":
+			tracking.bcast = true
+
+		case "OPTIN":
+			tracking.optIn = true
+
+		case "OPTOUT":
+			tracking.optOut = true
+
+		case "NOLOOP":
+			tracking.noLoop = true
+
+		case "REDIRECT", "PREFIX":
+			if index+1 >= len(args) {
+				return tracking, fmt.Errorf("\"CLIENT TRACKING\" command \"%s\" option requires a value", option)
+			}
+
+			index += 1
+			value, ok := args[index].([]byte)
+
+			if !ok {
+				return tracking, errors.New("\"CLIENT TRACKING\" command arguments must be strings")
+			}
+
+			if option == "PREFIX" {
+				tracking.prefixes = append(tracking.prefixes, string(value))
+				continue
+			}
+
+			id, err := strconv.ParseInt(string(value), 10, 64)
+
+			if err != nil || id <= 0 {
+				return tracking, errors.New("\"CLIENT TRACKING\" command \"REDIRECT\" option requires a client ID")
+			}
+
+			tracking.redirect = id
+
+		default:
+			return tracking, fmt.Errorf("unsupported \"CLIENT TRACKING\" option \"%s\"", arg)
+		}
+	}
+
+	if len(tracking.prefixes) > 0 && !tracking.bcast {
+		return tracking, errors.New("\"PREFIX\" option requires \"BCAST\" mode to be enabled")
+	}
+
+	if tracking.optIn && tracking.optOut {
+		return tracking, errors.New("you can't use both \"OPTIN\" and \"OPTOUT\"")
+	}
+
+	if tracking.bcast && (tracking.optIn || tracking.optOut) {
+		return tracking, errors.New("\"OPTIN\" and \"OPTOUT\" are not compatible with \"BCAST\"")
+	}
+
+	return tracking, nil
+}
+
+// trackReadKeys remembers that "conn" read "keys", for the clients caching
+// them.
+func (s *Server) trackReadKeys(conn *connection, keys []string, caching bool) {
+	invalidations := s.tracking.remember(conn, keys, caching, s.config.GetInt("tracking-table-max-keys"))
+	s.sendInvalidations(invalidations)
+}
+
+// signalModifiedKey tells the clients that may cache "key" that it was
+// modified by "conn", nil when the server modified it.
+func (s *Server) signalModifiedKey(conn *connection, key string) {
+	s.sendInvalidations(s.tracking.invalidate(key, conn))
+}
+
+// sendInvalidations sends invalidation messages. Like Redis, they are only
+// delivered to connections subscribed to something, which RESP2 clients
+// need to be to tell messages from replies.
+func (s *Server) sendInvalidations(invalidations []invalidation) {
+	for _, invalidation := range invalidations {
+		if s.pubsub.count(invalidation.conn) > 0 {
+			invalidation.conn.send(invalidation.payload)
+		}
+	}
+}
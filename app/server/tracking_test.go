@@ -0,0 +1,38 @@
+package server
+
+import "testing"
+
+func TestTrackingEnable(t *testing.T) {
+	table := newTrackingTable()
+	client := &connection{}
+	target := &connection{}
+	// Clients are given IDs 1 and 2.
+	table.register(client)
+	table.register(target)
+
+	tests := []struct {
+		name     string
+		tracking clientTracking
+		ok       bool
+	}{
+		{"without REDIRECT", clientTracking{}, false},
+		{"BCAST without REDIRECT", clientTracking{bcast: true}, false},
+		{"REDIRECT to a missing client", clientTracking{redirect: 3}, false},
+		{"REDIRECT", clientTracking{redirect: target.id}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			table.disable(client)
+			err := table.enable(client, test.tracking)
+
+			if (err == nil) != test.ok {
+				t.Fatalf("got error %v, want success %t", err, test.ok)
+			}
+
+			if client.tracking.enabled != test.ok {
+				t.Errorf("got tracking enabled %t, want %t", client.tracking.enabled, test.ok)
+			}
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package utils
 
 import (
+	"fmt"
 	"math/rand"
+	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -22,3 +26,184 @@ func GenerateRandomString(length int) string {
 	}
 	return string(result)
 }
+
+// ParseMemorySize converts a memory size such as "100", "1k" or "2gb" to a
+// number of bytes. Like Redis, "k", "m" and "g" are powers of 1000 while
+// "kb", "mb" and "gb" are powers of 1024.
+func ParseMemorySize(size string) (int64, error) {
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"kb", 1 << 10},
+		{"mb", 1 << 20},
+		{"gb", 1 << 30},
+		{"k", 1000},
+		{"m", 1000 * 1000},
+		{"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+
+	lower := strings.ToLower(strings.TrimSpace(size))
+	multiplier := int64(1)
+
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.ParseInt(lower, 10, 64)
+
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory size \"%s\"", size)
+	}
+
+	return value * multiplier, nil
+}
+
+// IsLocalAddress reports whether "host" resolves to an address of this
+// machine, such as a loopback address or one of its interfaces.
+func IsLocalAddress(host string) bool {
+	ips, err := net.LookupIP(host)
+
+	if err != nil {
+		return false
+	}
+
+	addrs, _ := net.InterfaceAddrs()
+
+	for _, ip := range ips {
+		if ip.IsLoopback() || ip.IsUnspecified() {
+			return true
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// FormatMemorySize converts a number of bytes to a human readable size such
+// as "1.50M", the way Redis reports memory sizes.
+func FormatMemorySize(size int64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+
+	if size < 1024 {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	value := float64(size)
+	unit := ""
+
+	for _, unit = range units {
+		value /= 1024
+
+		if value < 1024 {
+			break
+		}
+	}
+
+	return fmt.Sprintf("%.2f%s", value, unit)
+}
+
+// MatchPattern reports whether "str" matches the glob-style "pattern" the
+// way Redis matches keys: "*" matches any sequence, "?" any character,
+// "[abc]", "[^abc]" and "[a-z]" a set of characters, and a backslash
+// escapes the character that follows.
+func MatchPattern(pattern, str string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Collapse consecutive stars.
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 1 {
+				return true
+			}
+
+			for index := 0; index <= len(str); index++ {
+				if MatchPattern(pattern[1:], str[index:]) {
+					return true
+				}
+			}
+
+			return false
+
+		case '?':
+			if len(str) == 0 {
+				return false
+			}
+
+			str = str[1:]
+
+		case '[':
+			if len(str) == 0 {
+				return false
+			}
+
+			pattern = pattern[1:]
+			negate := len(pattern) > 0 && pattern[0] == '^'
+
+			if negate {
+				pattern = pattern[1:]
+			}
+
+			matched := false
+
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) > 1:
+					matched = matched || pattern[1] == str[0]
+					pattern = pattern[2:]
+
+				case len(pattern) > 2 && pattern[1] == '-':
+					start, end := min(pattern[0], pattern[2]), max(pattern[0], pattern[2])
+					matched = matched || (str[0] >= start && str[0] <= end)
+					pattern = pattern[3:]
+
+				default:
+					matched = matched || pattern[0] == str[0]
+					pattern = pattern[1:]
+				}
+			}
+
+			if matched == negate {
+				return false
+			}
+
+			str = str[1:]
+
+			// An unterminated set ends the pattern.
+			if len(pattern) == 0 {
+				return len(str) == 0
+			}
+
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+
+			fallthrough
+
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false
+			}
+
+			str = str[1:]
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(str) == 0
+}